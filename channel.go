@@ -0,0 +1,104 @@
+package conduit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// TypeChannelPrefix prefixes the stream type of every channel, keeping channel
+// names apart from plain stream types.
+const TypeChannelPrefix = "conduit.channel."
+
+// Channel is a logical connection multiplexed over a conduit connection. Each
+// channel has its own flow-control window and is closed independently of the
+// connection and of other channels.
+//
+// A Channel is a net.Conn, so it can carry any byte-oriented protocol, or it can
+// exchange whole messages with Send and Receive. Use one mode per channel; mixing
+// raw reads and writes with messages corrupts the message framing.
+type Channel struct {
+	*Stream
+	name string
+
+	sendMu  sync.Mutex
+	encoder *json.Encoder
+	recvMu  sync.Mutex
+	decoder *json.Decoder
+}
+
+var _ net.Conn = (*Channel)(nil)
+
+// NewChannel wraps a stream opened for the named channel.
+func NewChannel(stream *Stream, name string) *Channel {
+	ch := &Channel{Stream: stream, name: name}
+	ch.encoder = json.NewEncoder(stream)
+	ch.decoder = json.NewDecoder(stream)
+	return ch
+}
+
+// ChannelStreamType returns the stream type used to open the named channel.
+func ChannelStreamType(name string) string {
+	return TypeChannelPrefix + name
+}
+
+// Name returns the name the channel was opened with.
+func (c *Channel) Name() string {
+	return c.name
+}
+
+// Send writes a message to the channel. It is safe for concurrent use.
+func (c *Channel) Send(msgType string, payload interface{}, opts ...SendOption) error {
+	msg, err := NewMessage(msgType, payload, opts...)
+	if err != nil {
+		return err
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.encoder.Encode(msg)
+}
+
+// Receive reads the next message from the channel. It returns io.EOF once the
+// peer has closed the channel.
+func (c *Channel) Receive() (*Message, error) {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+	var msg Message
+	if err := c.decoder.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Close closes the channel in both directions without waiting for the peer.
+// Data the peer sends afterwards is discarded.
+func (c *Channel) Close() error {
+	c.CloseRead()
+	return c.CloseWrite()
+}
+
+// LocalAddr returns the channel's address. Both ends of a channel share it.
+func (c *Channel) LocalAddr() net.Addr {
+	return ChannelAddr{Name: c.name, ID: c.ID()}
+}
+
+// RemoteAddr returns the channel's address. Both ends of a channel share it.
+func (c *Channel) RemoteAddr() net.Addr {
+	return ChannelAddr{Name: c.name, ID: c.ID()}
+}
+
+// ChannelAddr identifies a channel within its conduit connection.
+type ChannelAddr struct {
+	Name string
+	ID   uint64
+}
+
+// Network returns "conduit".
+func (a ChannelAddr) Network() string {
+	return "conduit"
+}
+
+func (a ChannelAddr) String() string {
+	return fmt.Sprintf("%s#%d", a.Name, a.ID)
+}
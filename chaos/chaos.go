@@ -0,0 +1,259 @@
+// Package chaos provides a conduit transport that injects faults into the
+// connections of another transport, for testing how clients and servers cope
+// with slow, broken and misbehaving peers.
+//
+// Register the wrapping transport under a scheme of its own and point the
+// server and client at it:
+//
+//	t, _ := chaos.Register("chaos", "memory", &chaos.Config{
+//		Faults: []chaos.Fault{
+//			{Kind: chaos.Latency, Probability: 0.2, Delay: 50 * time.Millisecond},
+//			{Kind: chaos.Reset, Side: chaos.Client, Op: chaos.Write, After: 10},
+//		},
+//	})
+//	srv := server.NewServer(conduit.DefaultServerConfig("chaos://app"))
+//
+// Faults fire either on a schedule (the After-th matching operation of each
+// connection) or at random with the given probability, or both.
+package chaos
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// ErrInjected is returned by operations failed by a Reset or PartialWrite fault.
+var ErrInjected = errors.New("chaos: injected connection reset")
+
+// Kind selects what a fault does.
+type Kind int
+
+const (
+	// Latency delays the operation by Delay.
+	Latency Kind = iota
+	// Stall blocks the operation for Delay, or until the connection is closed or
+	// its deadline passes if Delay is zero. A passed deadline, including one set
+	// while the operation is stalled, fails it with os.ErrDeadlineExceeded, like
+	// a real timeout.
+	Stall
+	// Reset closes the connection and fails the operation.
+	Reset
+	// PartialWrite writes only the first half of the buffer, then closes the
+	// connection. It applies to writes only.
+	PartialWrite
+	// Corrupt flips the bits of one random byte of the data written or read.
+	Corrupt
+
+	numKinds
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Latency:
+		return "latency"
+	case Stall:
+		return "stall"
+	case Reset:
+		return "reset"
+	case PartialWrite:
+		return "partial_write"
+	case Corrupt:
+		return "corrupt"
+	default:
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+}
+
+// Op selects the operations a fault applies to. Zero means both.
+type Op int
+
+const (
+	// Read marks Read calls.
+	Read Op = 1 << iota
+	// Write marks Write calls.
+	Write
+)
+
+// Side selects the connections a fault applies to. Zero means both.
+type Side int
+
+const (
+	// Client marks connections opened with Dial.
+	Client Side = 1 << iota
+	// Server marks connections accepted from Listen.
+	Server
+)
+
+// Fault describes one kind of failure to inject.
+//
+// Fields:
+//   - Kind: What the fault does.
+//   - Op: Operations it applies to; zero means reads and writes.
+//   - Side: Connections it applies to; zero means client and server side.
+//   - After: Fires on the After-th matching operation of each connection.
+//     Zero disables the schedule.
+//   - Probability: Chance between 0 and 1 of firing on any matching operation.
+//   - Delay: Duration of Latency and Stall faults.
+type Fault struct {
+	Kind        Kind
+	Op          Op
+	Side        Side
+	After       int
+	Probability float64
+	Delay       time.Duration
+}
+
+func (f *Fault) matches(side Side, op Op) bool {
+	if f.Side != 0 && f.Side&side == 0 {
+		return false
+	}
+	if f.Op != 0 && f.Op&op == 0 {
+		return false
+	}
+	return f.Kind != PartialWrite || op == Write
+}
+
+// Config holds configuration options for the chaos transport.
+//
+// Fields:
+//   - Faults: Faults to inject, checked in order for every read and write.
+//   - Seed: Seed for the random source, so probabilistic runs can be replayed.
+//     Zero seeds from the clock.
+type Config struct {
+	Faults []Fault
+	Seed   int64
+}
+
+// Transport wraps another transport and injects faults into its connections.
+type Transport struct {
+	inner    conduit.Transport
+	faults   []Fault
+	rnd      *rand.Rand
+	rndMu    sync.Mutex
+	conns    map[*Conn]struct{}
+	mu       sync.Mutex
+	injected [numKinds]uint64
+}
+
+// Wrap returns a transport injecting the faults of config into the connections
+// of inner. The provided config must not be nil.
+func Wrap(inner conduit.Transport, config *Config) *Transport {
+	if config == nil {
+		panic("config cannot be nil")
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Transport{
+		inner:  inner,
+		faults: append([]Fault(nil), config.Faults...),
+		rnd:    rand.New(rand.NewSource(seed)),
+		conns:  make(map[*Conn]struct{}),
+	}
+}
+
+// Register wraps the transport registered under innerScheme and registers the
+// result under scheme. Servers only manage socket files for the unix scheme, so
+// prefer wrapping memory or tcp.
+func Register(scheme, innerScheme string, config *Config) (*Transport, error) {
+	inner, ok := conduit.LookupTransport(innerScheme)
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", innerScheme)
+	}
+	t := Wrap(inner, config)
+	conduit.RegisterTransport(scheme, t)
+	return t, nil
+}
+
+// Listen listens with the inner transport. Accepted connections are server side.
+func (t *Transport) Listen(addr string, config *tls.Config) (net.Listener, error) {
+	l, err := t.inner.Listen(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{Listener: l, transport: t}, nil
+}
+
+// Dial dials with the inner transport. Dialed connections are client side.
+func (t *Transport) Dial(ctx context.Context, addr string, config *tls.Config) (net.Conn, error) {
+	conn, err := t.inner.Dial(ctx, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return t.wrap(conn, Client), nil
+}
+
+// ResetAll closes every open connection, as if the network went down.
+func (t *Transport) ResetAll() {
+	t.mu.Lock()
+	conns := make([]*Conn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+	for _, c := range conns {
+		atomic.AddUint64(&t.injected[Reset], 1)
+		c.Close()
+	}
+}
+
+// Injected returns how many faults of kind have fired so far.
+func (t *Transport) Injected(kind Kind) uint64 {
+	if kind < 0 || kind >= numKinds {
+		return 0
+	}
+	return atomic.LoadUint64(&t.injected[kind])
+}
+
+func (t *Transport) wrap(conn net.Conn, side Side) *Conn {
+	c := &Conn{
+		Conn:      conn,
+		transport: t,
+		side:      side,
+		counts:    make([]int, len(t.faults)),
+		deadlines: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	t.mu.Lock()
+	t.conns[c] = struct{}{}
+	t.mu.Unlock()
+	return c
+}
+
+func (t *Transport) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	t.rndMu.Lock()
+	defer t.rndMu.Unlock()
+	return t.rnd.Float64() < p
+}
+
+func (t *Transport) intn(n int) int {
+	t.rndMu.Lock()
+	defer t.rndMu.Unlock()
+	return t.rnd.Intn(n)
+}
+
+type listener struct {
+	net.Listener
+	transport *Transport
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.transport.wrap(conn, Server), nil
+}
@@ -0,0 +1,193 @@
+package chaos
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn is a connection of the chaos transport. Faults are checked on every Read
+// and Write call, so the operations they count are calls rather than messages.
+type Conn struct {
+	net.Conn
+	transport *Transport
+	side      Side
+
+	mu            sync.Mutex
+	counts        []int
+	readDeadline  time.Time
+	writeDeadline time.Time
+	deadlines     chan struct{} // closed and replaced whenever a deadline changes
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Read reads from the inner connection after applying the faults due.
+func (c *Conn) Read(p []byte) (int, error) {
+	corrupt := false
+	for _, f := range c.due(Read) {
+		switch f.Kind {
+		case Latency:
+			c.sleep(f.Delay)
+		case Stall:
+			if err := c.stall(f.Delay, Read); err != nil {
+				return 0, err
+			}
+		case Reset:
+			c.Close()
+			return 0, ErrInjected
+		case Corrupt:
+			corrupt = true
+		}
+	}
+
+	n, err := c.Conn.Read(p)
+	if corrupt && n > 0 {
+		p[c.transport.intn(n)] ^= 0xff
+	}
+	return n, err
+}
+
+// Write writes to the inner connection after applying the faults due.
+func (c *Conn) Write(p []byte) (int, error) {
+	for _, f := range c.due(Write) {
+		switch f.Kind {
+		case Latency:
+			c.sleep(f.Delay)
+		case Stall:
+			if err := c.stall(f.Delay, Write); err != nil {
+				return 0, err
+			}
+		case Reset:
+			c.Close()
+			return 0, ErrInjected
+		case PartialWrite:
+			n, _ := c.Conn.Write(p[:len(p)/2])
+			c.Close()
+			return n, ErrInjected
+		case Corrupt:
+			if len(p) > 0 {
+				p = append([]byte(nil), p...)
+				p[c.transport.intn(len(p))] ^= 0xff
+			}
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+// Close closes the inner connection. Operations stalled by a fault return.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.transport.mu.Lock()
+		delete(c.transport.conns, c)
+		c.transport.mu.Unlock()
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// SetDeadline sets the read and write deadlines, which also end stalls.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.deadlineChanged()
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, which also ends stalled reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.deadlineChanged()
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline, which also ends stalled writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.deadlineChanged()
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// deadlineChanged wakes stalled operations to check the new deadlines. c.mu
+// must be held.
+func (c *Conn) deadlineChanged() {
+	close(c.deadlines)
+	c.deadlines = make(chan struct{})
+}
+
+// due counts the operation against every matching fault and returns the faults
+// that fire.
+func (c *Conn) due(op Op) []Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var due []Fault
+	for i := range c.transport.faults {
+		f := &c.transport.faults[i]
+		if !f.matches(c.side, op) {
+			continue
+		}
+		c.counts[i]++
+		if (f.After > 0 && c.counts[i] == f.After) || c.transport.chance(f.Probability) {
+			atomic.AddUint64(&c.transport.injected[f.Kind], 1)
+			due = append(due, *f)
+		}
+	}
+	return due
+}
+
+func (c *Conn) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.closed:
+	}
+}
+
+// stall blocks for d, or until the connection closes or the deadline of op
+// passes if d is zero. The deadline is checked again whenever it changes.
+func (c *Conn) stall(d time.Duration, op Op) error {
+	var done <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		done = timer.C
+	}
+
+	for {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		if op == Write {
+			deadline = c.writeDeadline
+		}
+		changed := c.deadlines
+		c.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(deadline))
+		if deadline.IsZero() {
+			timer.Stop()
+		}
+		select {
+		case <-done:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+			return os.ErrDeadlineExceeded
+		case <-c.closed:
+			timer.Stop()
+			return net.ErrClosed
+		case <-changed:
+			timer.Stop()
+		}
+	}
+}
@@ -1,15 +1,17 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
 )
 
 // Common errors
@@ -24,14 +26,26 @@ type Handler func(*Client, *conduit.Message) error
 // Client represents a Unix domain socket client. It supports sending and receiving
 // JSON-encoded messages and optionally reconnecting on connection loss.
 type Client struct {
-	config    *conduit.ClientConfig
-	conn      net.Conn
-	handlers  map[string]Handler
-	mu        sync.RWMutex
-	done      chan struct{}
-	closeOnce sync.Once
-	context   map[string]interface{}
-	contextMu sync.RWMutex
+	config         *conduit.ClientConfig
+	conn           net.Conn
+	outbox         *conduit.Outbox
+	session        *conduit.Session
+	streams        *conduit.StreamManager
+	calls          *conduit.PendingCalls
+	rpc            map[string]RequestHandler
+	schemas        map[string]*schema.Schema
+	handlers       map[string]Handler
+	defaultHandler Handler
+	streamHandlers map[string]StreamHandler
+	subscriptions  map[string]*subscription
+	mu             sync.RWMutex
+	ctx            context.Context
+	cancel         context.CancelFunc
+	done           chan struct{}
+	closeOnce      sync.Once
+	expired        uint64
+	context        map[string]interface{}
+	contextMu      sync.RWMutex
 }
 
 // NewClient creates a new Unix domain socket client with the given configuration.
@@ -42,16 +56,25 @@ func NewClient(config *conduit.ClientConfig) *Client {
 	if config == nil {
 		panic("config cannot be nil")
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		config:   config,
-		handlers: make(map[string]Handler),
-		done:     make(chan struct{}),
-		context:  make(map[string]interface{}),
+		config:         config,
+		handlers:       make(map[string]Handler),
+		streamHandlers: make(map[string]StreamHandler),
+		calls:          conduit.NewPendingCalls(),
+		rpc:            make(map[string]RequestHandler),
+		schemas:        make(map[string]*schema.Schema),
+		subscriptions:  make(map[string]*subscription),
+		ctx:            ctx,
+		cancel:         cancel,
+		done:           make(chan struct{}),
+		context:        make(map[string]interface{}),
 	}
 }
 
-// Connect attempts to establish a connection to the Unix domain socket server.
-// It returns an error if the connection fails.
+// Connect attempts to establish a connection to the Unix domain socket server and
+// performs the protocol handshake. It returns an error if the connection fails, or
+// an error wrapping conduit.ErrIncompatiblePeer if the server rejects the client.
 //
 // Once connected, the client starts a background goroutine to listen for incoming messages.
 func (c *Client) Connect() error {
@@ -59,30 +82,44 @@ func (c *Client) Connect() error {
 		return ErrClientClosed
 	}
 
-	conn, err := net.Dial("unix", c.config.SocketPath)
+	conn, err := conduit.Dial(c.ctx, c.config.SocketPath, c.config.TLSConfig)
 	if err != nil {
 		return fmt.Errorf("failed to connect to server: %w", err)
 	}
 
+	limited := conduit.NewLimitedReader(conn, c.config.MaxMessageSize)
+	session, encoder, decoder, err := c.handshake(conn, limited)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	streams := conduit.NewStreamManager(c.Send, true, c.acceptStream, c.config.Logger)
+	outbox := conduit.NewOutbox(c.newWriter(conn, session, encoder))
+	calls := newServerCalls(c.ctx, outbox)
 	c.mu.Lock()
 	c.conn = conn
+	c.session = session
+	c.outbox = outbox
+	c.streams = streams
 	c.mu.Unlock()
 
 	c.config.Logger.Infof("Connected to server at %s", c.config.SocketPath)
 
-	go c.handleMessages()
+	go c.handleMessages(conn, limited, decoder, streams, calls)
+	c.resubscribe()
 	return nil
 }
 
 // ConnectWithRetry continuously attempts to connect until successful if Reconnect is true.
-// If Reconnect is false, it behaves like Connect.
+// If Reconnect is false, it behaves like Connect. Incompatible servers are not retried.
 //
 // This method blocks until a connection is established or the client is closed.
 func (c *Client) ConnectWithRetry() error {
 	for {
 		if err := c.Connect(); err == nil {
 			return nil
-		} else if !c.config.Reconnect {
+		} else if !c.config.Reconnect || errors.Is(err, conduit.ErrIncompatiblePeer) {
 			return err
 		}
 
@@ -102,7 +139,17 @@ func (c *Client) Close() error {
 	var err error
 	c.closeOnce.Do(func() {
 		close(c.done)
+		c.cancel()
 		c.mu.Lock()
+		if c.outbox != nil {
+			c.outbox.Close()
+			c.outbox = nil
+		}
+		if c.streams != nil {
+			c.streams.Close(ErrClientClosed)
+			c.streams = nil
+		}
+		c.calls.FailAll(ErrClientClosed)
 		if c.conn != nil {
 			err = c.conn.Close()
 			c.conn = nil
@@ -114,6 +161,8 @@ func (c *Client) Close() error {
 }
 
 // Handle registers a handler for a given message type.
+// Handlers run one at a time in the order their messages arrived, but not on the
+// connection's read loop, so a handler may wait for the reply to a Request.
 // Handlers should be registered before connecting.
 func (c *Client) Handle(msgType string, handler Handler) {
 	c.mu.Lock()
@@ -121,37 +170,69 @@ func (c *Client) Handle(msgType string, handler Handler) {
 	c.handlers[msgType] = handler
 }
 
+// HandleDefault registers a handler for messages of any type that has no handler
+// of its own. Requests still get an unknown-type error reply, since the default
+// handler cannot answer them.
+func (c *Client) HandleDefault(handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultHandler = handler
+}
+
 // Send sends a message to the server with the given type and payload.
-// Returns ErrNotConnected if the client is not currently connected.
-func (c *Client) Send(msgType string, payload interface{}) error {
+// Messages are written in priority order (see conduit.WithPriority); Send blocks until
+// the message has been written. Returns ErrNotConnected if the client is not currently connected.
+func (c *Client) Send(msgType string, payload interface{}, opts ...conduit.SendOption) error {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if c.conn == nil {
+	outbox := c.outbox
+	connected := c.conn != nil
+	c.mu.RUnlock()
+	if !connected || outbox == nil {
 		return ErrNotConnected
 	}
 
-	msg, err := conduit.NewMessage(msgType, payload)
+	msg, err := conduit.NewMessage(msgType, payload, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create message: %w", err)
 	}
 
-	if c.config.WriteTimeout > 0 {
-		c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
-	}
-
-	if err := json.NewEncoder(c.conn).Encode(msg); err != nil {
+	if err := outbox.Send(msg); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
 	return nil
 }
 
-func (c *Client) handleMessages() {
+// newWriter returns the outbox write function for conn. It is only called from
+// the outbox goroutine, so the encoder needs no further locking.
+func (c *Client) newWriter(conn net.Conn, session *conduit.Session, encoder conduit.Encoder) func(*conduit.Message) error {
+	return func(msg *conduit.Message) error {
+		msg, err := session.Compress(msg, c.config.CompressionThreshold)
+		if err != nil {
+			return err
+		}
+		if c.config.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+		}
+		return encoder.Encode(msg)
+	}
+}
+
+func (c *Client) handleMessages(conn net.Conn, limited *conduit.LimitedReader, decoder conduit.Decoder, streams *conduit.StreamManager, calls *serverCalls) {
+	inbox := conduit.NewInbox()
 	defer func() {
+		inbox.Close()
+		streams.Close(conduit.ErrConnectionClosed)
+		c.calls.FailAll(conduit.ErrConnectionClosed)
+		calls.cancel()
 		if c.config.Reconnect && !c.IsClosed() {
 			c.config.Logger.Info("Connection lost, attempting to reconnect...")
 			c.mu.Lock()
 			c.conn = nil
+			if c.outbox != nil {
+				c.outbox.Close()
+				c.outbox = nil
+			}
 			c.mu.Unlock()
 			if err := c.ConnectWithRetry(); err != nil {
 				c.config.Logger.Errorf("Failed to reconnect: %v", err)
@@ -159,37 +240,82 @@ func (c *Client) handleMessages() {
 		}
 	}()
 
-	decoder := json.NewDecoder(conduit.NewLimitedReader(c.conn, c.config.MaxMessageSize))
-
 	for {
 		select {
 		case <-c.done:
 			return
 		default:
 			if c.config.ReadTimeout > 0 {
-				c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
+				conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
 			}
 
 			var msg conduit.Message
+			limited.Reset()
 			if err := decoder.Decode(&msg); err != nil {
 				if err != io.EOF && !c.IsClosed() {
 					c.config.Logger.Errorf("Failed to decode message: %v", err)
 				}
 				return
 			}
+			if err := msg.Decompress(c.config.MaxMessageSize); err != nil {
+				c.config.Logger.Errorf("Failed to decompress message: %v", err)
+				return
+			}
+
+			if msg.Type == conduit.TypeQueueJob {
+				c.handleJob(&msg)
+				continue
+			}
+			if msg.Type == conduit.TypeRPCCancel {
+				c.cancelCall(calls, msg.ReplyTo)
+				continue
+			}
+			if c.calls.Deliver(&msg) {
+				continue
+			}
+			if handled, err := streams.HandleFrame(&msg); handled {
+				if err != nil {
+					c.config.Logger.Errorf("Failed to process '%s': %v", msg.Type, err)
+				}
+				continue
+			}
+
+			if msg.Expired() {
+				atomic.AddUint64(&c.expired, 1)
+				c.config.Logger.Debugf("Dropping expired message of type '%s'", msg.Type)
+				continue
+			}
+			if !c.validate(&msg) || c.dispatchCall(calls, &msg) {
+				continue
+			}
 
 			c.mu.RLock()
 			handler, exists := c.handlers[msg.Type]
+			if !exists && c.defaultHandler != nil && !msg.ExpectReply {
+				handler, exists = c.defaultHandler, true
+			}
 			c.mu.RUnlock()
 
 			if !exists {
 				c.config.Logger.Warnf("No handler for message type '%s'", msg.Type)
+				if msg.ExpectReply {
+					c.Send(conduit.TypeRPCError, &conduit.RemoteError{
+						Code:    conduit.CodeUnknownType,
+						Message: fmt.Sprintf("no handler for message type '%s'", msg.Type),
+					}, conduit.WithReplyTo(msg.ID))
+				}
 				continue
 			}
 
-			if err := handler(c, &msg); err != nil {
-				c.config.Logger.Errorf("Handler error for message type '%s': %v", msg.Type, err)
-			}
+			// Handlers run off the read loop so replies to requests they send
+			// are still read while they wait.
+			inbox.Push(func() {
+				ctx, cancel := conduit.MessageContext(c.ctx, &msg)
+				defer cancel()
+				if err := handler(c, msg.WithContext(ctx)); err != nil {
+					c.config.Logger.Errorf("Handler error for message type '%s': %v", msg.Type, err)
+				}
+			})
 		}
 	}
 }
@@ -201,6 +327,17 @@ func (c *Client) IsConnected() bool {
 	return c.conn != nil
 }
 
+// Session returns the settings negotiated with the server during the handshake,
+// or nil if the client is not connected.
+func (c *Client) Session() *conduit.Session {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.session
+}
+
 // IsClosed returns true if the client has been closed.
 func (c *Client) IsClosed() bool {
 	select {
@@ -211,6 +348,12 @@ func (c *Client) IsClosed() bool {
 	}
 }
 
+// ExpiredMessages returns how many received messages were dropped because they
+// had passed their deadline before they could be dispatched.
+func (c *Client) ExpiredMessages() uint64 {
+	return atomic.LoadUint64(&c.expired)
+}
+
 // GetContext retrieves a context value from the client's key-value store.
 func (c *Client) GetContext(key string) (interface{}, bool) {
 	c.contextMu.RLock()
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"context"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// Describe asks the server which message types it handles, along with their
+// payload schemas where known and the server's version. Servers with
+// ServerConfig.DisableReflection set answer with a *conduit.RemoteError of code
+// conduit.CodeUnknownType.
+func (c *Client) Describe(ctx context.Context) (*conduit.ServerDescription, error) {
+	var desc conduit.ServerDescription
+	if err := c.Request(ctx, conduit.TypeReflect, nil, &desc); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// jsonCodec speaks the handshake.
+var jsonCodec, _ = conduit.LookupCodec("json")
+
+// handshake sends the client's hello on a freshly dialed connection and waits for
+// the server's reply, read from r. It returns the negotiated session together with
+// an encoder and decoder for the agreed codec. A server that does not answer
+// within HandshakeTimeout or answers with anything but a hello is reported as a
+// *conduit.HandshakeError.
+func (c *Client) handshake(conn net.Conn, r io.Reader) (*conduit.Session, conduit.Encoder, conduit.Decoder, error) {
+	hello := conduit.NewHello(c.config.Codecs, c.config.Compression)
+	msg, err := conduit.NewMessage(conduit.TypeHello, hello)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if c.config.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+	}
+	if err := jsonCodec.NewEncoder(conn).Encode(msg); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	if c.config.HandshakeTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(c.config.HandshakeTimeout))
+	}
+	helloDecoder := json.NewDecoder(r)
+	var reply conduit.Message
+	if err := helloDecoder.Decode(&reply); err != nil {
+		// A server that predates the handshake ignores the hello and never
+		// answers, so a timeout means the peer is incompatible.
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, nil, nil, &conduit.HandshakeError{Reason: fmt.Sprintf(
+				"no hello from server within %v; it may not support the handshake", c.config.HandshakeTimeout)}
+		}
+		return nil, nil, nil, fmt.Errorf("failed to read handshake reply: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if reply.Type != conduit.TypeHello {
+		return nil, nil, nil, &conduit.HandshakeError{Reason: fmt.Sprintf("expected hello from server, got '%s'", reply.Type)}
+	}
+	var replyHello conduit.Hello
+	if err := reply.UnmarshalPayload(&replyHello); err != nil {
+		return nil, nil, nil, &conduit.HandshakeError{Reason: fmt.Sprintf("malformed hello: %v", err)}
+	}
+
+	session, err := hello.Accept(&replyHello)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	codec, _ := conduit.LookupCodec(session.Codec)
+	decoder := codec.NewDecoder(conduit.HandshakeRemainder(helloDecoder, r))
+	return session, codec.NewEncoder(conn), decoder, nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// JobHandler processes a job delivered from a server-side work queue.
+// Returning nil acknowledges the job; returning an error nacks it so the server
+// can redeliver it to another worker.
+type JobHandler func(*Client, *conduit.Job) error
+
+type subscription struct {
+	prefetch int
+	handler  JobHandler
+}
+
+// Subscribe registers the client as a worker for the named server-side queue.
+// Up to prefetch jobs are delivered concurrently, each processed in its own
+// goroutine by handler. Subscriptions are restored automatically on reconnect.
+//
+// If the client is not connected yet, the subscription is sent once it connects.
+func (c *Client) Subscribe(queue string, prefetch int, handler JobHandler) error {
+	c.mu.Lock()
+	c.subscriptions[queue] = &subscription{prefetch: prefetch, handler: handler}
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+	return c.Send(conduit.TypeQueueSubscribe, conduit.QueueSubscribe{Queue: queue, Prefetch: prefetch})
+}
+
+// Unsubscribe stops the client from receiving jobs from the named queue.
+// Jobs that are still being processed are redelivered by the server.
+func (c *Client) Unsubscribe(queue string) error {
+	c.mu.Lock()
+	delete(c.subscriptions, queue)
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+	return c.Send(conduit.TypeQueueUnsubscribe, conduit.QueueSubscribe{Queue: queue})
+}
+
+// Enqueue adds a job with the given payload to the named server-side queue and
+// returns its ID. It is shorthand for EnqueueContext with a background context.
+func (c *Client) Enqueue(queue string, payload interface{}, opts ...conduit.SendOption) (string, error) {
+	return c.EnqueueContext(context.Background(), queue, payload, opts...)
+}
+
+// EnqueueContext adds a job with the given payload to the named server-side queue
+// and waits for the server to accept it, returning the job's ID. Options such as
+// conduit.WithTTL apply to the job as well as the enqueue message. An unknown
+// queue is reported as a *conduit.RemoteError.
+//
+// Servers that did not negotiate RPC cannot confirm the job; it is sent without
+// waiting and the returned ID is empty.
+func (c *Client) EnqueueContext(ctx context.Context, queue string, payload interface{}, opts ...conduit.SendOption) (string, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req := conduit.QueueEnqueue{Queue: queue, Payload: payloadBytes}
+	if session := c.Session(); session != nil && !session.HasFeature(conduit.FeatureRPC) {
+		return "", c.Send(conduit.TypeQueueEnqueue, req, opts...)
+	}
+	var id string
+	if err := c.Request(ctx, conduit.TypeQueueEnqueue, req, &id, opts...); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// resubscribe sends every registered subscription to the server. It is called
+// after each successful connection.
+func (c *Client) resubscribe() {
+	c.mu.RLock()
+	subs := make(map[string]int, len(c.subscriptions))
+	for queue, sub := range c.subscriptions {
+		subs[queue] = sub.prefetch
+	}
+	c.mu.RUnlock()
+
+	for queue, prefetch := range subs {
+		if err := c.Send(conduit.TypeQueueSubscribe, conduit.QueueSubscribe{Queue: queue, Prefetch: prefetch}); err != nil {
+			c.config.Logger.Errorf("Failed to subscribe to queue '%s': %v", queue, err)
+		}
+	}
+}
+
+// handleJob runs the subscription handler for a delivered job and reports the
+// outcome back to the server.
+func (c *Client) handleJob(msg *conduit.Message) {
+	var job conduit.Job
+	if err := msg.UnmarshalPayload(&job); err != nil {
+		c.config.Logger.Errorf("Failed to decode job: %v", err)
+		return
+	}
+
+	c.mu.RLock()
+	sub, exists := c.subscriptions[job.Queue]
+	c.mu.RUnlock()
+
+	ack := conduit.QueueAck{Queue: job.Queue, JobID: job.ID}
+	switch {
+	case !exists:
+		ack.Error = "not subscribed"
+	case job.Expired():
+		atomic.AddUint64(&c.expired, 1)
+		ack.Error = conduit.ErrMessageExpired.Error()
+	}
+	if ack.Error != "" {
+		if err := c.Send(conduit.TypeQueueNack, ack); err != nil {
+			c.config.Logger.Errorf("Failed to nack job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	go func() {
+		msgType := conduit.TypeQueueAck
+		ctx, cancel := conduit.MessageContext(c.ctx, msg)
+		defer cancel()
+		if err := sub.handler(c, job.WithContext(ctx)); err != nil {
+			c.config.Logger.Errorf("Job handler error for queue '%s': %v", job.Queue, err)
+			msgType = conduit.TypeQueueNack
+			ack.Error = err.Error()
+		}
+		if err := c.Send(msgType, ack); err != nil {
+			c.config.Logger.Errorf("Failed to acknowledge job %s: %v", job.ID, err)
+		}
+	}()
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// RequestHandler answers a request sent by the server with Connection.Request.
+// The returned value is sent back as the reply; a returned error is sent as a
+// conduit.RemoteError instead. The request's Context is canceled if the server
+// gives up.
+type RequestHandler func(*Client, *conduit.Message) (interface{}, error)
+
+// HandleRequest registers a handler that answers server requests of the given
+// type. Each request is handled in its own goroutine.
+// Handlers should be registered before connecting.
+func (c *Client) HandleRequest(msgType string, handler RequestHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rpc[msgType] = handler
+}
+
+// Reply sends payload as the reply to a request from the server. Plain message
+// handlers can use it to answer requests sent with Connection.Request.
+func (c *Client) Reply(req *conduit.Message, payload interface{}, opts ...conduit.SendOption) error {
+	return c.Send(conduit.TypeRPCReply, payload, append(opts, conduit.WithReplyTo(req.ID))...)
+}
+
+// serverCalls tracks the requests the server sent on one connection. Their
+// handlers are canceled when the connection is lost, and their replies are only
+// ever written to that connection: a later connection never sees answers to
+// request IDs its server did not issue.
+type serverCalls struct {
+	active *conduit.ActiveCalls
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   conduit.SendFunc
+}
+
+func newServerCalls(parent context.Context, outbox *conduit.Outbox) *serverCalls {
+	ctx, cancel := context.WithCancel(parent)
+	return &serverCalls{
+		active: conduit.NewActiveCalls(),
+		ctx:    ctx,
+		cancel: cancel,
+		send: func(msgType string, payload interface{}, opts ...conduit.SendOption) error {
+			msg, err := conduit.NewMessage(msgType, payload, opts...)
+			if err != nil {
+				return err
+			}
+			return outbox.Send(msg)
+		},
+	}
+}
+
+// dispatchCall starts the request handler registered for msg's type, if any. The
+// handler runs in its own goroutine with a context that the server can cancel.
+func (c *Client) dispatchCall(calls *serverCalls, msg *conduit.Message) bool {
+	c.mu.RLock()
+	handler, exists := c.rpc[msg.Type]
+	c.mu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	ctx, cancel := conduit.MessageContext(calls.ctx, msg)
+	if err := calls.active.Start(msg.ID, cancel); err != nil {
+		cancel()
+		c.config.Logger.Warnf("Rejecting request '%s': %v", msg.Type, err)
+		calls.send(conduit.TypeRPCError, &conduit.RemoteError{
+			Code:    conduit.CodeInvalidArgument,
+			Message: fmt.Sprintf("%v '%s'", err, msg.ID),
+		}, conduit.WithReplyTo(msg.ID))
+		return true
+	}
+
+	go func() {
+		err := conduit.ServeCall(calls.active, calls.send, msg, cancel, func() error {
+			resp, err := handler(c, msg.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			return calls.send(conduit.TypeRPCReply, resp, conduit.WithReplyTo(msg.ID))
+		})
+		if err != nil {
+			c.config.Logger.Errorf("Handler error for request '%s': %v", msg.Type, err)
+		}
+	}()
+	return true
+}
+
+// cancelCall cancels the request handler serving the request with the given ID.
+// Running calls report their outcome once the handler returns.
+func (c *Client) cancelCall(calls *serverCalls, id string) {
+	if outcome := calls.active.Cancel(id); outcome != "" {
+		conduit.SendCancelResult(calls.send, id, outcome)
+	}
+}
+
+// Request sends a request of the given type and waits for the server's reply,
+// decoding it into resp unless resp is nil. A handler error is returned as a
+// *conduit.RemoteError. If ctx is done first, the server is told to cancel the
+// call and a *conduit.CanceledError wrapping ctx's error is returned, reporting
+// whether the server's handler stopped or had already completed.
+func (c *Client) Request(ctx context.Context, msgType string, payload interface{}, resp interface{}, opts ...conduit.SendOption) error {
+	stream, err := c.startCall(ctx, msgType, payload, true, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return stream.Recv(resp)
+}
+
+// Call invokes a method of a service registered on the server with
+// RegisterService, e.g. c.Call("Arith.Add", &args, &reply). It is shorthand for
+// CallContext with a background context.
+func (c *Client) Call(method string, req interface{}, resp interface{}) error {
+	return c.CallContext(context.Background(), method, req, resp)
+}
+
+// CallContext invokes a service method like Call, canceling the call on the
+// server if ctx is done first.
+func (c *Client) CallContext(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	return c.Request(ctx, method, req, resp)
+}
+
+// RequestStream sends a request of the given type to a server-streaming handler
+// and returns the stream of responses. Call Recv until it returns io.EOF, or
+// Close the stream to cancel the call early.
+func (c *Client) RequestStream(ctx context.Context, msgType string, payload interface{}, opts ...conduit.SendOption) (*CallStream, error) {
+	return c.startCall(ctx, msgType, payload, true, opts)
+}
+
+// OpenBidiStream starts a bidirectional streaming call of the given type. Messages
+// sent with Send are delivered to the handler's Recv, and CloseSend tells the
+// handler that no more messages follow.
+func (c *Client) OpenBidiStream(ctx context.Context, msgType string, opts ...conduit.SendOption) (*CallStream, error) {
+	return c.startCall(ctx, msgType, nil, false, opts)
+}
+
+func (c *Client) startCall(ctx context.Context, msgType string, payload interface{}, sendClosed bool, opts []conduit.SendOption) (*CallStream, error) {
+	if session := c.Session(); session != nil && !session.HasFeature(conduit.FeatureRPC) {
+		return nil, conduit.ErrFeatureUnsupported
+	}
+
+	id := conduit.NewID()
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &CallStream{
+		client:     c,
+		call:       c.calls.Register(id),
+		ctx:        ctx,
+		cancel:     cancel,
+		sendClosed: sendClosed,
+	}
+	stop := context.AfterFunc(ctx, stream.abort)
+	stream.mu.Lock()
+	stream.stop = stop
+	stream.mu.Unlock()
+
+	opts = append(opts, conduit.WithID(id), expectReply)
+	if err := c.Send(msgType, payload, opts...); err != nil {
+		stream.finish(err)
+		return nil, err
+	}
+	return stream, nil
+}
+
+func expectReply(m *conduit.Message) {
+	m.ExpectReply = true
+}
+
+// CallStream is the caller's side of a call started with RequestStream or
+// OpenBidiStream.
+type CallStream struct {
+	client    *Client
+	call      *conduit.Call
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stop      func() bool
+	abortOnce sync.Once
+
+	mu         sync.Mutex
+	sendClosed bool
+	done       bool
+	err        error
+}
+
+// Context returns the call's context. It is canceled once the call has finished.
+func (s *CallStream) Context() context.Context {
+	return s.ctx
+}
+
+// Recv decodes the next response into v. It returns io.EOF after the last
+// response, a *conduit.RemoteError if the handler failed, or a
+// *conduit.CanceledError if the call was canceled.
+func (s *CallStream) Recv(v interface{}) error {
+	s.mu.Lock()
+	if s.done {
+		err := s.err
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Unlock()
+
+	for {
+		msg, err := s.call.Next(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				s.abort()
+			} else if errors.Is(err, conduit.ErrCallOverflow) {
+				// Stop the handler; nothing more it sends will be read.
+				s.client.Send(conduit.TypeRPCCancel, nil, conduit.WithReplyTo(s.call.ID()),
+					conduit.WithPriority(conduit.PriorityHigh))
+			}
+			return s.finish(err)
+		}
+
+		switch msg.Type {
+		case conduit.TypeRPCItem, conduit.TypeRPCReply:
+			if v != nil {
+				if err := msg.UnmarshalPayload(v); err != nil {
+					return fmt.Errorf("failed to unmarshal response: %w", err)
+				}
+			}
+			if msg.Type == conduit.TypeRPCReply {
+				s.finish(io.EOF)
+			}
+			return nil
+		case conduit.TypeRPCEnd:
+			return s.finish(io.EOF)
+		case conduit.TypeRPCError:
+			return s.finish(conduit.DecodeRemoteError(msg))
+		}
+	}
+}
+
+// Send sends one message to the handler of a bidirectional stream.
+func (s *CallStream) Send(payload interface{}, opts ...conduit.SendOption) error {
+	s.mu.Lock()
+	closed := s.sendClosed || s.done
+	s.mu.Unlock()
+	if closed {
+		return conduit.ErrStreamClosed
+	}
+	return s.client.Send(conduit.TypeRPCItem, payload, append(opts, conduit.WithReplyTo(s.call.ID()))...)
+}
+
+// CloseSend tells the handler of a bidirectional stream that no more messages follow.
+func (s *CallStream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendClosed || s.done {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.mu.Unlock()
+	return s.client.Send(conduit.TypeRPCEnd, nil, conduit.WithReplyTo(s.call.ID()))
+}
+
+// Close releases the call. If the server has not finished it yet, the call is
+// canceled on the server as well.
+func (s *CallStream) Close() error {
+	s.cancel()
+	return nil
+}
+
+// abort runs when the call's context is done. Unfinished calls are canceled on
+// the server so its handler stops working on them, and the call completes with
+// the outcome the server reports. Concurrent callers wait for the first one.
+func (s *CallStream) abort() {
+	s.abortOnce.Do(func() {
+		s.mu.Lock()
+		done := s.done
+		s.mu.Unlock()
+		if done {
+			return
+		}
+
+		outcome := conduit.CancelUnknown
+		if err := s.client.Send(conduit.TypeRPCCancel, nil, conduit.WithReplyTo(s.call.ID()),
+			conduit.WithPriority(conduit.PriorityHigh)); err == nil {
+			outcome = conduit.AwaitCancelResult(s.call, s.client.config.CancelTimeout)
+		}
+		s.complete(&conduit.CanceledError{Err: s.ctx.Err(), Outcome: outcome})
+	})
+}
+
+// finish records the outcome of the call and returns the outcome that was
+// recorded first, which every later Recv returns as well.
+func (s *CallStream) finish(err error) error {
+	s.complete(err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// complete records err as the call's outcome and stops tracking the call. It
+// returns false if the call had already completed.
+func (s *CallStream) complete(err error) bool {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return false
+	}
+	s.done = true
+	s.err = err
+	stop := s.stop
+	s.mu.Unlock()
+
+	s.client.calls.Remove(s.call.ID())
+	if stop != nil {
+		stop()
+	}
+	s.cancel()
+	return true
+}
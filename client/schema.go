@@ -0,0 +1,58 @@
+package client
+
+import (
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
+)
+
+// SetSchema registers the schema that payloads of msgType must satisfy. Messages
+// from the server are validated before any handler runs: an invalid request is
+// answered with a conduit.RemoteError of code conduit.CodeInvalidArgument
+// listing the failing paths (see schema.FieldErrors), and any other invalid
+// message is logged and dropped. A nil schema removes validation for msgType.
+//
+// The schema is compiled first; an invalid schema, such as one with a malformed
+// pattern, is returned as an error and not registered.
+func (c *Client) SetSchema(msgType string, sch *schema.Schema) error {
+	if sch != nil {
+		if err := sch.Compile(); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sch == nil {
+		delete(c.schemas, msgType)
+		return nil
+	}
+	c.schemas[msgType] = sch
+	return nil
+}
+
+// Schema returns the schema registered for msgType, or nil if there is none.
+func (c *Client) Schema(msgType string) *schema.Schema {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.schemas[msgType]
+}
+
+// validate checks msg against the schema of its type. It returns false if the
+// message was rejected.
+func (c *Client) validate(msg *conduit.Message) bool {
+	sch := c.Schema(msg.Type)
+	if sch == nil {
+		return true
+	}
+	err := sch.Validate(msg.Payload)
+	if err == nil {
+		return true
+	}
+
+	c.config.Logger.Warnf("Rejecting message of type '%s': %v", msg.Type, err)
+	if msg.ExpectReply {
+		if verr, ok := err.(*schema.ValidationError); ok {
+			c.Send(conduit.TypeRPCError, verr.RemoteError(), conduit.WithReplyTo(msg.ID))
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// StreamHandler processes a byte stream opened by the server. The handler reads
+// the stream until io.EOF; returning nil completes the stream, while returning an
+// error resets it and reports the error to the server.
+//
+// Each stream runs in its own goroutine.
+type StreamHandler func(*Client, *conduit.Stream) error
+
+// HandleStream registers a handler for streams opened with the given type.
+// Handlers should be registered before connecting.
+func (c *Client) HandleStream(msgType string, handler StreamHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.streamHandlers[msgType] = handler
+}
+
+// OpenStream opens a byte stream to the server, handled by the server's stream
+// handler for msgType. Data written to the stream is sent in chunks that
+// interleave with other messages, so payloads far larger than MaxMessageSize can
+// be transferred. Close the stream to wait for the server's handler to finish and
+// receive its error, or call CloseWithError to cancel the transfer.
+//
+// Returns ErrNotConnected if the client is not currently connected, or
+// conduit.ErrFeatureUnsupported if the server did not negotiate streams.
+func (c *Client) OpenStream(msgType string) (*conduit.Stream, error) {
+	c.mu.RLock()
+	streams := c.streams
+	session := c.session
+	connected := c.conn != nil
+	c.mu.RUnlock()
+	if !connected || streams == nil {
+		return nil, ErrNotConnected
+	}
+	if !session.HasFeature(conduit.FeatureStreams) {
+		return nil, conduit.ErrFeatureUnsupported
+	}
+	return streams.Open(msgType)
+}
+
+// acceptStream runs the handler for a stream opened by the server. It is called
+// in its own goroutine by the connection's stream manager.
+func (c *Client) acceptStream(stream *conduit.Stream) {
+	c.mu.RLock()
+	handler, exists := c.streamHandlers[stream.Type()]
+	c.mu.RUnlock()
+
+	if !exists {
+		c.config.Logger.Warnf("No stream handler for type '%s'", stream.Type())
+		stream.CloseWithError(fmt.Errorf("no handler for stream type '%s'", stream.Type()))
+		return
+	}
+
+	err := handler(c, stream)
+	if err != nil {
+		c.config.Logger.Errorf("Stream handler error for type '%s': %v", stream.Type(), err)
+	}
+	stream.Finish(err)
+}
+
+// ChannelHandler serves a logical channel opened by the server. The channel is
+// closed when the handler returns; returning an error resets it instead.
+type ChannelHandler func(*Client, *conduit.Channel) error
+
+// HandleChannel registers a handler for channels opened with the given name.
+// Handlers should be registered before connecting.
+func (c *Client) HandleChannel(name string, handler ChannelHandler) {
+	c.HandleStream(conduit.ChannelStreamType(name), func(client *Client, stream *conduit.Stream) error {
+		ch := conduit.NewChannel(stream, name)
+		err := handler(client, ch)
+		if err == nil {
+			ch.CloseRead()
+		}
+		return err
+	})
+}
+
+// OpenChannel opens a logical channel to the server, served by the server's
+// handler for name. Each channel is a net.Conn with its own flow-control window,
+// so independent conversations can share one connection.
+func (c *Client) OpenChannel(name string) (*conduit.Channel, error) {
+	stream, err := c.OpenStream(conduit.ChannelStreamType(name))
+	if err != nil {
+		return nil, err
+	}
+	return conduit.NewChannel(stream, name), nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// bounds are the upper edges of the histogram buckets, following a 1-2-5
+// progression from 10µs to 10s. Slower samples land in a final overflow bucket.
+var bounds = func() []time.Duration {
+	var b []time.Duration
+	for d := 10 * time.Microsecond; d <= 10*time.Second; d *= 10 {
+		b = append(b, d, 2*d, 5*d)
+	}
+	return b[:len(b)-2]
+}()
+
+// histogram records latencies into fixed buckets. Percentiles are reported as
+// the upper edge of the bucket they fall in, except for the exact min and max.
+// It is not safe for concurrent use; each worker keeps its own and they are
+// merged at the end.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(bounds)+1), min: math.MaxInt64}
+}
+
+func (h *histogram) record(d time.Duration) {
+	i := 0
+	for i < len(bounds) && d > bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += d
+	if d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *histogram) merge(other *histogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+	h.sum += other.sum
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// percentile returns the upper edge of the bucket holding the q-th quantile,
+// capped at the largest sample.
+func (h *histogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			if i < len(bounds) && bounds[i] < h.max {
+				return bounds[i]
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// print writes the summary line and one bar per non-empty bucket.
+func (h *histogram) print(w io.Writer) {
+	if h.total == 0 {
+		fmt.Fprintln(w, "Latency:     no samples")
+		return
+	}
+	fmt.Fprintf(w, "Latency:     min %v  mean %v  p50 %v  p90 %v  p99 %v  p99.9 %v  max %v\n",
+		round(h.min), round(h.mean()), round(h.percentile(0.5)), round(h.percentile(0.9)),
+		round(h.percentile(0.99)), round(h.percentile(0.999)), round(h.max))
+
+	fmt.Fprintln(w, "\nLatency histogram:")
+	var peak uint64
+	for _, n := range h.counts {
+		if n > peak {
+			peak = n
+		}
+	}
+	for i, n := range h.counts {
+		if n == 0 {
+			continue
+		}
+		label := "> " + bounds[len(bounds)-1].String()
+		if i < len(bounds) {
+			label = "<= " + bounds[i].String()
+		}
+		bar := strings.Repeat("#", int(math.Ceil(40*float64(n)/float64(peak))))
+		fmt.Fprintf(w, "  %-10s %10d %6.2f%% %s\n", label, n, 100*float64(n)/float64(h.total), bar)
+	}
+}
+
+// round trims the noise digits off durations for display.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	case d >= time.Microsecond:
+		return d.Round(10 * time.Nanosecond)
+	default:
+		return d
+	}
+}
@@ -0,0 +1,327 @@
+// Command conduit-bench generates load against a conduit server and reports
+// throughput and a latency histogram.
+//
+// Usage:
+//
+//	conduit-bench run [flags] socket
+//	conduit-bench serve [flags] socket
+//
+// run drives the server at socket with -c concurrent workers spread over -conns
+// connections. In request mode (the default) every operation is a request of
+// -type that waits for its reply; in send mode operations are one-way messages
+// and the latency is the time until the message was written. Payloads are JSON
+// strings of -size bytes. -rate caps the total operations per second, and the
+// run ends after -d or after -n operations, whichever comes first.
+//
+// With -rate, operations follow a fixed schedule and latency is measured from
+// the time an operation was scheduled, not from when a worker got to send it.
+// Time spent waiting for a free worker behind a slow operation counts, so a
+// stalled server shows up in the latency instead of only as lower throughput.
+//
+// serve starts a server answering the default request type "bench.echo" with
+// its payload and accepting "bench.send" messages, so the two halves can be
+// pointed at each other:
+//
+//	conduit-bench serve /tmp/bench.sock &
+//	conduit-bench run -c 16 -size 1024 -d 10s /tmp/bench.sock
+//
+// Sockets are Unix socket paths or addresses such as tcp://127.0.0.1:9000.
+// Exit status is 0 if every operation succeeded, 1 if some failed and 2 for
+// usage errors.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+const (
+	echoType = "bench.echo"
+	sendType = "bench.send"
+)
+
+const usage = `Usage:
+  conduit-bench run [flags] socket
+  conduit-bench serve [flags] socket
+
+Run "conduit-bench <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var run func([]string) error
+	switch os.Args[1] {
+	case "run":
+		run = runBench
+	case "serve":
+		run = runServe
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "conduit-bench: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := run(os.Args[2:]); err != nil {
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			os.Exit(0)
+		case err == errUsage:
+			// The flag set has already printed its usage.
+			os.Exit(2)
+		case errors.Is(err, errUsage):
+			fmt.Fprintf(os.Stderr, "conduit-bench: %v\n", err)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "conduit-bench: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage error")
+
+// options are the flags of the run command.
+type options struct {
+	mode        string
+	msgType     string
+	concurrency int
+	conns       int
+	size        int
+	rate        float64
+	duration    time.Duration
+	count       int64
+	timeout     time.Duration
+	codec       string
+}
+
+func runBench(args []string) error {
+	var opts options
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.StringVar(&opts.mode, "mode", "request", `"request" waits for a reply to every message, "send" only writes`)
+	fs.StringVar(&opts.msgType, "type", "", `message type (default "bench.echo" for requests, "bench.send" for sends)`)
+	fs.IntVar(&opts.concurrency, "c", 1, "number of concurrent workers")
+	fs.IntVar(&opts.conns, "conns", 1, "number of connections shared by the workers")
+	fs.IntVar(&opts.size, "size", 64, "payload size in bytes")
+	fs.Float64Var(&opts.rate, "rate", 0, "maximum operations per second across all workers (0 = unlimited)")
+	fs.DurationVar(&opts.duration, "d", 10*time.Second, "duration of the run")
+	fs.Int64Var(&opts.count, "n", 0, "stop after this many operations (0 = no limit)")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "timeout of a single request")
+	fs.StringVar(&opts.codec, "codec", "", "codec to negotiate, e.g. json or gob (default: server's choice)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: conduit-bench run [flags] socket")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	switch opts.mode {
+	case "request", "send":
+	default:
+		return fmt.Errorf("%w: unknown mode %q", errUsage, opts.mode)
+	}
+	if opts.concurrency < 1 || opts.conns < 1 || opts.size < 0 {
+		return fmt.Errorf("%w: -c and -conns must be at least 1 and -size not negative", errUsage)
+	}
+	if opts.msgType == "" {
+		opts.msgType = echoType
+		if opts.mode == "send" {
+			opts.msgType = sendType
+		}
+	}
+
+	clients := make([]*client.Client, opts.conns)
+	for i := range clients {
+		cfg := conduit.DefaultClientConfig(fs.Arg(0))
+		cfg.Logger = conduit.NewLogger(conduit.LogError, os.Stderr)
+		cfg.Reconnect = false
+		cfg.ReadTimeout = 0
+		if opts.codec != "" {
+			cfg.Codecs = []string{opts.codec}
+		}
+		c := client.NewClient(cfg)
+		if err := c.Connect(); err != nil {
+			return fmt.Errorf("failed to connect to %s: %v", fs.Arg(0), err)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	res := drive(ctx, clients, &opts)
+	res.print(os.Stdout, &opts)
+	if res.errors > 0 {
+		return fmt.Errorf("%d of %d operations failed, first error: %v", res.errors, res.errors+res.latency.total, res.firstErr)
+	}
+	return nil
+}
+
+// result is the outcome of a run.
+type result struct {
+	latency  *histogram
+	errors   uint64
+	firstErr error
+	elapsed  time.Duration
+}
+
+// drive runs the workers until ctx is done or the operation budget is spent.
+func drive(ctx context.Context, clients []*client.Client, opts *options) *result {
+	payload := strings.Repeat("x", opts.size)
+
+	var interval time.Duration
+	if opts.rate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.rate)
+	}
+
+	var (
+		issued int64
+		slots  int64
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		res    = &result{latency: newHistogram()}
+	)
+	start := time.Now()
+	for w := 0; w < opts.concurrency; w++ {
+		wg.Add(1)
+		go func(c *client.Client) {
+			defer wg.Done()
+			local := newHistogram()
+			var errs uint64
+			var firstErr error
+			for {
+				// Each operation takes the next slot of the schedule, whether or
+				// not a worker was free at that time.
+				begin := time.Now()
+				if interval > 0 {
+					slot := atomic.AddInt64(&slots, 1) - 1
+					begin = start.Add(time.Duration(slot) * interval)
+					waitUntil(ctx, begin)
+				}
+				if ctx.Err() != nil || (opts.count > 0 && atomic.AddInt64(&issued, 1) > opts.count) {
+					break
+				}
+
+				err := operate(ctx, c, opts, payload)
+				if err != nil {
+					if ctx.Err() != nil {
+						break
+					}
+					errs++
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+				local.record(time.Since(begin))
+			}
+
+			mu.Lock()
+			res.latency.merge(local)
+			res.errors += errs
+			if res.firstErr == nil {
+				res.firstErr = firstErr
+			}
+			mu.Unlock()
+		}(clients[w%len(clients)])
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	return res
+}
+
+// waitUntil sleeps until t or until ctx is done.
+func waitUntil(ctx context.Context, t time.Time) {
+	d := time.Until(t)
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+func operate(ctx context.Context, c *client.Client, opts *options, payload string) error {
+	if opts.mode == "send" {
+		return c.Send(opts.msgType, payload)
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	return c.Request(ctx, opts.msgType, payload, nil)
+}
+
+func (r *result) print(w io.Writer, opts *options) {
+	ops := r.latency.total
+	seconds := r.elapsed.Seconds()
+	fmt.Fprintf(w, "Target:      %d workers over %d connections, %s of %d bytes\n", opts.concurrency, opts.conns, opts.mode+"s", opts.size)
+	fmt.Fprintf(w, "Operations:  %d (%d errors) in %v\n", ops, r.errors, round(r.elapsed))
+	if seconds > 0 {
+		fmt.Fprintf(w, "Throughput:  %.1f ops/s, %.2f MB/s\n", float64(ops)/seconds, float64(ops)*float64(opts.size)/seconds/1e6)
+	}
+	r.latency.print(w)
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: conduit-bench serve socket")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg := conduit.DefaultServerConfig(fs.Arg(0))
+	cfg.Logger = conduit.NewLogger(conduit.LogError, os.Stderr)
+	cfg.ReadTimeout = 0
+	srv := server.NewServer(cfg)
+	var received uint64
+	srv.HandleRequest(echoType, func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		return msg.Payload, nil
+	})
+	srv.Handle(sendType, func(*server.Connection, *conduit.Message) error {
+		atomic.AddUint64(&received, 1)
+		return nil
+	})
+	if err := srv.Start(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s and %s on %s\n", echoType, sendType, fs.Arg(0))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	fmt.Fprintf(os.Stderr, "Received %d %s messages\n", atomic.LoadUint64(&received), sendType)
+	return srv.Stop()
+}
@@ -0,0 +1,69 @@
+// Command conduit-gen generates a typed conduit server and client from service
+// interfaces in a Go source file.
+//
+// Usage:
+//
+//	conduit-gen [-type Name[,Name...]] [-o output.go] definition.go
+//
+// Interfaces marked with a "//conduit:service" comment are used unless -type
+// names them explicitly. The output defaults to <definition>_conduit.go next to
+// the input. Typical use is a go:generate directive:
+//
+//	//go:generate go run github.com/crazywolf132/conduit/cmd/conduit-gen greeter.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crazywolf132/conduit/gen"
+)
+
+func main() {
+	types := flag.String("type", "", "comma-separated interface names to generate (default: marked interfaces)")
+	output := flag.String("o", "", "output file (default: <input>_conduit.go)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: conduit-gen [-type Name[,Name...]] [-o output.go] definition.go")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *output, *types); err != nil {
+		fmt.Fprintf(os.Stderr, "conduit-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(input, output, types string) error {
+	src, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if types != "" {
+		names = strings.Split(types, ",")
+	}
+	file, err := gen.Parse(input, src, names)
+	if err != nil {
+		return err
+	}
+
+	code, err := gen.Generate(file, filepath.Base(input))
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = strings.TrimSuffix(input, ".go") + "_conduit.go"
+	}
+	return os.WriteFile(output, code, 0644)
+}
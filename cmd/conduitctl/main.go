@@ -0,0 +1,551 @@
+// Command conduitctl talks to a running conduit server from the command line.
+//
+// Usage:
+//
+//	conduitctl send [flags] socket type [payload]
+//	conduitctl request [flags] socket type [payload]
+//	conduitctl listen [flags] socket [type...]
+//	conduitctl describe [flags] socket
+//	conduitctl replay [flags] recording socket
+//	conduitctl proxy [flags] listen-socket target-socket
+//
+// Sockets are Unix socket paths or addresses such as tcp://127.0.0.1:9000 and
+// tls://host:9443; -ca, -cert and -key configure TLS. Payloads are JSON. If
+// the payload argument is omitted or "-", it is read from standard input; an
+// empty input sends a null payload. send and request accept -H key=value to
+// set headers. listen prints every message the server sends, optionally only
+// those of the given types, one JSON object per line. replay re-drives a
+// recording made with the record package and prints every response that
+// differs from the recorded one. proxy forwards connections from one socket to
+// another, printing the messages in both directions and optionally dropping or
+// delaying them by type.
+//
+// Exit status is 0 on success, 1 if the server answered with an error or a
+// replay found differences, 2 for usage errors, 3 if the connection failed and
+// 4 on timeout, so the command can be used in scripts:
+//
+//	conduitctl request /tmp/app.sock status '{"verbose":true}' | jq .uptime
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/proxy"
+	"github.com/crazywolf132/conduit/record"
+)
+
+// Exit statuses.
+const (
+	exitOK      = 0
+	exitRemote  = 1
+	exitUsage   = 2
+	exitConnect = 3
+	exitTimeout = 4
+)
+
+const usage = `Usage:
+  conduitctl send [flags] socket type [payload]
+  conduitctl request [flags] socket type [payload]
+  conduitctl listen [flags] socket [type...]
+  conduitctl describe [flags] socket
+  conduitctl replay [flags] recording socket
+  conduitctl proxy [flags] listen-socket target-socket
+
+Run "conduitctl <command> -h" for the flags of a command.
+`
+
+// exitError carries the exit status of a failed command.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func fail(code int, format string, args ...interface{}) error {
+	return &exitError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// command runs one subcommand, writing its output to stdout and diagnostics to
+// stderr.
+type command func(args []string, stdout, stderr io.Writer) error
+
+var commands = map[string]command{
+	"send":     runSend,
+	"request":  runRequest,
+	"listen":   runListen,
+	"describe": runDescribe,
+	"replay":   runReplay,
+	"proxy":    runProxy,
+}
+
+// run executes the command line args, without the program name, and returns the
+// exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "conduitctl: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	if err := cmd(args[1:], stdout, stderr); err != nil {
+		code := exitRemote
+		var exit *exitError
+		if errors.As(err, &exit) {
+			code = exit.code
+		}
+		if code != exitOK {
+			fmt.Fprintf(stderr, "conduitctl: %v\n", err)
+		}
+		return code
+	}
+	return exitOK
+}
+
+// headerFlags collects repeated -H key=value flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("header must be key=value, got %q", value)
+	}
+	h[key] = val
+	return nil
+}
+
+// listFlags collects a repeatable string flag.
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// options holds the flags shared by every command.
+type options struct {
+	stderr  io.Writer
+	timeout time.Duration
+	pretty  bool
+	headers headerFlags
+	caFile  string
+	cert    string
+	key     string
+}
+
+// tlsConfig returns the client TLS settings from the flags, or nil if none
+// were given.
+func (o *options) tlsConfig() (*tls.Config, error) {
+	if o.caFile == "" && o.cert == "" && o.key == "" {
+		return nil, nil
+	}
+	config, err := conduit.ClientTLSConfig(o.caFile, o.cert, o.key)
+	if err != nil {
+		return nil, fail(exitUsage, "%v", err)
+	}
+	return config, nil
+}
+
+func newFlagSet(name, args string, opts *options, defaultTimeout time.Duration) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(opts.stderr)
+	fs.DurationVar(&opts.timeout, "timeout", defaultTimeout, "give up after this long (0 waits forever)")
+	fs.BoolVar(&opts.pretty, "pretty", false, "indent JSON output")
+	fs.StringVar(&opts.caFile, "ca", "", "verify tls:// servers against the CA certificates in this file")
+	fs.StringVar(&opts.cert, "cert", "", "client certificate for tls:// servers that require one")
+	fs.StringVar(&opts.key, "key", "", "private key of the client certificate")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: conduitctl %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return &exitError{code: exitOK, err: err}
+		}
+		return &exitError{code: exitUsage, err: err}
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		fs.Usage()
+		return fail(exitUsage, "wrong number of arguments")
+	}
+	return nil
+}
+
+func runSend(args []string, stdout, stderr io.Writer) error {
+	opts := options{stderr: stderr, headers: headerFlags{}}
+	fs := newFlagSet("send", "socket type [payload]", &opts, 10*time.Second)
+	fs.Var(opts.headers, "H", "set a header as key=value (repeatable)")
+	if err := parse(fs, args, 2, 3); err != nil {
+		return err
+	}
+
+	payload, err := readPayload(fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	c, err := connect(fs.Arg(0), &opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Send(fs.Arg(1), payload, conduit.WithHeaders(opts.headers))
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			return fail(exitConnect, "send failed: %v", err)
+		}
+		return nil
+	case <-after(opts.timeout):
+		return fail(exitTimeout, "timed out sending message")
+	}
+}
+
+func runRequest(args []string, stdout, stderr io.Writer) error {
+	opts := options{stderr: stderr, headers: headerFlags{}}
+	fs := newFlagSet("request", "socket type [payload]", &opts, 10*time.Second)
+	fs.Var(opts.headers, "H", "set a header as key=value (repeatable)")
+	if err := parse(fs, args, 2, 3); err != nil {
+		return err
+	}
+
+	payload, err := readPayload(fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	c, err := connect(fs.Arg(0), &opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := commandContext(opts.timeout)
+	defer cancel()
+
+	var reply json.RawMessage
+	if err := c.Request(ctx, fs.Arg(1), payload, &reply, conduit.WithHeaders(opts.headers)); err != nil {
+		return requestError(err)
+	}
+	return printJSON(stdout, reply, opts.pretty)
+}
+
+func runListen(args []string, stdout, stderr io.Writer) error {
+	opts := options{stderr: stderr}
+	fs := newFlagSet("listen", "socket [type...]", &opts, 0)
+	count := fs.Int("n", 0, "exit after printing this many messages (0 for no limit)")
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	types := make(map[string]bool)
+	for _, t := range fs.Args()[1:] {
+		types[t] = true
+	}
+
+	messages := make(chan *conduit.Message, 64)
+	done := make(chan struct{})
+	c, err := newClient(fs.Arg(0), &opts)
+	if err != nil {
+		return err
+	}
+	c.HandleDefault(func(_ *client.Client, msg *conduit.Message) error {
+		if len(types) == 0 || types[msg.Type] {
+			select {
+			case messages <- msg:
+			case <-done:
+			}
+		}
+		return nil
+	})
+	if err := c.Connect(); err != nil {
+		return fail(exitConnect, "failed to connect to %s: %v", fs.Arg(0), err)
+	}
+	defer c.Close()
+	defer close(done)
+
+	ctx, cancel := commandContext(opts.timeout)
+	defer cancel()
+
+	for printed := 0; *count == 0 || printed < *count; printed++ {
+		select {
+		case msg := <-messages:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if err := printJSON(stdout, data, opts.pretty); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fail(exitTimeout, "timed out after %d messages", printed)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func runDescribe(args []string, stdout, stderr io.Writer) error {
+	opts := options{stderr: stderr}
+	fs := newFlagSet("describe", "socket", &opts, 10*time.Second)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	c, err := connect(fs.Arg(0), &opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := commandContext(opts.timeout)
+	defer cancel()
+
+	desc, err := c.Describe(ctx)
+	if err != nil {
+		return requestError(err)
+	}
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, data, opts.pretty)
+}
+
+func runReplay(args []string, stdout, stderr io.Writer) error {
+	opts := options{stderr: stderr}
+	fs := newFlagSet("replay", "recording socket", &opts, 0)
+	speed := fs.Float64("speed", 1, "timing scale: 1 keeps the recorded timing, 2 is twice as fast, 0 sends without delays")
+	settle := fs.Duration("settle", 500*time.Millisecond, "how long to wait for trailing messages from the server")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+
+	entries, err := record.Load(fs.Arg(0))
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	cfg := record.DefaultReplayConfig(fs.Arg(1))
+	cfg.Speed = *speed
+	cfg.Settle = *settle
+	cfg.Logger = conduit.NewLogger(conduit.LogError, stderr)
+	if cfg.TLSConfig, err = opts.tlsConfig(); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext(opts.timeout)
+	defer cancel()
+
+	report, err := record.Replay(ctx, entries, cfg)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fail(exitTimeout, "replay timed out")
+		}
+		return fail(exitConnect, "replay failed: %v", err)
+	}
+	for _, diff := range report.Diffs {
+		fmt.Fprintln(stdout, diff)
+	}
+	fmt.Fprintf(stderr, "replayed %d messages on %d connections, compared %d responses, %d differ\n",
+		report.Sent, report.Connections, report.Compared, len(report.Diffs))
+	if !report.OK() {
+		return &exitError{code: exitRemote, err: errors.New("responses differ from the recording")}
+	}
+	return nil
+}
+
+func runProxy(args []string, stdout, stderr io.Writer) error {
+	opts := options{stderr: stderr}
+	fs := newFlagSet("proxy", "listen-socket target-socket", &opts, 0)
+	var show, drop, delay listFlags
+	fs.Var(&show, "show", "only print messages whose type matches this pattern (repeatable)")
+	fs.Var(&drop, "drop", "drop messages whose type matches this pattern (repeatable)")
+	fs.Var(&delay, "delay", "delay messages as pattern=duration, e.g. chat.*=500ms (repeatable)")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+
+	cfg := proxy.DefaultConfig(fs.Arg(0), fs.Arg(1))
+	cfg.Logger = conduit.NewLogger(conduit.LogError, stderr)
+	var err error
+	if cfg.TargetTLS, err = opts.tlsConfig(); err != nil {
+		return err
+	}
+	p := proxy.New(cfg)
+
+	p.Use(proxy.Match(proxy.Both, show, func(conn *proxy.Conn, dir proxy.Direction, msg *conduit.Message) *conduit.Message {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(struct {
+			Conn      string           `json:"conn"`
+			Direction string           `json:"dir"`
+			Message   *conduit.Message `json:"message"`
+		}{conn.ID(), dir.String(), msg})
+		if err == nil {
+			printJSON(stdout, bytes.TrimSpace(buf.Bytes()), opts.pretty)
+		}
+		return msg
+	}))
+	for _, spec := range delay {
+		pattern, value, ok := strings.Cut(spec, "=")
+		d, err := time.ParseDuration(value)
+		if !ok || err != nil {
+			return fail(exitUsage, "invalid -delay %q: want pattern=duration", spec)
+		}
+		p.Use(proxy.Match(proxy.Both, []string{pattern}, proxy.Delay(d)))
+	}
+	if len(drop) > 0 {
+		p.Use(proxy.Match(proxy.Both, drop, proxy.Drop()))
+	}
+
+	if err := p.Start(); err != nil {
+		return fail(exitConnect, "%v", err)
+	}
+	defer p.Stop()
+
+	ctx, cancel := commandContext(opts.timeout)
+	defer cancel()
+	<-ctx.Done()
+	return nil
+}
+
+func newClient(address string, opts *options) (*client.Client, error) {
+	cfg := conduit.DefaultClientConfig(address)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, opts.stderr)
+	cfg.Reconnect = false
+	cfg.ReadTimeout = 0
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLSConfig = tlsConfig
+	return client.NewClient(cfg), nil
+}
+
+func connect(address string, opts *options) (*client.Client, error) {
+	c, err := newClient(address, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Connect(); err != nil {
+		return nil, fail(exitConnect, "failed to connect to %s: %v", address, err)
+	}
+	return c, nil
+}
+
+// commandContext returns a context that is canceled on interrupt or, if
+// timeout is positive, once it has elapsed.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func after(timeout time.Duration) <-chan time.Time {
+	if timeout <= 0 {
+		return nil
+	}
+	return time.After(timeout)
+}
+
+// readPayload returns the JSON payload given as an argument, or read from
+// standard input if arg is empty or "-".
+func readPayload(arg string) (json.RawMessage, error) {
+	data := []byte(arg)
+	if arg == "" || arg == "-" {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return nil, fail(exitUsage, "failed to read payload: %v", err)
+		}
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	if !json.Valid(data) {
+		return nil, fail(exitUsage, "payload is not valid JSON")
+	}
+	return data, nil
+}
+
+func requestError(err error) error {
+	var remote *conduit.RemoteError
+	var canceled *conduit.CanceledError
+	switch {
+	case errors.As(err, &remote):
+		return fail(exitRemote, "%v", remote)
+	case errors.As(err, &canceled) && errors.Is(err, context.DeadlineExceeded):
+		return fail(exitTimeout, "timed out waiting for reply")
+	case errors.As(err, &canceled):
+		return fail(exitRemote, "%v", err)
+	default:
+		return fail(exitConnect, "request failed: %v", err)
+	}
+}
+
+func printJSON(w io.Writer, data []byte, pretty bool) error {
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	if pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err == nil {
+			data = buf.Bytes()
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestExitStatus tests the exit statuses documented for scripts.
+func TestExitStatus(t *testing.T) {
+	socketPath := "/tmp/conduitctl_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(cfg)
+	srv.HandleRequest("echo", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		return msg.Payload, nil
+	})
+	srv.HandleRequest("fail", func(*server.Connection, *conduit.Message) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	srv.HandleRequest("hang", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		<-msg.Context().Done()
+		return nil, msg.Context().Err()
+	})
+	srv.Handle("note", func(*server.Connection, *conduit.Message) error { return nil })
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"no command", nil, exitUsage, "", "Usage:"},
+		{"help", []string{"help"}, exitOK, "Usage:", ""},
+		{"unknown command", []string{"frobnicate"}, exitUsage, "", `unknown command "frobnicate"`},
+		{"command help", []string{"request", "-h"}, exitOK, "", "Usage: conduitctl request"},
+		{"unknown flag", []string{"request", "-bogus", socketPath, "echo", "1"}, exitUsage, "", "-bogus"},
+		{"missing arguments", []string{"request", socketPath}, exitUsage, "", "wrong number of arguments"},
+		{"invalid payload", []string{"request", socketPath, "echo", "{"}, exitUsage, "", "not valid JSON"},
+		{"bad header", []string{"send", "-H", "novalue", socketPath, "note", "1"}, exitUsage, "", "key=value"},
+		{"request", []string{"request", socketPath, "echo", `{"a":1}`}, exitOK, `{"a":1}`, ""},
+		{"send", []string{"send", socketPath, "note", `"hi"`}, exitOK, "", ""},
+		{"describe", []string{"describe", socketPath}, exitOK, `"echo"`, ""},
+		{"remote error", []string{"request", socketPath, "fail", "null"}, exitRemote, "", "boom"},
+		{"unknown type", []string{"request", socketPath, "missing", "null"}, exitRemote, "", "unknown_type"},
+		{"connection failure", []string{"request", "/tmp/conduitctl_missing.sock", "echo", "1"}, exitConnect, "", "failed to connect"},
+		{"timeout", []string{"request", "-timeout", "100ms", socketPath, "hang", "null"}, exitTimeout, "", "timed out"},
+		{"listen timeout", []string{"listen", "-timeout", "100ms", socketPath}, exitTimeout, "", "timed out after 0 messages"},
+		{"missing recording", []string{"replay", "/tmp/conduitctl_missing.jsonl", socketPath}, exitUsage, "", "conduitctl_missing.jsonl"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tc.args, &stdout, &stderr)
+			if code != tc.code {
+				t.Errorf("Expected exit status %d, got %d (stderr: %s)", tc.code, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tc.stdout) {
+				t.Errorf("Expected stdout to contain %q, got %q", tc.stdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tc.stderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.stderr, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package conduit
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor implements a payload compression algorithm. Algorithms are
+// negotiated per connection during the handshake.
+type Compressor interface {
+	// Name identifies the algorithm during negotiation and in Message.Encoding, e.g. "gzip".
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		"gzip":  gzipCompressor{},
+		"flate": flateCompressor{},
+	}
+)
+
+// RegisterCompressor makes a compression algorithm available for negotiation
+// under its name. Registering an algorithm with an existing name replaces it.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// LookupCompressor returns the compressor registered under name, if any.
+func LookupCompressor(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// Compress replaces the payload with its compressed form and records the
+// algorithm in Encoding. The compressed bytes are carried as a base64 JSON
+// string so the payload stays valid JSON for every codec, at the cost of making
+// them a third larger.
+func (m *Message) Compress(c Compressor) error {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.Payload); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	m.Payload = payload
+	m.Encoding = c.Name()
+	return nil
+}
+
+// Decompress restores a payload compressed with Compress. The decompressed size
+// is checked against limit so a small frame cannot expand into an oversized
+// payload. Messages without an Encoding are left untouched.
+func (m *Message) Decompress(limit int64) error {
+	if m.Encoding == "" {
+		return nil
+	}
+	c, ok := LookupCompressor(m.Encoding)
+	if !ok {
+		return fmt.Errorf("unsupported payload encoding '%s'", m.Encoding)
+	}
+
+	var compressed []byte
+	if err := json.Unmarshal(m.Payload, &compressed); err != nil {
+		return fmt.Errorf("malformed compressed payload: %w", err)
+	}
+	r, err := c.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	payload, err := io.ReadAll(NewLimitedReader(r, limit))
+	if err != nil {
+		return err
+	}
+	m.Payload = payload
+	m.Encoding = ""
+	return nil
+}
+
+// Compress returns msg unchanged, or a compressed copy if the session negotiated
+// a compression algorithm, the payload is larger than threshold bytes and the
+// encoded compressed payload is smaller than the original. A nil session never
+// compresses.
+func (s *Session) Compress(msg *Message, threshold int) (*Message, error) {
+	if s == nil || s.Compression == "" || len(msg.Payload) <= threshold {
+		return msg, nil
+	}
+	c, ok := LookupCompressor(s.Compression)
+	if !ok {
+		return msg, nil
+	}
+	compressed := *msg
+	if err := compressed.Compress(c); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	if len(compressed.Payload) >= len(msg.Payload) {
+		// The base64 overhead outweighs what compression saved.
+		return msg, nil
+	}
+	return &compressed, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string { return "flate" }
+
+func (flateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func (flateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil }
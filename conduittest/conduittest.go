@@ -0,0 +1,288 @@
+// Package conduittest provides utilities for testing code built on conduit.
+//
+// A Harness starts a server on a fresh in-memory address, or on an ephemeral
+// Unix socket with NewUnixHarness, and hands out connected clients that record
+// what they receive, so tests wait for messages instead of sleeping:
+//
+//	h := conduittest.NewHarness(t, nil)
+//	h.Server.Handle("ping", pingHandler)
+//	c := h.Client(nil)
+//	c.Send("ping", nil)
+//	reply := c.ExpectMessage(t, "pong", time.Second)
+//
+// To test a server handler on its own, call it with a Conn, a real
+// *server.Connection whose peer records everything the handler sends:
+//
+//	conn := conduittest.NewConn(t)
+//	err := pingHandler(conn.Connection, conduittest.NewMessage(t, "ping", nil))
+//	conn.ExpectMessage(t, "pong", time.Second)
+package conduittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// addresses numbers the in-memory addresses handed out by NewHarness.
+var addresses uint64
+
+// readyTimeout bounds how long the helpers wait for a handshake to complete.
+const readyTimeout = 5 * time.Second
+
+// NewMessage creates a message like conduit.NewMessage, failing the test if the
+// payload cannot be encoded.
+func NewMessage(t testing.TB, msgType string, payload interface{}, opts ...conduit.SendOption) *conduit.Message {
+	t.Helper()
+	msg, err := conduit.NewMessage(msgType, payload, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create message of type '%s': %v", msgType, err)
+	}
+	return msg
+}
+
+// Harness runs a server for the duration of a test.
+type Harness struct {
+	Server  *server.Server
+	Address string // the address clients dial
+
+	t       testing.TB
+	mu      sync.Mutex
+	clients []*Client
+	ready   map[string]*server.Connection // by connection ID
+	changed chan struct{}
+}
+
+// NewHarness starts a server on a unique memory:// address and stops it when
+// the test ends. configure, if not nil, can adjust the server config before the
+// server is created; handlers may be registered on Server before or after.
+func NewHarness(t testing.TB, configure func(*conduit.ServerConfig)) *Harness {
+	t.Helper()
+	address := fmt.Sprintf("memory://conduittest-%d", atomic.AddUint64(&addresses, 1))
+	return newHarness(t, address, configure)
+}
+
+// NewUnixHarness is like NewHarness but listens on a Unix socket in a temporary
+// directory that is removed when the test ends, for tests that need a real
+// socket.
+func NewUnixHarness(t testing.TB, configure func(*conduit.ServerConfig)) *Harness {
+	t.Helper()
+	// t.TempDir can exceed the length limit of socket paths, so a short
+	// directory is created instead.
+	dir, err := os.MkdirTemp("", "conduittest")
+	if err != nil {
+		t.Fatalf("Failed to create socket directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return newHarness(t, filepath.Join(dir, "server.sock"), configure)
+}
+
+// NewHarnessAt is like NewHarness but listens on address, which may use any
+// registered transport. For a tcp:// or tls:// address with port 0, Address is
+// set to the port the server was given.
+func NewHarnessAt(t testing.TB, address string, configure func(*conduit.ServerConfig)) *Harness {
+	t.Helper()
+	return newHarness(t, address, configure)
+}
+
+func newHarness(t testing.TB, address string, configure func(*conduit.ServerConfig)) *Harness {
+	t.Helper()
+	cfg := conduit.DefaultServerConfig(address)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	if configure != nil {
+		configure(cfg)
+	}
+
+	h := &Harness{t: t, ready: make(map[string]*server.Connection), changed: make(chan struct{})}
+	srv := server.NewServer(cfg)
+	srv.OnConnect(h.connected)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	h.Server = srv
+	h.Address = cfg.SocketPath
+	if addr, err := conduit.ParseAddress(cfg.SocketPath); err == nil && (addr.Scheme == "tcp" || addr.Scheme == "tls") {
+		h.Address = addr.Scheme + "://" + srv.Addr().String()
+	}
+	return h
+}
+
+// connected notes each connection once the server is ready to send to it.
+func (h *Harness) connected(conn *server.Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready[conn.ID()] = conn
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Client returns a client connected to the harness server and closed when the
+// test ends. configure, if not nil, can adjust the client config first.
+// Reconnection is disabled by default. Client returns once the server is ready
+// to send to the new connection, so broadcasts reach it.
+func (h *Harness) Client(configure func(*conduit.ClientConfig)) *Client {
+	h.t.Helper()
+	cfg := conduit.DefaultClientConfig(h.Address)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.Reconnect = false
+	if configure != nil {
+		configure(cfg)
+	}
+
+	c := &Client{Client: client.NewClient(cfg), received: NewRecorder()}
+	c.HandleDefault(func(_ *client.Client, msg *conduit.Message) error {
+		c.received.Record(msg)
+		return nil
+	})
+
+	if err := c.Connect(); err != nil {
+		h.t.Fatalf("Client failed to connect: %v", err)
+	}
+	h.t.Cleanup(func() { c.Close() })
+	h.awaitReady(c.Session().ConnectionID)
+
+	h.mu.Lock()
+	h.clients = append(h.clients, c)
+	h.mu.Unlock()
+	return c
+}
+
+// awaitReady waits for the server to be ready to send to the connection with
+// the given ID, failing the test after readyTimeout.
+func (h *Harness) awaitReady(id string) {
+	h.t.Helper()
+	deadline := time.After(readyTimeout)
+	for {
+		h.mu.Lock()
+		_, ok := h.ready[id]
+		changed := h.changed
+		h.mu.Unlock()
+		if ok {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			h.t.Fatalf("Connection %q was not ready within %v", id, readyTimeout)
+		}
+	}
+}
+
+// ExpectBroadcast fails the test unless every client created by the harness
+// receives a message of msgType within timeout. It returns the messages in the
+// order the clients were created.
+func (h *Harness) ExpectBroadcast(t testing.TB, msgType string, timeout time.Duration) []*conduit.Message {
+	t.Helper()
+	h.mu.Lock()
+	clients := append([]*Client(nil), h.clients...)
+	h.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	messages := make([]*conduit.Message, len(clients))
+	for i, c := range clients {
+		msg := c.received.Next(msgType, time.Until(deadline))
+		if msg == nil {
+			t.Fatalf("Client %d of %d did not receive broadcast '%s' within %v", i+1, len(clients), msgType, timeout)
+		}
+		messages[i] = msg
+	}
+	return messages
+}
+
+// Client is a connected client that records messages no handler claimed.
+type Client struct {
+	*client.Client
+	received *Recorder
+}
+
+// Received returns the recorded messages.
+func (c *Client) Received() *Recorder {
+	return c.received
+}
+
+// ExpectMessage waits for a message of msgType, failing the test after timeout.
+// Messages of types with a registered handler are not recorded.
+func (c *Client) ExpectMessage(t testing.TB, msgType string, timeout time.Duration) *conduit.Message {
+	t.Helper()
+	return c.received.ExpectMessage(t, msgType, timeout)
+}
+
+// Conn is a server connection for calling handlers directly. Its peer is a fake
+// client that completes the handshake and records every message sent on the
+// connection.
+type Conn struct {
+	*server.Connection
+	sent *Recorder
+}
+
+// NewConn returns a connection of a private server, closed when the test ends.
+// The peer negotiates the JSON codec without compression, so recorded payloads
+// are plain JSON.
+func NewConn(t testing.TB) *Conn {
+	t.Helper()
+	cfg := conduit.DefaultServerConfig("")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(cfg)
+	t.Cleanup(func() { srv.Stop() })
+
+	// The server serves only the connection below.
+	accepted := make(chan *server.Connection, 1)
+	srv.OnConnect(func(conn *server.Connection) { accepted <- conn })
+
+	local, remote := net.Pipe()
+	go srv.ServeConn(remote)
+
+	hello := NewMessage(t, conduit.TypeHello, conduit.NewHello([]string{"json"}, nil))
+	encoder := json.NewEncoder(local)
+	decoder := json.NewDecoder(local)
+	if err := encoder.Encode(hello); err != nil {
+		t.Fatalf("Failed to send hello: %v", err)
+	}
+	var reply conduit.Message
+	if err := decoder.Decode(&reply); err != nil || reply.Type != conduit.TypeHello {
+		t.Fatalf("Handshake failed: expected '%s', got '%s' (%v)", conduit.TypeHello, reply.Type, err)
+	}
+
+	var conn *server.Connection
+	select {
+	case conn = <-accepted:
+	case <-time.After(readyTimeout):
+		t.Fatalf("Server did not complete the handshake within %v", readyTimeout)
+	}
+
+	c := &Conn{Connection: conn, sent: NewRecorder()}
+	go func() {
+		for {
+			var msg conduit.Message
+			if err := decoder.Decode(&msg); err != nil {
+				return
+			}
+			c.sent.Record(&msg)
+		}
+	}()
+	return c
+}
+
+// Sent returns the messages sent on the connection.
+func (c *Conn) Sent() *Recorder {
+	return c.sent
+}
+
+// ExpectMessage waits for a message of msgType to be sent on the connection,
+// failing the test after timeout.
+func (c *Conn) ExpectMessage(t testing.TB, msgType string, timeout time.Duration) *conduit.Message {
+	t.Helper()
+	return c.sent.ExpectMessage(t, msgType, timeout)
+}
@@ -0,0 +1,86 @@
+package conduittest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// Recorder collects messages and lets tests wait for them without sleeping.
+// It is safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []*conduit.Message
+	taken    []bool
+	changed  chan struct{}
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{changed: make(chan struct{})}
+}
+
+// Record appends msg and wakes up tests waiting for it.
+func (r *Recorder) Record(msg *conduit.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+	r.taken = append(r.taken, false)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Messages returns every message recorded so far, in order.
+func (r *Recorder) Messages() []*conduit.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*conduit.Message(nil), r.messages...)
+}
+
+// Next waits up to timeout for a message of msgType that no earlier call to
+// Next or ExpectMessage has returned, and returns it. It returns nil if none
+// arrives in time.
+func (r *Recorder) Next(msgType string, timeout time.Duration) *conduit.Message {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		r.mu.Lock()
+		for i, msg := range r.messages {
+			if !r.taken[i] && msg.Type == msgType {
+				r.taken[i] = true
+				r.mu.Unlock()
+				return msg
+			}
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return nil
+		}
+	}
+}
+
+// ExpectMessage is like Next but fails the test if no message of msgType
+// arrives within timeout.
+func (r *Recorder) ExpectMessage(t testing.TB, msgType string, timeout time.Duration) *conduit.Message {
+	t.Helper()
+	msg := r.Next(msgType, timeout)
+	if msg == nil {
+		t.Fatalf("Timeout waiting for message of type '%s' after %v", msgType, timeout)
+	}
+	return msg
+}
+
+// ExpectNoMessage fails the test if a message of msgType that was not returned
+// yet arrives within wait.
+func (r *Recorder) ExpectNoMessage(t testing.TB, msgType string, wait time.Duration) {
+	t.Helper()
+	if msg := r.Next(msgType, wait); msg != nil {
+		t.Fatalf("Expected no message of type '%s', got %s", msgType, msg.Payload)
+	}
+}
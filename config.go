@@ -1,23 +1,51 @@
 package conduit
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 // ServerConfig holds configuration options for the server.
 //
 // Fields:
-//   - SocketPath: Filesystem path to the Unix domain socket.
-//   - SocketPermissions: Filesystem permissions for the socket file.
+//   - SocketPath: Filesystem path to the Unix domain socket, or an address with a
+//     transport scheme such as "tcp://127.0.0.1:9000" or "tls://:9443" (see ParseAddress).
+//   - SocketPermissions: Filesystem permissions for the socket file. Only used for Unix sockets.
 //   - Logger: A Logger interface for outputting server logs. Defaults to a basic logger if not set.
 //   - ReadTimeout: Maximum duration for reading a single message from a client.
 //   - WriteTimeout: Maximum duration for writing a single message to a client.
 //   - MaxMessageSize: Maximum allowed size of a single message in bytes.
+//   - Codecs: Wire codecs the server accepts during the handshake, in order of preference.
+//     JSON is accepted if empty.
+//   - Compression: Payload compression algorithms the server accepts, in order of preference.
+//   - CompressionThreshold: Payloads larger than this many bytes are compressed when the
+//     connection negotiated an algorithm. Compressed payloads are sent base64 encoded,
+//     a third larger than the compressed bytes, so payloads that do not shrink by more
+//     than that are sent as they are.
+//   - RequireHandshake: If true, clients that do not start with a hello are rejected
+//     instead of being served with the legacy JSON protocol.
+//   - CancelTimeout: Maximum duration a canceled request to a client waits for the
+//     client to report whether its handler stopped.
+//   - Version: Application version reported to clients that describe the server.
+//   - DisableReflection: If true, the server does not answer conduit.reflect requests,
+//     hiding its message types from clients.
+//   - TLSConfig: Certificates and client authentication settings for the tls transport
+//     (see ServerTLSConfig).
 type ServerConfig struct {
-	SocketPath        string
-	SocketPermissions uint32
-	Logger            Logger
-	ReadTimeout       time.Duration
-	WriteTimeout      time.Duration
-	MaxMessageSize    int64
+	SocketPath           string
+	SocketPermissions    uint32
+	Logger               Logger
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	MaxMessageSize       int64
+	Codecs               []string
+	Compression          []string
+	CompressionThreshold int
+	RequireHandshake     bool
+	CancelTimeout        time.Duration
+	Version              string
+	DisableReflection    bool
+	TLSConfig            *tls.Config
 }
 
 // DefaultServerConfig returns a ServerConfig with standard default values.
@@ -28,33 +56,56 @@ type ServerConfig struct {
 //	s := server.NewServer(cfg)
 func DefaultServerConfig(socketPath string) *ServerConfig {
 	return &ServerConfig{
-		SocketPath:        socketPath,
-		SocketPermissions: 0666,
-		Logger:            NewLogger(LogInfo, nil),
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		MaxMessageSize:    32 * 1024 * 1024, // 32MB default
+		SocketPath:           socketPath,
+		SocketPermissions:    0666,
+		Logger:               NewLogger(LogInfo, nil),
+		ReadTimeout:          30 * time.Second,
+		WriteTimeout:         30 * time.Second,
+		MaxMessageSize:       32 * 1024 * 1024, // 32MB default
+		Codecs:               []string{"json", "gob"},
+		Compression:          []string{"gzip", "flate"},
+		CompressionThreshold: 64 * 1024,
+		CancelTimeout:        time.Second,
 	}
 }
 
 // ClientConfig holds configuration options for the client.
 //
 // Fields:
-//   - SocketPath: Filesystem path to the Unix domain socket the client connects to.
+//   - SocketPath: Filesystem path to the Unix domain socket the client connects to, or an
+//     address with a transport scheme such as "tcp://127.0.0.1:9000" (see ParseAddress).
 //   - Logger: A Logger interface for outputting client logs. Defaults to a basic logger if not set.
 //   - ReadTimeout: Maximum duration for reading a single message from the server.
 //   - WriteTimeout: Maximum duration for writing a single message to the server.
 //   - MaxMessageSize: Maximum allowed size of a single message in bytes.
 //   - Reconnect: If true, the client will attempt to reconnect on connection loss.
 //   - ReconnectDelay: Delay between reconnection attempts if Reconnect is true.
+//   - Codecs: Wire codecs the client offers during the handshake, in order of preference.
+//     JSON is offered if empty.
+//   - Compression: Payload compression algorithms the client offers, in order of preference.
+//   - CompressionThreshold: Payloads larger than this many bytes are compressed when the
+//     connection negotiated an algorithm. Compressed payloads are sent base64 encoded,
+//     a third larger than the compressed bytes, so payloads that do not shrink by more
+//     than that are sent as they are.
+//   - HandshakeTimeout: Maximum duration to wait for the server's reply to the hello.
+//   - CancelTimeout: Maximum duration a canceled call waits for the server to report
+//     whether its handler stopped.
+//   - TLSConfig: Server verification and client certificate settings for the tls
+//     transport (see ClientTLSConfig). The system roots are used if nil.
 type ClientConfig struct {
-	SocketPath     string
-	Logger         Logger
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	MaxMessageSize int64
-	Reconnect      bool
-	ReconnectDelay time.Duration
+	SocketPath           string
+	Logger               Logger
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	MaxMessageSize       int64
+	Reconnect            bool
+	ReconnectDelay       time.Duration
+	Codecs               []string
+	Compression          []string
+	CompressionThreshold int
+	HandshakeTimeout     time.Duration
+	CancelTimeout        time.Duration
+	TLSConfig            *tls.Config
 }
 
 // DefaultClientConfig returns a ClientConfig with standard default values.
@@ -66,12 +117,17 @@ type ClientConfig struct {
 //	if err := c.Connect(); err != nil { ... }
 func DefaultClientConfig(socketPath string) *ClientConfig {
 	return &ClientConfig{
-		SocketPath:     socketPath,
-		Logger:         NewLogger(LogInfo, nil),
-		ReadTimeout:    30 * time.Second,
-		WriteTimeout:   30 * time.Second,
-		MaxMessageSize: 32 * 1024 * 1024, // 32MB default
-		Reconnect:      true,
-		ReconnectDelay: 5 * time.Second,
+		SocketPath:           socketPath,
+		Logger:               NewLogger(LogInfo, nil),
+		ReadTimeout:          30 * time.Second,
+		WriteTimeout:         30 * time.Second,
+		MaxMessageSize:       32 * 1024 * 1024, // 32MB default
+		Reconnect:            true,
+		ReconnectDelay:       5 * time.Second,
+		Codecs:               []string{"json", "gob"},
+		Compression:          []string{"gzip", "flate"},
+		CompressionThreshold: 64 * 1024,
+		HandshakeTimeout:     5 * time.Second,
+		CancelTimeout:        time.Second,
 	}
 }
@@ -0,0 +1,55 @@
+package conduit
+
+import "encoding/json"
+
+// Version is the version of the conduit library.
+const Version = "0.1.0"
+
+// TypeReflect is the request type of the built-in introspection service. The
+// server answers it with a ServerDescription.
+const TypeReflect = "conduit.reflect"
+
+// Kinds of handler reported in a TypeDescription.
+const (
+	KindMessage      = "message"
+	KindRequest      = "request"
+	KindServerStream = "server_stream"
+	KindBidiStream   = "bidi_stream"
+	KindStream       = "stream"
+	KindChannel      = "channel"
+)
+
+// ServerDescription describes a server and the message types it handles.
+//
+// Version is the application version from ServerConfig.Version, Library the
+// conduit version the server was built with, and Protocol the protocol version
+// negotiated for the connection. Types is sorted by Type.
+type ServerDescription struct {
+	Version  string            `json:"version,omitempty"`
+	Library  string            `json:"library"`
+	Protocol int               `json:"protocol"`
+	Features []string          `json:"features,omitempty"`
+	Types    []TypeDescription `json:"types"`
+}
+
+// TypeDescription describes one message type handled by a server. Request and
+// Response hold the JSON Schemas of the payloads where the server knows them,
+// either registered explicitly or derived from a registered service; they can
+// be loaded with schema.Parse.
+type TypeDescription struct {
+	Type     string          `json:"type"`
+	Kind     string          `json:"kind"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// Lookup returns the description of msgType, or nil if the server does not
+// handle it.
+func (d *ServerDescription) Lookup(msgType string) *TypeDescription {
+	for i := range d.Types {
+		if d.Types[i].Type == msgType {
+			return &d.Types[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "context"
+
+//go:generate go run github.com/crazywolf132/conduit/cmd/conduit-gen greeter.go
+
+// Greeter is the service shared by the greeter server and client. The typed glue
+// in greeter_conduit.go is generated from it.
+//
+//conduit:service
+type Greeter interface {
+	// SayHello returns a greeting for the given name.
+	SayHello(ctx context.Context, req *HelloRequest) (*HelloReply, error)
+	// Greeted is sent to every client whenever someone is greeted.
+	Greeted(evt *GreetedEvent)
+}
+
+type HelloRequest struct {
+	Name string `json:"name"`
+}
+
+type HelloReply struct {
+	Message string `json:"message"`
+}
+
+type GreetedEvent struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,77 @@
+// Code generated by conduit-gen from greeter.go. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// Message types of the Greeter service.
+const (
+	GreeterSayHello = "Greeter.SayHello"
+	GreeterGreeted  = "Greeter.Greeted"
+)
+
+// GreeterServer is implemented by the server side of the Greeter service.
+type GreeterServer interface {
+	SayHello(ctx context.Context, req *HelloRequest) (*HelloReply, error)
+}
+
+// RegisterGreeterServer registers impl as the handler of every Greeter call on srv.
+func RegisterGreeterServer(srv *server.Server, impl GreeterServer) {
+	srv.HandleRequest(GreeterSayHello, func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		req := new(HelloRequest)
+		if err := msg.UnmarshalPayload(req); err != nil {
+			return nil, &conduit.RemoteError{Code: conduit.CodeInvalidArgument, Message: err.Error()}
+		}
+		resp, err := impl.SayHello(msg.Context(), req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// BroadcastGreeterGreeted sends a Greeted event to every client of srv.
+func BroadcastGreeterGreeted(srv *server.Server, evt *GreetedEvent, opts ...conduit.SendOption) error {
+	return srv.Broadcast(GreeterGreeted, evt, opts...)
+}
+
+// SendGreeterGreeted sends a Greeted event to the client of conn.
+func SendGreeterGreeted(conn *server.Connection, evt *GreetedEvent, opts ...conduit.SendOption) error {
+	return conn.Send(GreeterGreeted, evt, opts...)
+}
+
+// GreeterClient is a typed client of the Greeter service.
+type GreeterClient struct {
+	*client.Client
+}
+
+// NewGreeterClient wraps c in a typed Greeter client.
+func NewGreeterClient(c *client.Client) *GreeterClient {
+	return &GreeterClient{Client: c}
+}
+
+// SayHello calls Greeter.SayHello on the server.
+func (c *GreeterClient) SayHello(ctx context.Context, req *HelloRequest, opts ...conduit.SendOption) (*HelloReply, error) {
+	resp := new(HelloReply)
+	if err := c.Request(ctx, GreeterSayHello, req, resp, opts...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// OnGreeted registers handler for Greeted events from the server.
+func (c *GreeterClient) OnGreeted(handler func(*GreetedEvent) error) {
+	c.Handle(GreeterGreeted, func(_ *client.Client, msg *conduit.Message) error {
+		evt := new(GreetedEvent)
+		if err := msg.UnmarshalPayload(evt); err != nil {
+			return err
+		}
+		return handler(evt)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+const socketPath = "/tmp/greeter.sock"
+
+// greeterServer implements the generated GreeterServer interface.
+type greeterServer struct {
+	srv *server.Server
+}
+
+func (g *greeterServer) SayHello(_ context.Context, req *HelloRequest) (*HelloReply, error) {
+	BroadcastGreeterGreeted(g.srv, &GreetedEvent{Name: req.Name})
+	return &HelloReply{Message: "Hello, " + req.Name + "!"}, nil
+}
+
+// runServer serves the Greeter service until interrupted.
+func runServer() error {
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogInfo, os.Stdout)
+
+	s := server.NewServer(cfg)
+	RegisterGreeterServer(s, &greeterServer{srv: s})
+
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	// Wait for OS interrupt signals to shut down
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	return s.Stop()
+}
+
+// runClient greets the given name through the typed client.
+func runClient(name string) error {
+	cfg := conduit.DefaultClientConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogInfo, os.Stdout)
+
+	c := NewGreeterClient(client.NewClient(cfg))
+	c.OnGreeted(func(evt *GreetedEvent) error {
+		fmt.Println("Someone greeted:", evt.Name)
+		return nil
+	})
+
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := c.SayHello(ctx, &HelloRequest{Name: name})
+	if err != nil {
+		return fmt.Errorf("failed to say hello: %w", err)
+	}
+	fmt.Println("Received from server:", reply.Message)
+
+	// Give some time to receive the Greeted event
+	time.Sleep(500 * time.Millisecond)
+
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage:")
+		fmt.Println("  greeter server")
+		fmt.Println("  greeter client [name]")
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer()
+	case "client":
+		name := "world"
+		if len(os.Args) > 2 {
+			name = os.Args[2]
+		}
+		err = runClient(name)
+	default:
+		fmt.Println("Invalid command. Use 'server' or 'client'.")
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,360 @@
+// Package gateway exposes conduit servers to browsers over WebSocket and to
+// plain HTTP clients (see HTTPGateway).
+//
+// A Gateway is an http.Handler. Each WebSocket connection it accepts is bridged
+// to a conduit connection, either served in-process by a *server.Server or
+// dialed to an upstream conduit address:
+//
+//	gw := gateway.ForServer(srv, gateway.DefaultConfig(""))
+//	http.Handle("/conduit", gw)
+//
+//	gw := gateway.New(gateway.DefaultConfig("/tmp/app.sock"))
+//
+// Every WebSocket text message carries one message envelope encoded as JSON,
+// exactly as it appears on a conduit socket:
+//
+//	{"id":"1","type":"greet","payload":{"name":"web"},"expect_reply":true}
+//
+// Browsers may open with a conduit.hello to negotiate features; the gateway
+// restricts it to the JSON codec without compression. Browsers that skip the
+// hello are served as legacy peers.
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/server"
+	"github.com/crazywolf132/conduit/websocket"
+)
+
+// Config holds configuration options for the gateway.
+//
+// Fields:
+//   - Upstream: Socket path or address of the conduit server to bridge to. Unused
+//     by gateways created with ForServer.
+//   - UpstreamTLS: TLS settings when Upstream is a tls:// address.
+//   - AllowedOrigins: Origins allowed to connect, as path.Match patterns against
+//     the Origin header (e.g. "https://*.example.com"). When empty, only
+//     same-origin requests are accepted. Requests without an Origin header, such as
+//     those from non-browser clients, are always accepted.
+//   - AllowSend: Message types clients may send, as path.Match patterns. Empty
+//     allows every type. Denied requests are answered with a permission_denied
+//     RemoteError; other denied messages are dropped.
+//   - AllowReceive: Message types forwarded to clients, as path.Match patterns.
+//     Empty allows every type. Replies and stream frames are matched by their
+//     own type, e.g. "conduit.rpc.*" or "conduit.stream.*".
+//   - MaxMessageSize: Maximum allowed size of a single message in bytes.
+//   - DialTimeout: Maximum time to connect to the upstream server.
+//   - RequestTimeout: Maximum time the HTTP gateway waits for a reply. Zero waits
+//     as long as the HTTP request lasts.
+//   - Logger: A Logger interface for the gateway's logs.
+//
+// MaxMessageSize and DialTimeout take their DefaultConfig values when left zero.
+type Config struct {
+	Upstream       string
+	UpstreamTLS    *tls.Config
+	AllowedOrigins []string
+	AllowSend      []string
+	AllowReceive   []string
+	MaxMessageSize int64
+	DialTimeout    time.Duration
+	RequestTimeout time.Duration
+	Logger         conduit.Logger
+}
+
+const (
+	defaultMaxMessageSize = 32 * 1024 * 1024 // 32MB
+	defaultDialTimeout    = 5 * time.Second
+)
+
+// DefaultConfig returns a Config with standard default values.
+func DefaultConfig(upstream string) *Config {
+	return &Config{
+		Upstream:       upstream,
+		MaxMessageSize: defaultMaxMessageSize,
+		DialTimeout:    defaultDialTimeout,
+		RequestTimeout: 30 * time.Second,
+		Logger:         conduit.NewLogger(conduit.LogInfo, nil),
+	}
+}
+
+// Gateway bridges WebSocket connections to conduit connections.
+type Gateway struct {
+	config *Config
+	dial   func(ctx context.Context) (net.Conn, error)
+	conns  map[*bridge]struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+// New creates a gateway that connects each WebSocket to config.Upstream. The
+// provided config must not be nil.
+func New(config *Config) *Gateway {
+	if config == nil {
+		panic("config cannot be nil")
+	}
+	return newGateway(config, func(ctx context.Context) (net.Conn, error) {
+		return conduit.Dial(ctx, config.Upstream, config.UpstreamTLS)
+	})
+}
+
+// ForServer creates a gateway that serves each WebSocket as a connection of srv,
+// without going through a socket. The provided config must not be nil.
+func ForServer(srv *server.Server, config *Config) *Gateway {
+	if config == nil {
+		panic("config cannot be nil")
+	}
+	return newGateway(config, func(context.Context) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go srv.ServeConn(remote)
+		return local, nil
+	})
+}
+
+func newGateway(config *Config, dial func(context.Context) (net.Conn, error)) *Gateway {
+	return &Gateway{
+		config: config,
+		dial:   dial,
+		conns:  make(map[*bridge]struct{}),
+	}
+}
+
+// maxMessageSize returns MaxMessageSize, or the default if it is not set.
+func (c *Config) maxMessageSize() int64 {
+	if c.MaxMessageSize <= 0 {
+		return defaultMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
+
+// dialTimeout returns DialTimeout, or the default if it is not set.
+func (c *Config) dialTimeout() time.Duration {
+	if c.DialTimeout <= 0 {
+		return defaultDialTimeout
+	}
+	return c.DialTimeout
+}
+
+// ServeHTTP checks the request's origin, upgrades it to a WebSocket and bridges
+// it until either side closes.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(g.config, r) {
+		g.config.Logger.Warnf("Rejected WebSocket from origin %q", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	ws, err := websocket.Upgrade(w, r)
+	if err != nil {
+		g.config.Logger.Errorf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	ws.SetReadLimit(g.config.maxMessageSize())
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.config.dialTimeout())
+	upstream, err := g.dial(ctx)
+	cancel()
+	if err != nil {
+		g.config.Logger.Errorf("Failed to connect to upstream: %v", err)
+		ws.CloseWithStatus(websocket.CloseGoingAway, "upstream unavailable")
+		return
+	}
+
+	b := &bridge{gateway: g, ws: ws, upstream: upstream}
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		b.close()
+		return
+	}
+	g.conns[b] = struct{}{}
+	g.mu.Unlock()
+
+	g.config.Logger.Infof("Bridging WebSocket from %s", ws.RemoteAddr())
+	b.serve()
+
+	g.mu.Lock()
+	delete(g.conns, b)
+	g.mu.Unlock()
+	g.config.Logger.Infof("WebSocket from %s closed", ws.RemoteAddr())
+}
+
+// Close closes every bridged connection. The gateway rejects new connections
+// afterwards; stopping the HTTP server is up to the caller.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	for b := range g.conns {
+		b.close()
+	}
+	return nil
+}
+
+// checkOrigin applies AllowedOrigins to the request's Origin header.
+func checkOrigin(config *Config, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(config.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	return matchAny(config.AllowedOrigins, origin)
+}
+
+// bridge relays messages between one WebSocket and its conduit connection.
+type bridge struct {
+	gateway  *Gateway
+	ws       *websocket.Conn
+	upstream net.Conn
+	once     sync.Once
+}
+
+func (b *bridge) close() {
+	b.once.Do(func() {
+		b.ws.Close()
+		b.upstream.Close()
+	})
+}
+
+func (b *bridge) serve() {
+	defer b.close()
+
+	errc := make(chan error, 2)
+	go func() { errc <- b.fromBrowser() }()
+	go func() { errc <- b.fromUpstream() }()
+
+	err := <-errc
+	var closeErr *websocket.CloseError
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.As(err, &closeErr) {
+		b.gateway.config.Logger.Errorf("WebSocket bridge from %s failed: %v", b.ws.RemoteAddr(), err)
+	}
+}
+
+// fromBrowser forwards the browser's messages upstream, applying AllowSend.
+func (b *bridge) fromBrowser() error {
+	defer b.close()
+	encoder := json.NewEncoder(b.upstream)
+	first := true
+	for {
+		_, data, err := b.ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var msg conduit.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			b.ws.CloseWithStatus(websocket.CloseInvalidPayload, "malformed message")
+			return fmt.Errorf("malformed message: %w", err)
+		}
+
+		if msg.Type == conduit.TypeHello {
+			if !first {
+				continue
+			}
+			if err := restrictHello(&msg); err != nil {
+				return err
+			}
+		} else if !matchAny(b.gateway.config.AllowSend, msg.Type) {
+			b.deny(&msg)
+			first = false
+			continue
+		}
+		first = false
+
+		if err := encoder.Encode(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// deny answers a request whose type is not in AllowSend. Other messages are
+// dropped silently.
+func (b *bridge) deny(msg *conduit.Message) {
+	b.gateway.config.Logger.Warnf("Dropped '%s' from %s: type not allowed", msg.Type, b.ws.RemoteAddr())
+	if !msg.ExpectReply {
+		return
+	}
+	reply, err := conduit.NewMessage(conduit.TypeRPCError, &conduit.RemoteError{
+		Code:    conduit.CodePermissionDenied,
+		Message: fmt.Sprintf("message type '%s' is not allowed", msg.Type),
+	}, conduit.WithReplyTo(msg.ID))
+	if err == nil {
+		b.writeBrowser(reply)
+	}
+}
+
+// fromUpstream forwards the server's messages to the browser, applying AllowReceive.
+func (b *bridge) fromUpstream() error {
+	defer b.close()
+	limited := conduit.NewLimitedReader(b.upstream, b.gateway.config.maxMessageSize())
+	decoder := json.NewDecoder(limited)
+	for {
+		var msg conduit.Message
+		limited.Reset()
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if err := msg.Decompress(b.gateway.config.maxMessageSize()); err != nil {
+			return err
+		}
+		if msg.Type != conduit.TypeHello && !matchAny(b.gateway.config.AllowReceive, msg.Type) {
+			continue
+		}
+		if err := b.writeBrowser(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *bridge) writeBrowser(msg *conduit.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// restrictHello limits a browser's hello to what the gateway can relay: the
+// JSON codec and no compression.
+func restrictHello(msg *conduit.Message) error {
+	var hello conduit.Hello
+	if err := msg.UnmarshalPayload(&hello); err != nil {
+		return fmt.Errorf("malformed hello: %w", err)
+	}
+	hello.Codecs = []string{"json"}
+	hello.Compressors = nil
+	payload, err := json.Marshal(&hello)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
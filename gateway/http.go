@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+)
+
+// Error codes the HTTP gateway reports for failures of its own.
+const (
+	codeUnavailable      = "unavailable"
+	codeDeadlineExceeded = "deadline_exceeded"
+)
+
+// keepAliveInterval is how often idle event streams send a comment so proxies
+// and browsers keep the connection open.
+const keepAliveInterval = 30 * time.Second
+
+// headerPrefix marks HTTP request headers that are copied onto the conduit
+// message, e.g. "Conduit-Trace-Id: abc" becomes the "trace-id" header.
+const headerPrefix = "Conduit-"
+
+// HTTPGateway maps plain HTTP requests onto a conduit server, for clients such
+// as curl that cannot speak the socket protocol:
+//
+//	POST /rpc/{type}   sends the JSON request body as a request of the given
+//	                   type and answers with the JSON reply
+//	GET  /events       streams messages pushed by the server as server-sent
+//	                   events, optionally filtered with ?type=pattern
+//
+// Failed requests are answered with a JSON conduit.RemoteError and a status
+// matching its code: 400 for invalid_argument, 403 for permission_denied, 404
+// for unknown_type, 500 for handler errors, 502 if the server is unreachable
+// and 504 if it did not answer within the request timeout.
+//
+// Topics of the events endpoint are message types. Each event stream opens its
+// own upstream connection and receives whatever the server sends to every
+// client, typically messages sent with Server.Broadcast; the ?type patterns are
+// applied by the gateway, so the server is not told which topics a stream
+// wants and still sends it every broadcast. Messages whose type or ID contains
+// a line break cannot be framed as events and are dropped.
+//
+// Mount it under a prefix with http.StripPrefix. The Config's AllowedOrigins
+// applies to both endpoints, AllowSend to the request types and AllowReceive to
+// the event types.
+type HTTPGateway struct {
+	config *Config
+	mux    *http.ServeMux
+	client *client.Client
+	mu     sync.Mutex
+}
+
+// NewHTTP creates an HTTP gateway to config.Upstream. The provided config must
+// not be nil. The upstream connection is opened on the first request.
+func NewHTTP(config *Config) *HTTPGateway {
+	if config == nil {
+		panic("config cannot be nil")
+	}
+	h := &HTTPGateway{
+		config: config,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("POST /rpc/{type}", h.handleRPC)
+	h.mux.HandleFunc("GET /events", h.handleEvents)
+	return h
+}
+
+// ServeHTTP routes requests to the gateway's endpoints.
+func (h *HTTPGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(h.config, r) {
+		h.config.Logger.Warnf("Rejected HTTP request from origin %q", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// Close closes the upstream connection used for requests. Event streams close
+// their own connections when their HTTP requests end.
+func (h *HTTPGateway) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.client == nil {
+		return nil
+	}
+	err := h.client.Close()
+	h.client = nil
+	return err
+}
+
+func (h *HTTPGateway) handleRPC(w http.ResponseWriter, r *http.Request) {
+	msgType := r.PathValue("type")
+	if !matchAny(h.config.AllowSend, msgType) {
+		writeError(w, http.StatusForbidden, &conduit.RemoteError{
+			Code:    conduit.CodePermissionDenied,
+			Message: fmt.Sprintf("message type '%s' is not allowed", msgType),
+		})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config.maxMessageSize()))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, &conduit.RemoteError{Code: conduit.CodeInvalidArgument, Message: err.Error()})
+		return
+	}
+	payload := json.RawMessage("null")
+	if len(bytes.TrimSpace(body)) > 0 {
+		if !json.Valid(body) {
+			writeError(w, http.StatusBadRequest, &conduit.RemoteError{Code: conduit.CodeInvalidArgument, Message: "request body is not valid JSON"})
+			return
+		}
+		payload = body
+	}
+
+	c, err := h.upstream()
+	if err != nil {
+		h.config.Logger.Errorf("Failed to connect to upstream: %v", err)
+		writeError(w, http.StatusBadGateway, &conduit.RemoteError{Code: codeUnavailable, Message: "upstream unavailable"})
+		return
+	}
+
+	ctx := r.Context()
+	if h.config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.config.RequestTimeout)
+		defer cancel()
+	}
+
+	var reply json.RawMessage
+	if err := c.Request(ctx, msgType, payload, &reply, messageHeaders(r.Header)...); err != nil {
+		status, remoteErr := httpError(err)
+		writeError(w, status, remoteErr)
+		return
+	}
+	if reply == nil {
+		reply = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+	w.Write([]byte("\n"))
+}
+
+func (h *HTTPGateway) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	patterns := r.URL.Query()["type"]
+
+	events := make(chan *conduit.Message, 16)
+	c := client.NewClient(h.clientConfig())
+	c.HandleDefault(func(_ *client.Client, msg *conduit.Message) error {
+		if !matchAny(h.config.AllowReceive, msg.Type) || !matchAny(patterns, msg.Type) {
+			return nil
+		}
+		if strings.ContainsAny(msg.Type+msg.ID, "\r\n") {
+			h.config.Logger.Warnf("Dropped event %q: line breaks cannot be sent in an event stream", msg.Type)
+			return nil
+		}
+		select {
+		case events <- msg:
+		case <-r.Context().Done():
+		}
+		return nil
+	})
+	if err := c.Connect(); err != nil {
+		h.config.Logger.Errorf("Failed to connect to upstream: %v", err)
+		writeError(w, http.StatusBadGateway, &conduit.RemoteError{Code: codeUnavailable, Message: "upstream unavailable"})
+		return
+	}
+	defer c.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg := <-events:
+			if err := writeEvent(w, msg); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// upstream returns the shared connection for requests, connecting if needed.
+func (h *HTTPGateway) upstream() (*client.Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.client != nil {
+		return h.client, nil
+	}
+	c := client.NewClient(h.clientConfig())
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	h.client = c
+	return c, nil
+}
+
+func (h *HTTPGateway) clientConfig() *conduit.ClientConfig {
+	cfg := conduit.DefaultClientConfig(h.config.Upstream)
+	cfg.Logger = h.config.Logger
+	cfg.TLSConfig = h.config.UpstreamTLS
+	cfg.MaxMessageSize = h.config.maxMessageSize()
+	cfg.ReadTimeout = 0
+	return cfg
+}
+
+// writeEvent writes msg as a server-sent event named after its type. The type
+// and ID must not contain line breaks, which would end the field early.
+func writeEvent(w io.Writer, msg *conduit.Message) error {
+	var data bytes.Buffer
+	if err := json.Compact(&data, msg.Payload); err != nil {
+		return err
+	}
+	var event bytes.Buffer
+	if msg.ID != "" {
+		fmt.Fprintf(&event, "id: %s\n", msg.ID)
+	}
+	fmt.Fprintf(&event, "event: %s\ndata: %s\n\n", msg.Type, data.Bytes())
+	_, err := w.Write(event.Bytes())
+	return err
+}
+
+// messageHeaders turns Conduit-* request headers into message headers.
+func messageHeaders(header http.Header) []conduit.SendOption {
+	var opts []conduit.SendOption
+	for name, values := range header {
+		if len(values) == 0 || !strings.HasPrefix(name, headerPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, headerPrefix))
+		opts = append(opts, conduit.WithHeader(key, values[0]))
+	}
+	return opts
+}
+
+// httpError maps a failed request to a status code and the error body.
+func httpError(err error) (int, *conduit.RemoteError) {
+	var remoteErr *conduit.RemoteError
+	switch {
+	case errors.As(err, &remoteErr):
+		switch remoteErr.Code {
+		case conduit.CodeInvalidArgument:
+			return http.StatusBadRequest, remoteErr
+		case conduit.CodePermissionDenied:
+			return http.StatusForbidden, remoteErr
+		case conduit.CodeUnknownType:
+			return http.StatusNotFound, remoteErr
+		default:
+			return http.StatusInternalServerError, remoteErr
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, &conduit.RemoteError{Code: codeDeadlineExceeded, Message: "request timed out"}
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, &conduit.RemoteError{Code: conduit.CodeCanceled, Message: "request canceled"}
+	default:
+		return http.StatusBadGateway, &conduit.RemoteError{Code: codeUnavailable, Message: err.Error()}
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, remoteErr *conduit.RemoteError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(remoteErr)
+}
@@ -0,0 +1,423 @@
+// Package gen generates typed conduit servers and clients from a service
+// definition written as a Go interface.
+//
+// A service is an interface marked with a "conduit:service" comment:
+//
+//	//conduit:service
+//	type Greeter interface {
+//		SayHello(ctx context.Context, req *HelloRequest) (*HelloReply, error)
+//		Greeted(evt *GreetedEvent)
+//	}
+//
+// Methods of the form func(context.Context, *Req) (*Resp, error) become
+// request/reply calls, and methods taking a single pointer and returning nothing
+// become events sent from the server to its clients. Message types are named
+// "<Service>.<Method>", matching server.RegisterService. The generated client
+// embeds *client.Client, so methods may not reuse its method names, such as Send
+// or Close.
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/crazywolf132/conduit/client"
+)
+
+// ServiceMarker is the comment that marks an interface as a service definition.
+const ServiceMarker = "conduit:service"
+
+// File is a parsed service definition file.
+type File struct {
+	Package  string
+	Imports  []string
+	Services []*Service
+}
+
+// HasMethods returns true if any service in the file has request/reply methods.
+func (f *File) HasMethods() bool {
+	for _, svc := range f.Services {
+		if len(svc.Methods) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Service describes one service interface.
+type Service struct {
+	Name    string
+	Methods []*Method
+	Events  []*Event
+}
+
+// Method is a request/reply call. Request and Response are the payload types
+// without the leading pointer, e.g. "HelloRequest" or "models.User".
+type Method struct {
+	Name     string
+	Request  string
+	Response string
+}
+
+// Event is a message sent from the server to its clients.
+type Event struct {
+	Name    string
+	Payload string
+}
+
+// Parse reads the service definitions from a Go source file. If names is
+// non-empty, only the interfaces with those names are used, whether or not they
+// carry the service marker.
+func Parse(filename string, src []byte, names []string) (*File, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Package: f.Name.Name}
+	used := make(map[string]bool)
+
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			iface, ok := ts.Type.(*ast.InterfaceType)
+			if !ok || !wanted(ts, gd, names) {
+				continue
+			}
+			svc, err := parseService(fset, ts.Name.Name, iface, used)
+			if err != nil {
+				return nil, err
+			}
+			file.Services = append(file.Services, svc)
+		}
+	}
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("%s: no service interfaces found", filename)
+	}
+
+	imported := make(map[string]bool)
+	for _, imp := range f.Imports {
+		importPath := strings.Trim(imp.Path.Value, `"`)
+		name := assumedName(importPath)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		imported[name] = true
+		if used[name] && importPath != "context" {
+			spec := imp.Path.Value
+			if imp.Name != nil {
+				spec = imp.Name.Name + " " + spec
+			}
+			file.Imports = append(file.Imports, spec)
+		}
+	}
+	for name := range used {
+		if !imported[name] {
+			return nil, fmt.Errorf("%s: no import found for package %s; if its name differs from the import path, import it with an explicit name",
+				filename, name)
+		}
+	}
+	sort.Strings(file.Imports)
+	return file, nil
+}
+
+// assumedName returns the package name an unnamed import is expected to have,
+// following the same conventions as goimports: the last path element, skipping
+// a major version suffix like "/v2", without a "go-" prefix and cut at the first
+// character that cannot appear in an identifier, so "gopkg.in/yaml.v3" is yaml.
+func assumedName(importPath string) string {
+	base := path.Base(importPath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := path.Dir(importPath); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// clientMethods are the methods of *client.Client. The generated typed client
+// embeds it, so service methods with these names would silently shadow them.
+var clientMethods = func() map[string]bool {
+	t := reflect.TypeOf(&client.Client{})
+	names := make(map[string]bool, t.NumMethod())
+	for i := 0; i < t.NumMethod(); i++ {
+		names[t.Method(i).Name] = true
+	}
+	return names
+}()
+
+// checkClientNames rejects services whose generated client methods would clash
+// with the embedded *client.Client or with each other.
+func checkClientNames(fset *token.FileSet, svc *Service, iface *ast.InterfaceType) error {
+	seen := make(map[string]string)
+	add := func(name, from string) error {
+		if clientMethods[name] {
+			return fmt.Errorf("%s: %s.%s: generated client method %s would shadow client.Client.%s; rename it",
+				fset.Position(iface.Pos()), svc.Name, from, name, name)
+		}
+		if other, exists := seen[name]; exists {
+			return fmt.Errorf("%s: %s.%s and %s.%s both generate client method %s",
+				fset.Position(iface.Pos()), svc.Name, other, svc.Name, from, name)
+		}
+		seen[name] = from
+		return nil
+	}
+	for _, m := range svc.Methods {
+		if err := add(m.Name, m.Name); err != nil {
+			return err
+		}
+	}
+	for _, e := range svc.Events {
+		if err := add("On"+e.Name, e.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func wanted(ts *ast.TypeSpec, gd *ast.GenDecl, names []string) bool {
+	if len(names) > 0 {
+		for _, name := range names {
+			if ts.Name.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, doc := range []*ast.CommentGroup{ts.Doc, gd.Doc} {
+		if doc == nil {
+			continue
+		}
+		for _, c := range doc.List {
+			if strings.TrimSpace(strings.TrimPrefix(c.Text, "//")) == ServiceMarker {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseService(fset *token.FileSet, name string, iface *ast.InterfaceType, used map[string]bool) (*Service, error) {
+	svc := &Service{Name: name}
+	for _, field := range iface.Methods.List {
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", fset.Position(field.Pos()))
+		}
+		methodName := field.Names[0].Name
+		params := expand(fn.Params)
+		results := expand(fn.Results)
+		pos := fset.Position(field.Pos())
+
+		switch {
+		case len(params) == 2 && len(results) == 2:
+			if typeString(fset, params[0]) != "context.Context" {
+				return nil, fmt.Errorf("%s: %s.%s: first argument must be context.Context", pos, name, methodName)
+			}
+			if typeString(fset, results[1]) != "error" {
+				return nil, fmt.Errorf("%s: %s.%s: second result must be error", pos, name, methodName)
+			}
+			req, err := pointerElem(fset, params[1], used)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.%s: request %v", pos, name, methodName, err)
+			}
+			resp, err := pointerElem(fset, results[0], used)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.%s: response %v", pos, name, methodName, err)
+			}
+			svc.Methods = append(svc.Methods, &Method{Name: methodName, Request: req, Response: resp})
+		case len(params) == 1 && len(results) == 0:
+			payload, err := pointerElem(fset, params[0], used)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.%s: event %v", pos, name, methodName, err)
+			}
+			svc.Events = append(svc.Events, &Event{Name: methodName, Payload: payload})
+		default:
+			return nil, fmt.Errorf("%s: %s.%s: must be func(context.Context, *Req) (*Resp, error) or func(*Event)",
+				pos, name, methodName)
+		}
+	}
+	if len(svc.Methods) == 0 && len(svc.Events) == 0 {
+		return nil, fmt.Errorf("%s: service %s has no methods", fset.Position(iface.Pos()), name)
+	}
+	if err := checkClientNames(fset, svc, iface); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// expand returns one type expression per parameter, so "a, b *T" counts twice.
+func expand(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+func pointerElem(fset *token.FileSet, expr ast.Expr, used map[string]bool) (string, error) {
+	star, ok := expr.(*ast.StarExpr)
+	if !ok {
+		return "", fmt.Errorf("type %s must be a pointer", typeString(fset, expr))
+	}
+	if sel, ok := star.X.(*ast.SelectorExpr); ok {
+		if pkg, ok := sel.X.(*ast.Ident); ok {
+			used[pkg.Name] = true
+		}
+	}
+	return typeString(fset, star.X), nil
+}
+
+func typeString(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, expr)
+	return buf.String()
+}
+
+// Generate returns the formatted Go source of the typed server glue and client
+// wrapper for every service in file. source names the definition file in the
+// generated header.
+func Generate(file *File, source string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, struct {
+		*File
+		Source string
+	}{file, source}); err != nil {
+		return nil, err
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return out, nil
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by conduit-gen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .HasMethods}}
+	"context"
+
+{{end}}
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+{{range $svc := .Services}}
+// Message types of the {{$svc.Name}} service.
+const (
+{{- range $svc.Methods}}
+	{{$svc.Name}}{{.Name}} = "{{$svc.Name}}.{{.Name}}"
+{{- end}}
+{{- range $svc.Events}}
+	{{$svc.Name}}{{.Name}} = "{{$svc.Name}}.{{.Name}}"
+{{- end}}
+)
+
+// {{$svc.Name}}Server is implemented by the server side of the {{$svc.Name}} service.
+type {{$svc.Name}}Server interface {
+{{- range $svc.Methods}}
+	{{.Name}}(ctx context.Context, req *{{.Request}}) (*{{.Response}}, error)
+{{- end}}
+}
+
+// Register{{$svc.Name}}Server registers impl as the handler of every {{$svc.Name}} call on srv.
+func Register{{$svc.Name}}Server(srv *server.Server, impl {{$svc.Name}}Server) {
+{{- range $svc.Methods}}
+	srv.HandleRequest({{$svc.Name}}{{.Name}}, func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		req := new({{.Request}})
+		if err := msg.UnmarshalPayload(req); err != nil {
+			return nil, &conduit.RemoteError{Code: conduit.CodeInvalidArgument, Message: err.Error()}
+		}
+		resp, err := impl.{{.Name}}(msg.Context(), req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+{{- end}}
+}
+{{range $svc.Events}}
+// Broadcast{{$svc.Name}}{{.Name}} sends a {{.Name}} event to every client of srv.
+func Broadcast{{$svc.Name}}{{.Name}}(srv *server.Server, evt *{{.Payload}}, opts ...conduit.SendOption) error {
+	return srv.Broadcast({{$svc.Name}}{{.Name}}, evt, opts...)
+}
+
+// Send{{$svc.Name}}{{.Name}} sends a {{.Name}} event to the client of conn.
+func Send{{$svc.Name}}{{.Name}}(conn *server.Connection, evt *{{.Payload}}, opts ...conduit.SendOption) error {
+	return conn.Send({{$svc.Name}}{{.Name}}, evt, opts...)
+}
+{{end}}
+// {{$svc.Name}}Client is a typed client of the {{$svc.Name}} service.
+type {{$svc.Name}}Client struct {
+	*client.Client
+}
+
+// New{{$svc.Name}}Client wraps c in a typed {{$svc.Name}} client.
+func New{{$svc.Name}}Client(c *client.Client) *{{$svc.Name}}Client {
+	return &{{$svc.Name}}Client{Client: c}
+}
+{{range $svc.Methods}}
+// {{.Name}} calls {{$svc.Name}}.{{.Name}} on the server.
+func (c *{{$svc.Name}}Client) {{.Name}}(ctx context.Context, req *{{.Request}}, opts ...conduit.SendOption) (*{{.Response}}, error) {
+	resp := new({{.Response}})
+	if err := c.Request(ctx, {{$svc.Name}}{{.Name}}, req, resp, opts...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+{{end}}
+{{- range $svc.Events}}
+// On{{.Name}} registers handler for {{.Name}} events from the server.
+func (c *{{$svc.Name}}Client) On{{.Name}}(handler func(*{{.Payload}}) error) {
+	c.Handle({{$svc.Name}}{{.Name}}, func(_ *client.Client, msg *conduit.Message) error {
+		evt := new({{.Payload}})
+		if err := msg.UnmarshalPayload(evt); err != nil {
+			return err
+		}
+		return handler(evt)
+	})
+}
+{{end}}
+{{- end}}
+`))
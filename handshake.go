@@ -0,0 +1,240 @@
+package conduit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Protocol versions spoken by this implementation. Peers negotiate the highest
+// version both support; version 0 denotes a legacy peer that skips the handshake.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// TypeHello is the reserved message type of the handshake. The client sends a
+// hello as its first message and the server answers with the negotiated settings.
+const TypeHello = "conduit.hello"
+
+// Features that can be advertised during the handshake. Both peers must
+// advertise a feature for it to be enabled on a connection.
+const (
+	FeatureQueues    = "queues"
+	FeaturePriority  = "priority"
+	FeatureDeadlines = "deadlines"
+	FeatureHeaders   = "headers"
+	FeatureStreams   = "streams"
+	FeatureRPC       = "rpc"
+)
+
+// Features returns the features supported by this implementation.
+func Features() []string {
+	return []string{FeatureQueues, FeaturePriority, FeatureDeadlines, FeatureHeaders, FeatureStreams, FeatureRPC}
+}
+
+// ErrIncompatiblePeer is returned when the handshake finds no common protocol
+// version or codec, or the peer rejects the connection.
+var ErrIncompatiblePeer = errors.New("incompatible peer")
+
+// HandshakeError describes why a handshake failed. It wraps ErrIncompatiblePeer.
+type HandshakeError struct {
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake failed: %s", e.Reason)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return ErrIncompatiblePeer
+}
+
+// Hello is the payload of a TypeHello message.
+//
+// In the client's hello, Codecs and Compressors list the codecs and compression
+// algorithms it can speak in order of preference. The server's reply carries the
+// chosen Codec and Compression (empty for none), the agreed Version and Features
+// and the ConnectionID the server gave the connection, or an Error if the client
+// was rejected.
+type Hello struct {
+	Version      int      `json:"version"`
+	MinVersion   int      `json:"min_version"`
+	Codecs       []string `json:"codecs,omitempty"`
+	Compressors  []string `json:"compressors,omitempty"`
+	Features     []string `json:"features,omitempty"`
+	Codec        string   `json:"codec,omitempty"`
+	Compression  string   `json:"compression,omitempty"`
+	ConnectionID string   `json:"connection_id,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// NewHello returns the hello advertised by this implementation for the given
+// codec and compression preference lists. Names that are not registered are
+// left out. An empty codec list offers JSON, which every peer speaks.
+func NewHello(codecNames, compressorNames []string) *Hello {
+	if len(codecNames) == 0 {
+		codecNames = []string{"json"}
+	}
+	codecs := make([]string, 0, len(codecNames))
+	for _, name := range codecNames {
+		if _, ok := LookupCodec(name); ok {
+			codecs = append(codecs, name)
+		}
+	}
+	var compressors []string
+	for _, name := range compressorNames {
+		if _, ok := LookupCompressor(name); ok {
+			compressors = append(compressors, name)
+		}
+	}
+	return &Hello{
+		Version:     ProtocolVersion,
+		MinVersion:  MinProtocolVersion,
+		Codecs:      codecs,
+		Compressors: compressors,
+		Features:    Features(),
+	}
+}
+
+// Session holds the settings agreed for a connection during the handshake.
+// Compression is empty if the peers share no compression algorithm.
+// ConnectionID is the server's ID for the connection, as returned by its
+// Connection.ID, or empty if the server did not send one.
+type Session struct {
+	Version      int
+	Codec        string
+	Compression  string
+	Features     []string
+	ConnectionID string
+}
+
+// LegacySession returns the session used for peers that skip the handshake:
+// protocol version 0, the JSON codec and no optional features.
+func LegacySession() *Session {
+	return &Session{Codec: "json"}
+}
+
+// HasFeature returns true if the feature was enabled by both peers.
+func (s *Session) HasFeature(feature string) bool {
+	for _, f := range s.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate picks the best settings common to the local and remote hellos. The
+// local codec order wins, so the server's preference decides between codecs both
+// sides support. Returns a *HandshakeError if the peers are incompatible.
+func Negotiate(local, remote *Hello) (*Session, error) {
+	version := local.Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+	if version < local.MinVersion || version < remote.MinVersion {
+		return nil, &HandshakeError{Reason: fmt.Sprintf(
+			"no common protocol version (local %d-%d, remote %d-%d)",
+			local.MinVersion, local.Version, remote.MinVersion, remote.Version)}
+	}
+
+	codec := pickCommon(local.Codecs, remote.Codecs)
+	if codec == "" {
+		return nil, &HandshakeError{Reason: fmt.Sprintf(
+			"no common codec (local %v, remote %v)", local.Codecs, remote.Codecs)}
+	}
+
+	var features []string
+	for _, f := range local.Features {
+		if contains(remote.Features, f) {
+			features = append(features, f)
+		}
+	}
+
+	return &Session{
+		Version:     version,
+		Codec:       codec,
+		Compression: pickCommon(local.Compressors, remote.Compressors),
+		Features:    features,
+	}, nil
+}
+
+// Accept validates the server's reply to a hello sent by the client and returns
+// the resulting session.
+func (h *Hello) Accept(reply *Hello) (*Session, error) {
+	if reply.Error != "" {
+		return nil, &HandshakeError{Reason: reply.Error}
+	}
+	if reply.Version < h.MinVersion || reply.Version > h.Version {
+		return nil, &HandshakeError{Reason: fmt.Sprintf("server chose unsupported protocol version %d", reply.Version)}
+	}
+	if !contains(h.Codecs, reply.Codec) {
+		return nil, &HandshakeError{Reason: fmt.Sprintf("server chose unsupported codec %q", reply.Codec)}
+	}
+	if reply.Compression != "" && !contains(h.Compressors, reply.Compression) {
+		return nil, &HandshakeError{Reason: fmt.Sprintf("server chose unsupported compression %q", reply.Compression)}
+	}
+	return &Session{
+		Version:      reply.Version,
+		Codec:        reply.Codec,
+		Compression:  reply.Compression,
+		Features:     reply.Features,
+		ConnectionID: reply.ConnectionID,
+	}, nil
+}
+
+// Reply returns the hello the server sends back for a negotiated session.
+func (s *Session) Reply() *Hello {
+	return &Hello{
+		Version:      s.Version,
+		MinVersion:   s.Version,
+		Codec:        s.Codec,
+		Compression:  s.Compression,
+		Features:     s.Features,
+		ConnectionID: s.ConnectionID,
+	}
+}
+
+func pickCommon(preferred, other []string) string {
+	for _, name := range preferred {
+		if contains(other, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// HandshakeRemainder returns the stream that follows a JSON-encoded hello read by
+// helloDecoder from r. Bytes the decoder buffered ahead are replayed first, and
+// the newline the JSON encoder writes after the hello is dropped so binary codecs
+// start on a clean frame boundary.
+func HandshakeRemainder(helloDecoder *json.Decoder, r io.Reader) io.Reader {
+	return &trimNewlineReader{r: io.MultiReader(helloDecoder.Buffered(), r)}
+}
+
+type trimNewlineReader struct {
+	r       io.Reader
+	trimmed bool
+}
+
+func (t *trimNewlineReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if !t.trimmed && n > 0 {
+		t.trimmed = true
+		if p[0] == '\n' {
+			n = copy(p, p[1:n])
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package conduit
+
+// InboxSize is how many handler calls an Inbox queues before Push blocks.
+const InboxSize = 1024
+
+// Inbox runs the message handlers of one connection on a dedicated goroutine,
+// one at a time and in the order they were pushed. The connection's read loop
+// keeps reading meanwhile, so a handler can wait for the reply to a request it
+// sent on the same connection. Once InboxSize calls are queued, Push blocks,
+// holding back the read loop until the handlers catch up.
+type Inbox struct {
+	queue chan func()
+	done  chan struct{}
+}
+
+// NewInbox creates an Inbox and starts its goroutine. Call Close to stop it.
+func NewInbox() *Inbox {
+	b := &Inbox{
+		queue: make(chan func(), InboxSize),
+		done:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *Inbox) run() {
+	defer close(b.done)
+	for fn := range b.queue {
+		fn()
+	}
+}
+
+// Push queues fn to run after every call pushed before it. It must not be called
+// after Close.
+func (b *Inbox) Push(fn func()) {
+	b.queue <- fn
+}
+
+// Close stops accepting calls. Calls already queued still run; Done is closed
+// once the last one has returned.
+func (b *Inbox) Close() {
+	close(b.queue)
+}
+
+// Done returns a channel that is closed once the Inbox has been closed and every
+// queued call has run.
+func (b *Inbox) Done() <-chan struct{} {
+	return b.done
+}
@@ -34,3 +34,9 @@ func (l *LimitedReader) Read(p []byte) (n int, err error) {
 	}
 	return n, err
 }
+
+// Reset starts a new limit window. Connections call it before decoding each
+// message so the limit applies per message rather than to the whole connection.
+func (l *LimitedReader) Reset() {
+	l.consumed = 0
+}
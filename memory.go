@@ -0,0 +1,263 @@
+package conduit
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// memoryBufferSize is how many bytes a memory connection holds in each
+// direction before writes block, like the buffer of a socket.
+const memoryBufferSize = 1 << 20
+
+// memoryTransport connects servers and clients in the same process without
+// touching the filesystem or the network. Addresses are arbitrary names, e.g.
+// "memory://app", and each connection is a pair of buffered pipes. A server
+// listening on a name is reachable as soon as Listen returns, so tests need not
+// wait for it.
+type memoryTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+func (t *memoryTransport) Listen(name string, _ *tls.Config) (net.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.listeners[name]; exists {
+		return nil, fmt.Errorf("memory address %q already in use", name)
+	}
+	l := &memoryListener{
+		transport: t,
+		addr:      memoryAddr(name),
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	t.listeners[name] = l
+	return l, nil
+}
+
+func (t *memoryTransport) Dial(ctx context.Context, name string, _ *tls.Config) (net.Conn, error) {
+	t.mu.Lock()
+	l, exists := t.listeners[name]
+	t.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("dial memory://%s: no listener", name)
+	}
+
+	local, remote := newMemoryConns(l.addr)
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.done:
+		return nil, fmt.Errorf("dial memory://%s: %w", name, net.ErrClosed)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// memoryListener hands out the server ends of connections created by Dial.
+type memoryListener struct {
+	transport *memoryTransport
+	addr      memoryAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	once      sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections and frees the name for other listeners.
+// Connections already accepted stay open.
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.transport.mu.Lock()
+		if l.transport.listeners[string(l.addr)] == l {
+			delete(l.transport.listeners, string(l.addr))
+		}
+		l.transport.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memoryAddr is the net.Addr of the memory transport.
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryConn is one end of an in-memory connection. Unlike net.Pipe, writes
+// complete as soon as the data fits in the peer's buffer, so both ends may write
+// at the same time without waiting for the other to read, as over a socket.
+type memoryConn struct {
+	in   *memoryBuffer
+	out  *memoryBuffer
+	addr memoryAddr
+	once sync.Once
+}
+
+func newMemoryConns(addr memoryAddr) (*memoryConn, *memoryConn) {
+	toServer, toClient := newMemoryBuffer(), newMemoryBuffer()
+	return &memoryConn{in: toClient, out: toServer, addr: addr},
+		&memoryConn{in: toServer, out: toClient, addr: addr}
+}
+
+func (c *memoryConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	return c.out.write(b)
+}
+
+// Close closes both directions. The peer reads what was already written and
+// then io.EOF; its writes fail.
+func (c *memoryConn) Close() error {
+	c.once.Do(func() {
+		c.in.close(&c.in.readerClosed)
+		c.out.close(&c.out.writerClosed)
+	})
+	return nil
+}
+
+func (c *memoryConn) LocalAddr() net.Addr  { return c.addr }
+func (c *memoryConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	c.in.setDeadline(&c.in.readDeadline, t)
+	c.out.setDeadline(&c.out.writeDeadline, t)
+	return nil
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(&c.in.readDeadline, t)
+	return nil
+}
+
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	c.out.setDeadline(&c.out.writeDeadline, t)
+	return nil
+}
+
+// memoryBuffer carries the bytes of one direction of a memory connection. The
+// receiving end's read deadline and the sending end's write deadline are kept
+// apart, so neither end's deadlines affect the other.
+type memoryBuffer struct {
+	mu            sync.Mutex
+	data          []byte
+	readerClosed  bool
+	writerClosed  bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	changed       chan struct{} // closed and replaced whenever the state changes
+}
+
+func newMemoryBuffer() *memoryBuffer {
+	return &memoryBuffer{changed: make(chan struct{})}
+}
+
+// notify wakes everyone waiting for the buffer to change. b.mu must be held.
+func (b *memoryBuffer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *memoryBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		switch {
+		case b.readerClosed:
+			return 0, io.ErrClosedPipe
+		case len(b.data) > 0:
+			n := copy(p, b.data)
+			b.data = b.data[n:]
+			b.notify()
+			return n, nil
+		case b.writerClosed:
+			return 0, io.EOF
+		}
+		if err := b.wait(b.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (b *memoryBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	written := 0
+	for {
+		if b.writerClosed || b.readerClosed {
+			return written, io.ErrClosedPipe
+		}
+		if written == len(p) {
+			return written, nil
+		}
+		if space := memoryBufferSize - len(b.data); space > 0 {
+			n := min(space, len(p)-written)
+			b.data = append(b.data, p[written:written+n]...)
+			written += n
+			b.notify()
+			continue
+		}
+		if err := b.wait(b.writeDeadline); err != nil {
+			return written, err
+		}
+	}
+}
+
+// wait releases b.mu until the buffer changes or deadline passes. b.mu must be
+// held.
+func (b *memoryBuffer) wait(deadline time.Time) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	changed := b.changed
+	b.mu.Unlock()
+	defer b.mu.Lock()
+	select {
+	case <-changed:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// setDeadline sets one of b's deadlines and wakes waiters to apply it.
+func (b *memoryBuffer) setDeadline(deadline *time.Time, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*deadline = t
+	b.notify()
+}
+
+func (b *memoryBuffer) close(flag *bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*flag = true
+	b.notify()
+}
@@ -0,0 +1,190 @@
+package conduit
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrConnectionClosed is returned when sending on a connection that has been closed.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// Priority is the priority class of an outgoing message. Higher priorities are
+// written to the connection first; messages of equal priority keep their order.
+type Priority int
+
+const (
+	// PriorityLow is for bulk traffic that may be delayed behind everything else.
+	PriorityLow Priority = -1
+	// PriorityNormal is the default priority for messages.
+	PriorityNormal Priority = 0
+	// PriorityHigh is for messages that should overtake normal traffic.
+	PriorityHigh Priority = 1
+	// PriorityUrgent is for control messages such as "cancel" or "shutdown".
+	PriorityUrgent Priority = 2
+)
+
+const numPriorities = int(PriorityUrgent-PriorityLow) + 1
+
+// starvationLimit is how many times a waiting priority class may be overtaken by
+// higher classes before it is served anyway.
+const starvationLimit = 8
+
+// Outbox serializes writes to a single connection, serving higher priority
+// messages first. A dedicated goroutine performs the writes; Send blocks until
+// the message has been written or the outbox is closed. Messages that expire
+// while queued are dropped and fail with ErrMessageExpired.
+//
+// To prevent starvation, a non-empty priority class that has been overtaken
+// several times in a row is served ahead of higher classes.
+type Outbox struct {
+	write   func(*Message) error
+	mu      sync.Mutex
+	queues  [numPriorities][]*outboxItem
+	skipped [numPriorities]int
+	notify  chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+type outboxItem struct {
+	msg    *Message
+	result chan error
+}
+
+// NewOutbox creates an Outbox that hands messages to write one at a time and
+// starts its writer goroutine. Call Close to stop it.
+func NewOutbox(write func(*Message) error) *Outbox {
+	o := &Outbox{
+		write:  write,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// Send queues msg according to its priority and waits until it has been written.
+// Returns the write error, or ErrConnectionClosed if the outbox is closed first.
+func (o *Outbox) Send(msg *Message) error {
+	item := &outboxItem{msg: msg, result: make(chan error, 1)}
+
+	if msg.Expired() {
+		return ErrMessageExpired
+	}
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return ErrConnectionClosed
+	}
+	level := priorityLevel(msg.Priority)
+	o.queues[level] = append(o.queues[level], item)
+	o.mu.Unlock()
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return <-item.result
+}
+
+// Len returns the number of messages waiting to be written.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for level := range o.queues {
+		n += len(o.queues[level])
+	}
+	return n
+}
+
+// Close stops the writer goroutine and fails all queued messages with
+// ErrConnectionClosed. It is safe to call multiple times.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.closed = true
+	var pending []*outboxItem
+	for level := range o.queues {
+		pending = append(pending, o.queues[level]...)
+		o.queues[level] = nil
+	}
+	o.mu.Unlock()
+
+	close(o.done)
+	for _, item := range pending {
+		item.result <- ErrConnectionClosed
+	}
+}
+
+func (o *Outbox) run() {
+	for {
+		item := o.next()
+		if item == nil {
+			select {
+			case <-o.done:
+				return
+			case <-o.notify:
+				continue
+			}
+		}
+		if item.msg.Expired() {
+			item.result <- ErrMessageExpired
+			continue
+		}
+		item.result <- o.write(item.msg)
+	}
+}
+
+// next pops the message that should be written next, or returns nil if the
+// outbox is empty.
+func (o *Outbox) next() *outboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	chosen := -1
+	for level := 0; level < numPriorities; level++ {
+		if len(o.queues[level]) > 0 && o.skipped[level] >= starvationLimit {
+			chosen = level
+			break
+		}
+	}
+	if chosen < 0 {
+		for level := numPriorities - 1; level >= 0; level-- {
+			if len(o.queues[level]) > 0 {
+				chosen = level
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		return nil
+	}
+
+	for level := 0; level < chosen; level++ {
+		if len(o.queues[level]) > 0 {
+			o.skipped[level]++
+		}
+	}
+	o.skipped[chosen] = 0
+
+	item := o.queues[chosen][0]
+	o.queues[chosen][0] = nil
+	o.queues[chosen] = o.queues[chosen][1:]
+	return item
+}
+
+func priorityLevel(p Priority) int {
+	if p < PriorityLow {
+		p = PriorityLow
+	}
+	if p > PriorityUrgent {
+		p = PriorityUrgent
+	}
+	return int(p - PriorityLow)
+}
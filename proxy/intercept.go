@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"path"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// Match applies next only to messages travelling in dir whose type matches one
+// of patterns, using path.Match syntax, so "chat.*" matches "chat.send". Other
+// messages pass through unchanged. An empty pattern list matches every type.
+func Match(dir Direction, patterns []string, next Interceptor) Interceptor {
+	return func(conn *Conn, d Direction, msg *conduit.Message) *conduit.Message {
+		if d&dir == 0 || !matchType(patterns, msg.Type) {
+			return msg
+		}
+		return next(conn, d, msg)
+	}
+}
+
+// Log logs every message with its connection, direction and payload.
+func Log(logger conduit.Logger) Interceptor {
+	return func(conn *Conn, dir Direction, msg *conduit.Message) *conduit.Message {
+		logger.Infof("[%s] %s %s: %s", conn.ID(), dir, msg.Type, msg.Payload)
+		return msg
+	}
+}
+
+// Drop drops every message.
+func Drop() Interceptor {
+	return func(*Conn, Direction, *conduit.Message) *conduit.Message {
+		return nil
+	}
+}
+
+// Delay holds every message back for d before forwarding it. Later messages in
+// the same direction wait behind it, so ordering is preserved.
+func Delay(d time.Duration) Interceptor {
+	return func(_ *Conn, _ Direction, msg *conduit.Message) *conduit.Message {
+		time.Sleep(d)
+		return msg
+	}
+}
+
+// Rewrite forwards the message returned by fn, which may modify msg in place. A
+// nil result drops the message.
+func Rewrite(fn func(msg *conduit.Message) *conduit.Message) Interceptor {
+	return func(_ *Conn, _ Direction, msg *conduit.Message) *conduit.Message {
+		return fn(msg)
+	}
+}
+
+func matchType(patterns []string, msgType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, msgType); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,414 @@
+// Package proxy implements a transparent debugging proxy for conduit traffic.
+//
+// The proxy listens on one socket and forwards every connection to another,
+// decoding the messages in both directions and passing them through a chain of
+// interceptors that can log, delay, drop or rewrite them:
+//
+//	p := proxy.New(proxy.DefaultConfig("/tmp/app-debug.sock", "/tmp/app.sock"))
+//	p.Use(
+//		proxy.Log(logger),
+//		proxy.Match(proxy.ToServer, []string{"chat.*"}, proxy.Delay(time.Second)),
+//		proxy.Match(proxy.Both, []string{"metrics"}, proxy.Drop()),
+//	)
+//	if err := p.Start(); err != nil { ... }
+//
+// Neither peer needs to change: the handshake is relayed as is, so the client
+// and server negotiate the codec and features they would have agreed on
+// directly. Compressed payloads are decompressed for the interceptors and
+// forwarded uncompressed.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// Direction tells which way a message is travelling through the proxy.
+type Direction int
+
+const (
+	// ToServer marks messages sent by the client.
+	ToServer Direction = 1 << iota
+	// ToClient marks messages sent by the server.
+	ToClient
+	// Both matches messages in either direction.
+	Both = ToServer | ToClient
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ToServer:
+		return "client->server"
+	case ToClient:
+		return "server->client"
+	default:
+		return "both"
+	}
+}
+
+// Interceptor inspects a message on its way through the proxy. It returns the
+// message to forward, which may be msg itself, a modified msg or a replacement,
+// or nil to drop it. Interceptors run in the order they were added, one message
+// at a time per direction, so blocking in an interceptor holds back the
+// messages behind it.
+type Interceptor func(conn *Conn, dir Direction, msg *conduit.Message) *conduit.Message
+
+// Config holds configuration options for the proxy.
+//
+// Fields:
+//   - ListenPath: Filesystem path of the Unix socket clients connect to, or an
+//     address with a transport scheme (see conduit.ParseAddress).
+//   - TargetPath: Filesystem path of the server's Unix socket, or its address.
+//   - SocketPermissions: Filesystem permissions for the listening socket.
+//   - Logger: A Logger interface for the proxy's own logs.
+//   - MaxMessageSize: Maximum allowed size of a single message in bytes.
+//   - ListenTLS: TLS settings when listening on a tls:// address.
+//   - TargetTLS: TLS settings when connecting to a tls:// target.
+//   - DialTimeout: Maximum time to connect to the target for each client. The
+//     default of DefaultConfig is used when zero.
+type Config struct {
+	ListenPath        string
+	TargetPath        string
+	SocketPermissions uint32
+	Logger            conduit.Logger
+	MaxMessageSize    int64
+	ListenTLS         *tls.Config
+	TargetTLS         *tls.Config
+	DialTimeout       time.Duration
+}
+
+const defaultDialTimeout = 5 * time.Second
+
+// DefaultConfig returns a Config with standard default values.
+func DefaultConfig(listenPath, targetPath string) *Config {
+	return &Config{
+		ListenPath:        listenPath,
+		TargetPath:        targetPath,
+		SocketPermissions: 0666,
+		Logger:            conduit.NewLogger(conduit.LogInfo, nil),
+		MaxMessageSize:    32 * 1024 * 1024, // 32MB default
+		DialTimeout:       defaultDialTimeout,
+	}
+}
+
+// Proxy forwards conduit connections from one socket to another.
+type Proxy struct {
+	config       *Config
+	listener     net.Listener
+	address      conduit.Address
+	interceptors []Interceptor
+	conns        map[*Conn]struct{}
+	mu           sync.RWMutex
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// New creates a proxy with the given configuration. The provided config must not
+// be nil.
+func New(config *Config) *Proxy {
+	if config == nil {
+		panic("config cannot be nil")
+	}
+	return &Proxy{
+		config: config,
+		conns:  make(map[*Conn]struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Use appends interceptors to the chain every message passes through.
+// Interceptors should be added before Start.
+func (p *Proxy) Use(interceptors ...Interceptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interceptors = append(p.interceptors, interceptors...)
+}
+
+// Start begins listening on the configured socket and forwarding connections to
+// the target.
+func (p *Proxy) Start() error {
+	addr, err := conduit.ParseAddress(p.config.ListenPath)
+	if err != nil {
+		return fmt.Errorf("failed to start proxy: %w", err)
+	}
+	p.address = addr
+
+	if addr.Scheme == "unix" {
+		if err := os.RemoveAll(addr.Addr); err != nil {
+			return fmt.Errorf("failed to remove existing socket: %w", err)
+		}
+	}
+
+	transport, _ := conduit.LookupTransport(addr.Scheme)
+	listener, err := transport.Listen(addr.Addr, p.config.ListenTLS)
+	if err != nil {
+		return fmt.Errorf("failed to start proxy: %w", err)
+	}
+	if addr.Scheme == "unix" {
+		if err := os.Chmod(addr.Addr, os.FileMode(p.config.SocketPermissions)); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	p.listener = listener
+	p.mu.Unlock()
+	p.config.Logger.Infof("Proxy started on %s, forwarding to %s", p.config.ListenPath, p.config.TargetPath)
+
+	go p.acceptConnections()
+	return nil
+}
+
+// Addr returns the address the proxy is listening on, or nil before Start.
+func (p *Proxy) Addr() net.Addr {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+// Stop stops accepting connections, closes every proxied connection and removes
+// the socket file. It is safe to call multiple times.
+func (p *Proxy) Stop() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		p.mu.Lock()
+		if p.listener != nil {
+			err = p.listener.Close()
+		}
+		for conn := range p.conns {
+			conn.Close()
+		}
+		p.mu.Unlock()
+
+		if p.address.Scheme == "unix" {
+			if err2 := os.RemoveAll(p.address.Addr); err2 != nil && err == nil {
+				err = err2
+			}
+		}
+	})
+	return err
+}
+
+func (p *Proxy) acceptConnections() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+				return
+			default:
+				p.config.Logger.Errorf("Failed to accept connection: %v", err)
+				continue
+			}
+		}
+
+		go p.proxyConnection(client)
+	}
+}
+
+// proxyConnection connects to the target for an accepted client and forwards
+// the connection. Dialing happens here rather than in the accept loop so a slow
+// or unreachable target does not hold back other clients.
+func (p *Proxy) proxyConnection(client net.Conn) {
+	timeout := p.config.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	server, err := conduit.Dial(ctx, p.config.TargetPath, p.config.TargetTLS)
+	if err != nil {
+		p.config.Logger.Errorf("Failed to connect to %s: %v", p.config.TargetPath, err)
+		client.Close()
+		return
+	}
+
+	conn := &Conn{proxy: p, client: client, server: server, id: generateConnID()}
+	p.mu.Lock()
+	select {
+	case <-p.done:
+		// Stop ran while dialing and will not close this connection.
+		p.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	p.conns[conn] = struct{}{}
+	p.mu.Unlock()
+
+	p.config.Logger.Infof("Proxying connection %s", conn.id)
+	conn.serve()
+}
+
+// Conn is one client connection forwarded by the proxy.
+type Conn struct {
+	proxy   *Proxy
+	client  net.Conn
+	server  net.Conn
+	id      string
+	session *conduit.Session
+	once    sync.Once
+}
+
+// ID returns the connection's unique ID.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// Session returns the settings the client and server negotiated, or
+// conduit.LegacySession() for clients that skip the handshake.
+func (c *Conn) Session() *conduit.Session {
+	return c.session
+}
+
+// Close closes both sides of the connection.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		c.client.Close()
+		c.server.Close()
+	})
+	return nil
+}
+
+func (c *Conn) serve() {
+	p := c.proxy
+	defer func() {
+		c.Close()
+		p.mu.Lock()
+		delete(p.conns, c)
+		p.mu.Unlock()
+		p.config.Logger.Infof("Proxied connection closed: %s", c.id)
+	}()
+
+	fromClient := conduit.NewLimitedReader(c.client, p.config.MaxMessageSize)
+	fromServer := conduit.NewLimitedReader(c.server, p.config.MaxMessageSize)
+	clientDecoder, serverDecoder, first, err := c.handshake(fromClient, fromServer)
+	if err != nil {
+		if err != io.EOF {
+			p.config.Logger.Errorf("Handshake of %s failed: %v", c.id, err)
+		}
+		return
+	}
+
+	codec, _ := conduit.LookupCodec(c.session.Codec)
+	toServer := codec.NewEncoder(c.server)
+	toClient := codec.NewEncoder(c.client)
+
+	if first != nil {
+		if err := c.forward(ToServer, first, toServer); err != nil {
+			p.config.Logger.Errorf("Failed to forward message of %s: %v", c.id, err)
+			return
+		}
+	}
+
+	errc := make(chan error, 2)
+	go func() { errc <- c.pump(ToServer, fromClient, clientDecoder, toServer) }()
+	go func() { errc <- c.pump(ToClient, fromServer, serverDecoder, toClient) }()
+
+	if err := <-errc; err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		p.config.Logger.Errorf("Proxied connection %s failed: %v", c.id, err)
+	}
+}
+
+// handshake relays the client's hello and the server's reply unchanged and
+// returns decoders for the negotiated codec. For legacy clients that start with
+// an ordinary message, that message is returned to be forwarded.
+func (c *Conn) handshake(fromClient, fromServer *conduit.LimitedReader) (conduit.Decoder, conduit.Decoder, *conduit.Message, error) {
+	jsonCodec, _ := conduit.LookupCodec("json")
+
+	clientHello := json.NewDecoder(fromClient)
+	var first conduit.Message
+	if err := clientHello.Decode(&first); err != nil {
+		return nil, nil, nil, err
+	}
+	clientRest := conduit.HandshakeRemainder(clientHello, fromClient)
+
+	if first.Type != conduit.TypeHello {
+		c.session = conduit.LegacySession()
+		return jsonCodec.NewDecoder(clientRest), jsonCodec.NewDecoder(fromServer), &first, nil
+	}
+
+	if err := jsonCodec.NewEncoder(c.server).Encode(&first); err != nil {
+		return nil, nil, nil, err
+	}
+
+	serverHello := json.NewDecoder(fromServer)
+	var reply conduit.Message
+	if err := serverHello.Decode(&reply); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := jsonCodec.NewEncoder(c.client).Encode(&reply); err != nil {
+		return nil, nil, nil, err
+	}
+	serverRest := conduit.HandshakeRemainder(serverHello, fromServer)
+
+	var hello conduit.Hello
+	if err := reply.UnmarshalPayload(&hello); err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed hello: %w", err)
+	}
+	if hello.Error != "" {
+		return nil, nil, nil, &conduit.HandshakeError{Reason: hello.Error}
+	}
+	codec, ok := conduit.LookupCodec(hello.Codec)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("peers negotiated unsupported codec %q", hello.Codec)
+	}
+	c.session = &conduit.Session{
+		Version:     hello.Version,
+		Codec:       hello.Codec,
+		Compression: hello.Compression,
+		Features:    hello.Features,
+	}
+	return codec.NewDecoder(clientRest), codec.NewDecoder(serverRest), nil, nil
+}
+
+// pump forwards messages in one direction until either side fails.
+func (c *Conn) pump(dir Direction, limited *conduit.LimitedReader, dec conduit.Decoder, enc conduit.Encoder) error {
+	defer c.Close()
+	for {
+		var msg conduit.Message
+		limited.Reset()
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		if err := c.forward(dir, &msg, enc); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Conn) forward(dir Direction, msg *conduit.Message, enc conduit.Encoder) error {
+	if err := msg.Decompress(c.proxy.config.MaxMessageSize); err != nil {
+		return err
+	}
+
+	c.proxy.mu.RLock()
+	interceptors := c.proxy.interceptors
+	c.proxy.mu.RUnlock()
+	for _, intercept := range interceptors {
+		if msg = intercept(c, dir, msg); msg == nil {
+			return nil
+		}
+	}
+	return enc.Encode(msg)
+}
+
+func generateConnID() string {
+	return fmt.Sprintf("proxy_%d", time.Now().UnixNano())
+}
@@ -0,0 +1,87 @@
+package conduit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Reserved message types used by the work-queue protocol. Types prefixed with
+// "conduit." are handled by the library itself and never reach user handlers.
+const (
+	TypeQueueEnqueue     = "conduit.queue.enqueue"
+	TypeQueueSubscribe   = "conduit.queue.subscribe"
+	TypeQueueUnsubscribe = "conduit.queue.unsubscribe"
+	TypeQueueJob         = "conduit.queue.job"
+	TypeQueueAck         = "conduit.queue.ack"
+	TypeQueueNack        = "conduit.queue.nack"
+)
+
+// Job is a unit of work delivered from a server-side queue to exactly one
+// subscribed worker.
+//
+// Attempt starts at 1 for the first delivery and is incremented every time the
+// job is redelivered after a nack, a visibility timeout or a worker disconnect.
+// Headers are copied from the message that enqueued the job. ExpiresAt is an
+// optional deadline in Unix nanoseconds after which the job is dropped instead
+// of delivered.
+type Job struct {
+	ID        string            `json:"id"`
+	Queue     string            `json:"queue"`
+	Attempt   int               `json:"attempt"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt int64             `json:"expires_at,omitempty"`
+
+	ctx context.Context
+}
+
+// UnmarshalPayload unmarshals the job payload into the provided interface
+func (j *Job) UnmarshalPayload(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Expired returns true if the job has a deadline that has passed.
+func (j *Job) Expired() bool {
+	return j.ExpiresAt != 0 && time.Now().UnixNano() > j.ExpiresAt
+}
+
+// Context returns the job's context. For delivered jobs it carries the job
+// deadline and is canceled when the worker's connection closes. The returned
+// context is never nil.
+func (j *Job) Context() context.Context {
+	if j.ctx != nil {
+		return j.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the job with its context changed to ctx.
+func (j *Job) WithContext(ctx context.Context) *Job {
+	j2 := *j
+	j2.ctx = ctx
+	return &j2
+}
+
+// QueueEnqueue is the payload of a TypeQueueEnqueue message sent by a producer.
+type QueueEnqueue struct {
+	Queue   string          `json:"queue"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// QueueSubscribe is the payload of TypeQueueSubscribe and TypeQueueUnsubscribe
+// messages sent by a worker. Prefetch limits how many unacknowledged jobs the
+// server hands to the worker at once; values below 1 are treated as 1.
+type QueueSubscribe struct {
+	Queue    string `json:"queue"`
+	Prefetch int    `json:"prefetch,omitempty"`
+}
+
+// QueueAck is the payload of TypeQueueAck and TypeQueueNack messages sent by a
+// worker once it has finished with a job. Error carries the failure reason for
+// a nack.
+type QueueAck struct {
+	Queue string `json:"queue"`
+	JobID string `json:"job_id"`
+	Error string `json:"error,omitempty"`
+}
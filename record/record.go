@@ -0,0 +1,229 @@
+// Package record captures the traffic of a conduit server and replays it
+// against another server, reporting where the responses differ.
+//
+// A recording is a file of JSON lines, one Entry per message:
+//
+//	rec, err := record.Create("traffic.jsonl")
+//	if err != nil { ... }
+//	defer rec.Close()
+//	srv.Tap(rec.Tap)
+//
+// Replay re-sends the inbound messages of each recorded connection over a new
+// client connection and compares what comes back with what was recorded.
+//
+// Recordings hold message payloads as they were sent, so treat them as
+// sensitive. The values of headers that look like credentials, such as
+// "authorization" or "api_token", are replaced with Redacted unless the
+// Recorder is told otherwise with RedactHeaders. Replays send the redacted
+// values, so a server that checks them will reject the replayed messages.
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// Direction tells whether a recorded message was received or sent by the server.
+type Direction string
+
+const (
+	Inbound  Direction = "in"
+	Outbound Direction = "out"
+)
+
+// Redacted replaces the values of redacted headers in a recording.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactedHeaders lists what Recorders look for in header keys to decide
+// that a header holds a secret. Keys are matched ignoring case.
+var DefaultRedactedHeaders = []string{"auth", "token", "cookie", "password", "secret", "credential", "api-key", "api_key", "apikey"}
+
+// Entry is one recorded message.
+type Entry struct {
+	Time      time.Time        `json:"time"`
+	Conn      string           `json:"conn"`
+	Direction Direction        `json:"dir"`
+	Message   *conduit.Message `json:"message"`
+}
+
+// Recorder writes entries to a recording. It is safe for concurrent use.
+// Entries are buffered, so the recording is only complete once Close (or Flush)
+// returns.
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	enc    *json.Encoder
+	closer io.Closer
+	redact []string
+	err    error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+	return &Recorder{w: bw, enc: json.NewEncoder(bw), redact: DefaultRedactedHeaders}
+}
+
+// Create creates or truncates the file at path and returns a Recorder writing to
+// it. Close the Recorder to close the file.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// RedactHeaders sets which headers are recorded with their values replaced by
+// Redacted: those whose key contains any of keys, ignoring case. Without keys
+// every header is recorded as it is. Recorders start out redacting
+// DefaultRedactedHeaders.
+func (r *Recorder) RedactHeaders(keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redact = make([]string, len(keys))
+	for i, key := range keys {
+		r.redact[i] = strings.ToLower(key)
+	}
+}
+
+// Record writes one entry for msg, timestamped now. msg itself is not modified
+// by redaction.
+func (r *Recorder) Record(conn string, dir Direction, msg *conduit.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	msg = r.redacted(msg)
+	if err := r.enc.Encode(&Entry{Time: time.Now(), Conn: conn, Direction: dir, Message: msg}); err != nil {
+		r.err = fmt.Errorf("failed to record message: %w", err)
+	}
+	return r.err
+}
+
+// redacted returns msg, or a copy with the values of secret headers replaced.
+// r.mu must be held.
+func (r *Recorder) redacted(msg *conduit.Message) *conduit.Message {
+	var headers map[string]string
+	for key := range msg.Headers {
+		if !r.isSecret(key) {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string, len(msg.Headers))
+			for k, v := range msg.Headers {
+				headers[k] = v
+			}
+		}
+		headers[key] = Redacted
+	}
+	if headers == nil {
+		return msg
+	}
+	copied := *msg
+	copied.Headers = headers
+	return &copied
+}
+
+func (r *Recorder) isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range r.redact {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tap records every message of a server. Register it with srv.Tap(rec.Tap).
+// Write errors stop the recording and are returned by Close.
+func (r *Recorder) Tap(conn *server.Connection, msg *conduit.Message, inbound bool) {
+	dir := Outbound
+	if inbound {
+		dir = Inbound
+	}
+	r.Record(conn.ID(), dir, msg)
+}
+
+// Flush writes buffered entries to the underlying writer.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Close flushes the recording and closes the file opened by Create. It returns
+// the first error the Recorder encountered.
+func (r *Recorder) Close() error {
+	err := r.Flush()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reader reads entries from a recording.
+type Reader struct {
+	dec *json.Decoder
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next entry, or io.EOF at the end of the recording.
+func (r *Reader) Next() (*Entry, error) {
+	var e Entry
+	if err := r.dec.Decode(&e); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("malformed recording: %w", err)
+	}
+	if e.Message == nil {
+		return nil, errors.New("malformed recording: entry without message")
+	}
+	return &e, nil
+}
+
+// Load reads every entry of the recording at path.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	r := NewReader(f)
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+}
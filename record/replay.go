@@ -0,0 +1,432 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+)
+
+// ReplayConfig holds configuration options for Replay.
+//
+// Fields:
+//   - SocketPath: Socket path or address of the server the recording is replayed against.
+//   - Speed: Timing scale. 1 keeps the recorded gaps between messages, 2 halves
+//     them, and 0 sends every message as soon as the previous one was sent.
+//   - Settle: How long to keep collecting messages from the server after the last
+//     message of a connection was sent.
+//   - RequestTimeout: Maximum duration to wait for the reply to a replayed request.
+//   - Logger: A Logger interface for the replay clients.
+//   - TLSConfig: TLS settings when SocketPath is a tls:// address.
+type ReplayConfig struct {
+	SocketPath     string
+	Speed          float64
+	Settle         time.Duration
+	RequestTimeout time.Duration
+	Logger         conduit.Logger
+	TLSConfig      *tls.Config
+}
+
+// DefaultReplayConfig returns a ReplayConfig that keeps the recorded timing.
+func DefaultReplayConfig(socketPath string) *ReplayConfig {
+	return &ReplayConfig{
+		SocketPath:     socketPath,
+		Speed:          1,
+		Settle:         500 * time.Millisecond,
+		RequestTimeout: 10 * time.Second,
+		Logger:         conduit.NewLogger(conduit.LogError, nil),
+	}
+}
+
+// Kinds of difference reported by Replay.
+const (
+	DiffMismatch   = "mismatch"
+	DiffMissing    = "missing"
+	DiffUnexpected = "unexpected"
+)
+
+// Diff is one response that differs between the recording and the replay.
+// Request is the type of the request the response answers, or empty for
+// messages the server sent on its own. Expected is empty for unexpected
+// responses and Actual for missing ones.
+type Diff struct {
+	Conn     string          `json:"conn"`
+	Kind     string          `json:"kind"`
+	Request  string          `json:"request,omitempty"`
+	Type     string          `json:"type"`
+	Expected json.RawMessage `json:"expected,omitempty"`
+	Actual   json.RawMessage `json:"actual,omitempty"`
+}
+
+func (d Diff) String() string {
+	what := d.Type
+	if d.Request != "" {
+		what = fmt.Sprintf("%s (reply to %s)", d.Type, d.Request)
+	}
+	switch d.Kind {
+	case DiffMissing:
+		return fmt.Sprintf("%s: missing %s: expected %s", d.Conn, what, d.Expected)
+	case DiffUnexpected:
+		return fmt.Sprintf("%s: unexpected %s: got %s", d.Conn, what, d.Actual)
+	default:
+		return fmt.Sprintf("%s: %s differs: expected %s, got %s", d.Conn, what, d.Expected, d.Actual)
+	}
+}
+
+// Report summarizes a replay.
+type Report struct {
+	Connections int    `json:"connections"`
+	Sent        int    `json:"sent"`
+	Compared    int    `json:"compared"`
+	Diffs       []Diff `json:"diffs"`
+}
+
+// OK returns true if every response matched the recording.
+func (r *Report) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+// response is a message from the server, reduced to what is compared.
+type response struct {
+	Type    string
+	Payload json.RawMessage
+}
+
+// Replay re-drives a recording against the server at cfg.SocketPath. Each
+// recorded connection is replayed concurrently over its own client connection,
+// sending the connection's inbound messages with the recorded timing scaled by
+// cfg.Speed.
+//
+// Replies to requests are compared with the recorded replies, in order, and
+// other messages from the server are matched by type and payload regardless of
+// order. Payloads are compared as JSON values. The library's own "conduit."
+// messages, such as stream and queue traffic, are neither sent nor compared,
+// and neither are requests the server made to its clients.
+//
+// Replay returns an error only if the replay could not be carried out; a
+// server that answers differently is reported in the Report's Diffs.
+func Replay(ctx context.Context, entries []Entry, cfg *ReplayConfig) (*Report, error) {
+	if cfg == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+
+	var order []string
+	conns := make(map[string][]Entry)
+	for _, e := range entries {
+		if _, ok := conns[e.Conn]; !ok {
+			order = append(order, e.Conn)
+		}
+		conns[e.Conn] = append(conns[e.Conn], e)
+	}
+
+	report := &Report{Connections: len(order)}
+	if len(entries) == 0 {
+		return report, nil
+	}
+	start := time.Now()
+	origin := entries[0].Time
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, id := range order {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			r := &connReplay{cfg: cfg, conn: id, entries: conns[id], start: start, origin: origin}
+			err := r.run(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("connection %s: %w", id, err))
+				return
+			}
+			report.Sent += r.sent
+			report.Compared += r.compared
+			report.Diffs = append(report.Diffs, r.diffs...)
+		}(id)
+	}
+	wg.Wait()
+
+	return report, errors.Join(errs...)
+}
+
+// connReplay replays the entries of one recorded connection.
+type connReplay struct {
+	cfg     *ReplayConfig
+	conn    string
+	entries []Entry
+	start   time.Time
+	origin  time.Time
+
+	mu       sync.Mutex
+	events   []response
+	sent     int
+	compared int
+	diffs    []Diff
+}
+
+func (r *connReplay) run(ctx context.Context) error {
+	// Index the recorded answers to each request and the other messages the
+	// server sent on this connection.
+	replies := make(map[string][]response)
+	var events []response
+	for _, e := range r.entries {
+		msg := e.Message
+		if e.Direction != Outbound {
+			continue
+		}
+		switch {
+		case conduit.IsRPCMessage(msg):
+			replies[msg.ReplyTo] = append(replies[msg.ReplyTo], response{msg.Type, msg.Payload})
+		case !isReserved(msg.Type) && !msg.ExpectReply:
+			events = append(events, response{msg.Type, msg.Payload})
+		}
+	}
+
+	cfg := conduit.DefaultClientConfig(r.cfg.SocketPath)
+	cfg.Logger = r.cfg.Logger
+	cfg.TLSConfig = r.cfg.TLSConfig
+	cfg.Reconnect = false
+	cfg.ReadTimeout = 0
+	c := client.NewClient(cfg)
+	c.HandleDefault(func(_ *client.Client, msg *conduit.Message) error {
+		r.mu.Lock()
+		r.events = append(r.events, response{msg.Type, msg.Payload})
+		r.mu.Unlock()
+		return nil
+	})
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for _, e := range r.entries {
+		msg := e.Message
+		if e.Direction != Inbound || isReserved(msg.Type) {
+			continue
+		}
+		if err := r.wait(ctx, e.Time); err != nil {
+			wg.Wait()
+			return err
+		}
+
+		r.sent++
+		if msg.ExpectReply {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				actual := r.request(ctx, c, msg, replies[msg.ID])
+				r.compareReplies(msg.Type, replies[msg.ID], actual)
+			}()
+			continue
+		}
+		if err := c.Send(msg.Type, msg.Payload, conduit.WithHeaders(msg.Headers)); err != nil {
+			wg.Wait()
+			return err
+		}
+	}
+	wg.Wait()
+
+	select {
+	case <-time.After(r.cfg.Settle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+	actual := r.events
+	r.mu.Unlock()
+	r.compareEvents(events, actual)
+	return nil
+}
+
+// wait sleeps until the scaled time of a recorded message.
+func (r *connReplay) wait(ctx context.Context, at time.Time) error {
+	if r.cfg.Speed <= 0 {
+		return ctx.Err()
+	}
+	due := r.start.Add(time.Duration(float64(at.Sub(r.origin)) / r.cfg.Speed))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// request replays a request and returns the server's answers in the form they
+// were recorded: a reply or error, or a sequence of items ending with an end
+// message or error.
+func (r *connReplay) request(ctx context.Context, c *client.Client, msg *conduit.Message, recorded []response) []response {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.RequestTimeout)
+	defer cancel()
+	opts := []conduit.SendOption{conduit.WithHeaders(msg.Headers)}
+
+	streaming := false
+	for _, resp := range recorded {
+		if resp.Type == conduit.TypeRPCItem || resp.Type == conduit.TypeRPCEnd {
+			streaming = true
+		}
+	}
+
+	if !streaming {
+		var reply json.RawMessage
+		if err := c.Request(ctx, msg.Type, msg.Payload, &reply, opts...); err != nil {
+			return []response{errorResponse(err)}
+		}
+		return []response{{conduit.TypeRPCReply, reply}}
+	}
+
+	stream, err := c.RequestStream(ctx, msg.Type, msg.Payload, opts...)
+	if err != nil {
+		return []response{errorResponse(err)}
+	}
+	defer stream.Close()
+
+	var out []response
+	for {
+		var item json.RawMessage
+		err := stream.Recv(&item)
+		if err == io.EOF {
+			return append(out, response{Type: conduit.TypeRPCEnd, Payload: json.RawMessage("null")})
+		}
+		if err != nil {
+			return append(out, errorResponse(err))
+		}
+		out = append(out, response{conduit.TypeRPCItem, item})
+	}
+}
+
+func errorResponse(err error) response {
+	var remote *conduit.RemoteError
+	if !errors.As(err, &remote) {
+		remote = &conduit.RemoteError{Code: conduit.CodeInternal, Message: err.Error()}
+	}
+	payload, _ := json.Marshal(remote)
+	return response{conduit.TypeRPCError, payload}
+}
+
+func (r *connReplay) compareReplies(request string, expected, actual []response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		r.compared++
+		switch {
+		case i >= len(actual):
+			r.diff(DiffMissing, request, expected[i].Type, expected[i].Payload, nil)
+		case i >= len(expected):
+			r.diff(DiffUnexpected, request, actual[i].Type, nil, actual[i].Payload)
+		case expected[i].Type != actual[i].Type:
+			r.diff(DiffMismatch, request, expected[i].Type, typed(expected[i]), typed(actual[i]))
+		case !equalJSON(expected[i].Payload, actual[i].Payload):
+			r.diff(DiffMismatch, request, expected[i].Type, expected[i].Payload, actual[i].Payload)
+		}
+	}
+}
+
+func (r *connReplay) compareEvents(expected, actual []response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := make([]bool, len(actual))
+	var unmatched []response
+	for _, exp := range expected {
+		r.compared++
+		found := false
+		for i, act := range actual {
+			if !matched[i] && act.Type == exp.Type && equalJSON(act.Payload, exp.Payload) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, exp)
+		}
+	}
+
+	// Pair what is left by type, so a changed payload reads as one mismatch
+	// rather than a missing and an unexpected message.
+	for _, exp := range unmatched {
+		paired := false
+		for i, act := range actual {
+			if !matched[i] && act.Type == exp.Type {
+				matched[i], paired = true, true
+				r.diff(DiffMismatch, "", exp.Type, exp.Payload, act.Payload)
+				break
+			}
+		}
+		if !paired {
+			r.diff(DiffMissing, "", exp.Type, exp.Payload, nil)
+		}
+	}
+	for i, act := range actual {
+		if !matched[i] {
+			r.compared++
+			r.diff(DiffUnexpected, "", act.Type, nil, act.Payload)
+		}
+	}
+}
+
+func (r *connReplay) diff(kind, request, msgType string, expected, actual json.RawMessage) {
+	r.diffs = append(r.diffs, Diff{
+		Conn:     r.conn,
+		Kind:     kind,
+		Request:  request,
+		Type:     msgType,
+		Expected: expected,
+		Actual:   actual,
+	})
+}
+
+// typed wraps a response with its type, for diffs where the types differ.
+func typed(resp response) json.RawMessage {
+	data, _ := json.Marshal(struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}{resp.Type, resp.Payload})
+	return data
+}
+
+func equalJSON(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal(nullIfEmpty(a), &va) != nil || json.Unmarshal(nullIfEmpty(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func nullIfEmpty(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+func isReserved(msgType string) bool {
+	return strings.HasPrefix(msgType, "conduit.")
+}
@@ -0,0 +1,409 @@
+package conduit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reserved message types used by request/reply and streaming calls. Each carries
+// the ID of the request it belongs to in ReplyTo.
+const (
+	// TypeRPCReply carries the response to a unary request.
+	TypeRPCReply = "conduit.rpc.reply"
+	// TypeRPCItem carries one element of a response or request stream.
+	TypeRPCItem = "conduit.rpc.item"
+	// TypeRPCEnd ends a stream. Sent by the handler it completes the call; sent
+	// by the caller it closes the request side of a bidirectional stream.
+	TypeRPCEnd = "conduit.rpc.end"
+	// TypeRPCError fails a call. Its payload is a RemoteError.
+	TypeRPCError = "conduit.rpc.error"
+	// TypeRPCCancel tells the handler that the caller gave up on the call.
+	TypeRPCCancel = "conduit.rpc.cancel"
+	// TypeRPCCanceled answers a cancel with its outcome. Its payload is a CancelResult.
+	TypeRPCCanceled = "conduit.rpc.canceled"
+)
+
+const rpcTypePrefix = "conduit.rpc."
+
+// Error codes carried by RemoteError.
+const (
+	CodeInternal         = "internal"
+	CodeUnknownType      = "unknown_type"
+	CodeCanceled         = "canceled"
+	CodeInvalidArgument  = "invalid_argument"
+	CodePermissionDenied = "permission_denied"
+)
+
+// Outcomes of a canceled call, as reported by the handler's side.
+const (
+	// CancelStopped means the handler returned an error after its context was canceled.
+	CancelStopped = "stopped"
+	// CancelCompleted means the handler had finished, or finished anyway, and its
+	// response was sent.
+	CancelCompleted = "completed"
+	// CancelUnknown means the outcome is not known, for example because the report
+	// did not arrive in time or the call was never received.
+	CancelUnknown = "unknown"
+)
+
+// CancelResult is the payload of a TypeRPCCanceled message.
+type CancelResult struct {
+	Outcome string `json:"outcome"`
+}
+
+// CanceledError is returned by calls whose context was done before the reply
+// arrived. It wraps the context's error, so errors.Is(err, context.Canceled)
+// and errors.Is(err, context.DeadlineExceeded) work as usual.
+type CanceledError struct {
+	Err     error
+	Outcome string
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("call canceled (handler %s): %v", e.Outcome, e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// RemoteError is an error returned by the handler on the other end of a call.
+type RemoteError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error (%s): %s", e.Code, e.Message)
+}
+
+// AsRemoteError converts a handler error into the RemoteError sent to the caller.
+// A *RemoteError anywhere in err's chain is passed through unchanged.
+func AsRemoteError(err error) *RemoteError {
+	var remote *RemoteError
+	if errors.As(err, &remote) {
+		return remote
+	}
+	if errors.Is(err, context.Canceled) {
+		return &RemoteError{Code: CodeCanceled, Message: err.Error()}
+	}
+	return &RemoteError{Code: CodeInternal, Message: err.Error()}
+}
+
+// IsRPCMessage returns true if msg belongs to a call started elsewhere.
+func IsRPCMessage(msg *Message) bool {
+	return msg.ReplyTo != "" && strings.HasPrefix(msg.Type, rpcTypePrefix)
+}
+
+// PendingCalls routes replies to the calls waiting for them, keyed by the ID of
+// the request message.
+type PendingCalls struct {
+	mu    sync.Mutex
+	calls map[string]*Call
+}
+
+// NewPendingCalls creates an empty call table.
+func NewPendingCalls() *PendingCalls {
+	return &PendingCalls{calls: make(map[string]*Call)}
+}
+
+// Register starts tracking replies to the request with the given ID. It must be
+// called before the request is sent so no reply can be missed.
+func (p *PendingCalls) Register(id string) *Call {
+	call := &Call{id: id, changed: make(chan struct{})}
+	p.mu.Lock()
+	p.calls[id] = call
+	p.mu.Unlock()
+	return call
+}
+
+// Remove stops tracking the call with the given ID. Later replies are dropped.
+func (p *PendingCalls) Remove(id string) {
+	p.mu.Lock()
+	delete(p.calls, id)
+	p.mu.Unlock()
+}
+
+// Deliver queues msg on the call it replies to. It returns false if msg is not
+// an RPC message; RPC messages for unknown calls are consumed and dropped.
+func (p *PendingCalls) Deliver(msg *Message) bool {
+	if !IsRPCMessage(msg) {
+		return false
+	}
+	p.mu.Lock()
+	call, exists := p.calls[msg.ReplyTo]
+	p.mu.Unlock()
+	if exists {
+		call.push(msg)
+	}
+	return true
+}
+
+// FailAll fails every pending call with err, for example when the connection is lost.
+func (p *PendingCalls) FailAll(err error) {
+	p.mu.Lock()
+	calls := p.calls
+	p.calls = make(map[string]*Call)
+	p.mu.Unlock()
+
+	for _, call := range calls {
+		call.fail(err)
+	}
+}
+
+// CallBufferSize is the number of received messages a call buffers for a slow
+// consumer. A call whose buffer overflows fails with ErrCallOverflow rather than
+// holding an unbounded number of messages in memory.
+const CallBufferSize = 1024
+
+// ErrCallOverflow fails a call whose consumer fell more than CallBufferSize
+// messages behind the peer.
+var ErrCallOverflow = errors.New("call buffer overflow: messages are not consumed fast enough")
+
+// ErrDuplicateCall is returned by ActiveCalls.Start for a request ID that is
+// already being served.
+var ErrDuplicateCall = errors.New("duplicate request ID")
+
+// Call buffers the messages received for one call until they are consumed.
+type Call struct {
+	id string
+
+	mu      sync.Mutex
+	changed chan struct{}
+	queue   []*Message
+	err     error
+}
+
+// ID returns the ID of the request message that started the call.
+func (c *Call) ID() string {
+	return c.id
+}
+
+// Next returns the next message received for the call, blocking until one
+// arrives, the call fails or ctx is done.
+func (c *Call) Next(ctx context.Context) (*Message, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return msg, nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return nil, err
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Call) push(msg *Message) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	if len(c.queue) >= CallBufferSize {
+		c.mu.Unlock()
+		c.fail(ErrCallOverflow)
+		return
+	}
+	c.queue = append(c.queue, msg)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+}
+
+func (c *Call) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+}
+
+// recentCallsSize is how many finished calls ActiveCalls remembers, so a cancel
+// that crosses the reply on the wire is still answered accurately.
+const recentCallsSize = 256
+
+// ActiveCalls tracks the calls a peer is currently serving so they can be
+// canceled by the caller.
+type ActiveCalls struct {
+	mu        sync.Mutex
+	active    map[string]*activeCall
+	recent    []string
+	recentSet map[string]struct{}
+}
+
+type activeCall struct {
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// NewActiveCalls creates an empty tracker.
+func NewActiveCalls() *ActiveCalls {
+	return &ActiveCalls{
+		active:    make(map[string]*activeCall),
+		recentSet: make(map[string]struct{}),
+	}
+}
+
+// Start tracks the call started by the request with the given ID. cancel cancels
+// the handler's context. Returns ErrDuplicateCall if a call with the same ID is
+// still running.
+func (a *ActiveCalls) Start(id string, cancel context.CancelFunc) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.active[id]; exists {
+		return ErrDuplicateCall
+	}
+	a.active[id] = &activeCall{cancel: cancel}
+	return nil
+}
+
+// Finish stops tracking a call whose handler returned and reports whether the
+// caller had canceled it.
+func (a *ActiveCalls) Finish(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	call := a.active[id]
+	delete(a.active, id)
+
+	if len(a.recent) == recentCallsSize {
+		delete(a.recentSet, a.recent[0])
+		a.recent = a.recent[1:]
+	}
+	a.recent = append(a.recent, id)
+	a.recentSet[id] = struct{}{}
+	return call != nil && call.canceled
+}
+
+// Cancel cancels the context of the call with the given ID. It returns the
+// outcome to report right away, or "" if the call is still running and reports
+// its outcome once the handler returns.
+func (a *ActiveCalls) Cancel(id string) string {
+	a.mu.Lock()
+	call, running := a.active[id]
+	if running {
+		call.canceled = true
+	}
+	_, finished := a.recentSet[id]
+	a.mu.Unlock()
+
+	switch {
+	case running:
+		call.cancel()
+		return ""
+	case finished:
+		return CancelCompleted
+	default:
+		return CancelUnknown
+	}
+}
+
+// ServeCall runs handler for the request msg, which must already be tracked in
+// calls with Start, then reports the result with send: a handler error as
+// TypeRPCError, or the cancel outcome if the caller canceled the call. The
+// handler is responsible for sending its own successful reply.
+func ServeCall(calls *ActiveCalls, send SendFunc, msg *Message, cancel context.CancelFunc, handler func() error) error {
+	err := handler()
+	canceled := calls.Finish(msg.ID)
+	cancel()
+
+	switch {
+	case canceled && err != nil:
+		SendCancelResult(send, msg.ID, CancelStopped)
+	case canceled:
+		SendCancelResult(send, msg.ID, CancelCompleted)
+	case err != nil:
+		send(TypeRPCError, AsRemoteError(err), WithReplyTo(msg.ID))
+	}
+	return err
+}
+
+// SendCancelResult reports the outcome of a cancel for the call with the given ID.
+func SendCancelResult(send SendFunc, id, outcome string) error {
+	return send(TypeRPCCanceled, CancelResult{Outcome: outcome}, WithReplyTo(id), WithPriority(PriorityHigh))
+}
+
+// AwaitReply waits for the reply to a unary request tracked by call and decodes
+// it into resp unless resp is nil. A handler error is returned as a
+// *RemoteError. If ctx is done first, the peer is told to cancel the call and a
+// *CanceledError is returned with the outcome the peer reports within
+// cancelTimeout.
+func AwaitReply(ctx context.Context, call *Call, resp interface{}, send SendFunc, cancelTimeout time.Duration) error {
+	for {
+		msg, err := call.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				return err
+			}
+			outcome := CancelUnknown
+			if send(TypeRPCCancel, nil, WithReplyTo(call.ID()), WithPriority(PriorityHigh)) == nil {
+				outcome = AwaitCancelResult(call, cancelTimeout)
+			}
+			return &CanceledError{Err: ctx.Err(), Outcome: outcome}
+		}
+
+		switch msg.Type {
+		case TypeRPCReply:
+			if resp == nil {
+				return nil
+			}
+			if err := msg.UnmarshalPayload(resp); err != nil {
+				return fmt.Errorf("failed to unmarshal response: %w", err)
+			}
+			return nil
+		case TypeRPCError:
+			return DecodeRemoteError(msg)
+		}
+	}
+}
+
+// DecodeRemoteError returns the RemoteError carried by a TypeRPCError message.
+func DecodeRemoteError(msg *Message) *RemoteError {
+	remote := &RemoteError{}
+	if err := msg.UnmarshalPayload(remote); err != nil {
+		return &RemoteError{Code: CodeInternal, Message: string(msg.Payload)}
+	}
+	return remote
+}
+
+// AwaitCancelResult waits up to timeout for the peer to report the outcome of a
+// cancel sent for call. Responses that crossed the cancel on the wire are skipped.
+func AwaitCancelResult(call *Call, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		msg, err := call.Next(ctx)
+		if err != nil {
+			return CancelUnknown
+		}
+		if msg.Type != TypeRPCCanceled {
+			continue
+		}
+		var result CancelResult
+		if err := msg.UnmarshalPayload(&result); err != nil {
+			return CancelUnknown
+		}
+		return result.Outcome
+	}
+}
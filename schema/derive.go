@@ -0,0 +1,267 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	typeOfTime      = reflect.TypeOf(time.Time{})
+	typeOfRawJSON   = reflect.TypeOf(json.RawMessage{})
+	typeOfMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// FromType derives a schema from the Go type of v, following the rules of
+// encoding/json for field names. Pointers, slices and maps are nullable, since
+// they encode to null when nil.
+//
+// Constraints are declared in a "schema" struct tag as a comma-separated list:
+//
+//	type CreateUser struct {
+//		Name  string `json:"name" schema:"required,minLength=1,maxLength=64"`
+//		Age   int    `json:"age" schema:"min=0,max=150"`
+//		Role  string `json:"role" schema:"enum=admin|member"`
+//		Email string `json:"email" schema:"pattern=^[^@]+@[^@]+$"`
+//	}
+//
+// Supported keys are required, min, max, minLength, maxLength, pattern, enum,
+// minItems and maxItems. Types with custom JSON marshaling accept any value.
+func FromType(v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot derive a schema from nil")
+	}
+	s, err := derive(t, make(map[reflect.Type]bool))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustFromType is like FromType but panics on error.
+func MustFromType(v interface{}) *Schema {
+	s, err := FromType(v)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func derive(t reflect.Type, visiting map[reflect.Type]bool) (*Schema, error) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	if t == typeOfTime {
+		return &Schema{Type: TypeString, Nullable: nullable}, nil
+	}
+	if t == typeOfRawJSON || t.Implements(typeOfMarshaler) || reflect.PointerTo(t).Implements(typeOfMarshaler) {
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: TypeBoolean, Nullable: nullable}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: TypeInteger, Nullable: nullable}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeNumber, Nullable: nullable}, nil
+	case reflect.String:
+		return &Schema{Type: TypeString, Nullable: nullable}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte encodes as a base64 string.
+			return &Schema{Type: TypeString, Nullable: t.Kind() == reflect.Slice || nullable}, nil
+		}
+		items, err := derive(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeArray, Items: items, Nullable: t.Kind() == reflect.Slice || nullable}, nil
+	case reflect.Map:
+		return &Schema{Type: TypeObject, Nullable: true}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			// Recursive types accept any value below the first level.
+			return &Schema{}, nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		s, err := deriveStruct(t, visiting)
+		if err != nil {
+			return nil, err
+		}
+		s.Nullable = nullable
+		return s, nil
+	}
+	return nil, fmt.Errorf("cannot derive a schema for %s", t)
+}
+
+func deriveStruct(t reflect.Type, visiting map[reflect.Type]bool) (*Schema, error) {
+	s := &Schema{Type: TypeObject, Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+
+		ft := field.Type
+		if field.Anonymous && name == "" {
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded, err := derive(ft, visiting)
+				if err != nil {
+					return nil, err
+				}
+				for n, p := range embedded.Properties {
+					if _, exists := s.Properties[n]; !exists {
+						s.Properties[n] = p
+					}
+				}
+				s.Required = append(s.Required, embedded.Required...)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop, err := derive(field.Type, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		required, err := applyTag(prop, field.Tag.Get("schema"))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if required {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	return s, nil
+}
+
+// jsonName returns the JSON name of a field, "" if it uses the Go name, and
+// false if the field is skipped.
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name := tag
+	if i := strings.Index(tag, ","); i >= 0 {
+		name = tag[:i]
+	}
+	return name, true
+}
+
+// applyTag applies the constraints of a schema struct tag and reports whether
+// the field is required.
+func applyTag(s *Schema, tag string) (bool, error) {
+	if tag == "" {
+		return false, nil
+	}
+
+	required := false
+	for _, part := range splitTag(tag) {
+		key, value, _ := strings.Cut(part, "=")
+		var err error
+		switch key {
+		case "required":
+			required = true
+		case "min":
+			s.Minimum, err = parseFloat(value)
+		case "max":
+			s.Maximum, err = parseFloat(value)
+		case "minLength":
+			s.MinLength, err = parseInt(value)
+		case "maxLength":
+			s.MaxLength, err = parseInt(value)
+		case "minItems":
+			s.MinItems, err = parseInt(value)
+		case "maxItems":
+			s.MaxItems, err = parseInt(value)
+		case "pattern":
+			s.Pattern = value
+		case "enum":
+			for _, option := range strings.Split(value, "|") {
+				s.Enum = append(s.Enum, enumValue(s.Type, option))
+			}
+		default:
+			return false, fmt.Errorf("unknown schema tag key %q", key)
+		}
+		if err != nil {
+			return false, fmt.Errorf("invalid schema tag %q: %w", part, err)
+		}
+	}
+	return required, nil
+}
+
+// splitTag splits a schema tag on commas, except inside a pattern, which runs
+// to the end of the tag.
+func splitTag(tag string) []string {
+	var parts []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "pattern=") {
+			return append(parts, tag)
+		}
+		part, rest, _ := strings.Cut(tag, ",")
+		parts = append(parts, part)
+		tag = rest
+	}
+	return parts
+}
+
+func enumValue(t, option string) interface{} {
+	switch t {
+	case TypeInteger, TypeNumber:
+		if f, err := strconv.ParseFloat(option, 64); err == nil {
+			return f
+		}
+	case TypeBoolean:
+		if b, err := strconv.ParseBool(option); err == nil {
+			return b
+		}
+	}
+	return option
+}
+
+func parseFloat(s string) (*float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseInt(s string) (*int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
@@ -0,0 +1,344 @@
+// Package schema validates message payloads against a subset of JSON Schema.
+//
+// Schemas are either parsed from JSON or derived from Go types with FromType.
+// The supported keywords are type, properties, required, additionalProperties,
+// items, enum, minimum, maximum, minLength, maxLength, pattern, minItems and
+// maxItems, plus the OpenAPI-style nullable flag.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// JSON types accepted by Schema.Type.
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeNull    = "null"
+)
+
+// Schema describes the expected shape of a JSON value. The zero Schema accepts
+// any value.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Parse parses a JSON-encoded schema and checks that it is well formed.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("malformed schema: %w", err)
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// MustParse is like Parse but panics if the schema is invalid. It simplifies
+// declaring schemas in package variables.
+func MustParse(data string) *Schema {
+	s, err := Parse([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Compile checks that the schema is well formed and compiles its patterns.
+// Parse and FromType compile the schemas they return; schemas built by hand are
+// compiled when they are registered with SetSchema.
+func (s *Schema) Compile() error {
+	return s.compile("")
+}
+
+// compile validates keywords and prepares patterns.
+func (s *Schema) compile(path string) error {
+	switch s.Type {
+	case "", TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeNull:
+	default:
+		return fmt.Errorf("schema %s: unknown type %q", displayPath(path), s.Type)
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema %s: invalid pattern: %w", displayPath(path), err)
+		}
+		s.pattern = re
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(path + "/" + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "/items")
+	}
+	return nil
+}
+
+// FieldError describes one part of a payload that failed validation. Path is a
+// JSON Pointer to the offending value, "" for the payload itself.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in a payload.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		parts[i] = fmt.Sprintf("%s: %s", displayPath(fe.Path), fe.Message)
+	}
+	return "invalid payload: " + strings.Join(parts, "; ")
+}
+
+// RemoteError converts the validation error into the error sent to a caller.
+// The failing paths are carried in Details as a list of FieldError.
+func (e *ValidationError) RemoteError() *conduit.RemoteError {
+	details, _ := json.Marshal(e.Errors)
+	return &conduit.RemoteError{
+		Code:    conduit.CodeInvalidArgument,
+		Message: e.Error(),
+		Details: details,
+	}
+}
+
+// FieldErrors extracts the failing paths from a RemoteError returned for an
+// invalid payload. It returns nil if err carries none.
+func FieldErrors(err *conduit.RemoteError) []FieldError {
+	if err == nil || err.Code != conduit.CodeInvalidArgument || len(err.Details) == 0 {
+		return nil
+	}
+	var errs []FieldError
+	if json.Unmarshal(err.Details, &errs) != nil {
+		return nil
+	}
+	return errs
+}
+
+// Validate checks a JSON payload against the schema. It returns a
+// *ValidationError listing every violation, or nil if the payload is valid.
+// An empty payload is validated as null.
+func (s *Schema) Validate(payload []byte) error {
+	var value interface{}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &value); err != nil {
+			return &ValidationError{Errors: []FieldError{{Message: "malformed JSON: " + err.Error()}}}
+		}
+	}
+
+	v := &validator{}
+	v.validate(s, value, "")
+	if len(v.errors) > 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(s *Schema, value interface{}, path string) {
+	if value == nil && (s.Nullable || s.Type == "" || s.Type == TypeNull) {
+		return
+	}
+	if s.Type != "" && !hasType(value, s.Type) {
+		v.fail(path, "expected %s, got %s", s.Type, typeOf(value))
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		v.fail(path, "must be one of %s", formatEnum(s.Enum))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(s, val, path)
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			v.fail(path, "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			v.fail(path, "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				v.validate(s.Items, item, path+"/"+strconv.Itoa(i))
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			v.fail(path, "must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			v.fail(path, "must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re := s.pattern
+			if re == nil {
+				// The schema was not compiled; never panic on its pattern.
+				var err error
+				if re, err = regexp.Compile(s.Pattern); err != nil {
+					v.fail(path, "schema has an invalid pattern %q", s.Pattern)
+					break
+				}
+			}
+			if !re.MatchString(val) {
+				v.fail(path, "must match pattern %q", s.Pattern)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			v.fail(path, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			v.fail(path, "must be at most %v", *s.Maximum)
+		}
+	}
+}
+
+func (v *validator) validateObject(s *Schema, obj map[string]interface{}, path string) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			v.fail(path+"/"+escape(name), "is required")
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, known := s.Properties[name]
+		switch {
+		case known:
+			v.validate(prop, obj[name], path+"/"+escape(name))
+		case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+			v.fail(path+"/"+escape(name), "is not allowed")
+		}
+	}
+}
+
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case TypeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case TypeArray:
+		_, ok := value.([]interface{})
+		return ok
+	case TypeString:
+		_, ok := value.(string)
+		return ok
+	case TypeNumber:
+		_, ok := value.(float64)
+		return ok
+	case TypeInteger:
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case TypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case TypeNull:
+		return value == nil
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case string:
+		return TypeString
+	case float64:
+		return TypeNumber
+	case bool:
+		return TypeBoolean
+	default:
+		return TypeNull
+	}
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(normalize(e), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize converts enum values declared in Go to their decoded JSON form.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+func formatEnum(enum []interface{}) string {
+	data, _ := json.Marshal(enum)
+	return string(data)
+}
+
+// escape encodes a property name as a JSON Pointer token.
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
+)
+
+// Describe returns a description of the server and every message type it
+// handles, as reported to clients by the built-in conduit.reflect service.
+// Request schemas come from SetSchema, or are derived from the method's request
+// type for registered services, which also report response schemas.
+func (s *Server) Describe() *conduit.ServerDescription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	desc := &conduit.ServerDescription{
+		Version:  s.config.Version,
+		Library:  conduit.Version,
+		Protocol: conduit.ProtocolVersion,
+		Features: conduit.Features(),
+	}
+
+	add := func(msgType, kind string) {
+		td := conduit.TypeDescription{Type: msgType, Kind: kind}
+		if sch, ok := s.schemas[msgType]; ok {
+			td.Request = marshalSchema(sch)
+		}
+		if m := s.serviceMethod(msgType); m != nil {
+			if td.Request == nil {
+				td.Request = deriveSchema(m.reqType)
+			}
+			td.Response = deriveSchema(m.respType)
+		}
+		desc.Types = append(desc.Types, td)
+	}
+
+	for msgType := range s.handlers {
+		add(msgType, conduit.KindMessage)
+	}
+	for msgType, h := range s.rpc {
+		add(msgType, h.kind)
+	}
+	for msgType := range s.streams {
+		kind := conduit.KindStream
+		if strings.HasPrefix(msgType, conduit.TypeChannelPrefix) {
+			kind = conduit.KindChannel
+		}
+		add(msgType, kind)
+	}
+
+	sort.Slice(desc.Types, func(i, j int) bool {
+		return desc.Types[i].Type < desc.Types[j].Type
+	})
+	return desc
+}
+
+// describe answers a conduit.reflect request from conn.
+func (s *Server) describe(conn *Connection) *conduit.ServerDescription {
+	desc := s.Describe()
+	if session := conn.Session(); session != nil {
+		desc.Protocol = session.Version
+		desc.Features = session.Features
+	}
+	return desc
+}
+
+// serviceMethod returns the registered service method handling msgType, if any.
+// The caller must hold s.mu.
+func (s *Server) serviceMethod(msgType string) *serviceMethod {
+	i := strings.LastIndex(msgType, ".")
+	if i < 0 {
+		return nil
+	}
+	svc, ok := s.services[msgType[:i]]
+	if !ok {
+		return nil
+	}
+	return svc.methods[msgType[i+1:]]
+}
+
+func deriveSchema(t reflect.Type) json.RawMessage {
+	sch, err := schema.FromType(reflect.Zero(t).Interface())
+	if err != nil {
+		return nil
+	}
+	return marshalSchema(sch)
+}
+
+func marshalSchema(sch *schema.Schema) json.RawMessage {
+	data, err := json.Marshal(sch)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// jsonCodec speaks the handshake and the legacy protocol.
+var jsonCodec, _ = conduit.LookupCodec("json")
+
+// handshake reads the client's first message. If it is a hello, the connection
+// settings are negotiated, the reply is sent and a decoder for the agreed codec
+// is returned. Otherwise the client is treated as a legacy peer speaking plain
+// JSON and its first message is returned so it can be dispatched normally.
+func (s *Server) handshake(conn *Connection, r io.Reader) (*conduit.Message, conduit.Decoder, error) {
+	if s.config.ReadTimeout > 0 {
+		conn.conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
+	}
+
+	helloDecoder := json.NewDecoder(r)
+	var first conduit.Message
+	if err := helloDecoder.Decode(&first); err != nil {
+		return nil, nil, err
+	}
+	rest := conduit.HandshakeRemainder(helloDecoder, r)
+
+	if first.Type != conduit.TypeHello {
+		if s.config.RequireHandshake {
+			conn.sendHello(&conduit.Hello{Error: "handshake required"})
+			return nil, nil, errors.New("client did not send a hello")
+		}
+		conn.session = conduit.LegacySession()
+		atomic.StoreInt32(&conn.ready, 1)
+		s.connected(conn)
+		return &first, jsonCodec.NewDecoder(rest), nil
+	}
+
+	var hello conduit.Hello
+	if err := first.UnmarshalPayload(&hello); err != nil {
+		return nil, nil, err
+	}
+
+	session, err := conduit.Negotiate(conduit.NewHello(s.config.Codecs, s.config.Compression), &hello)
+	if err != nil {
+		conn.sendHello(&conduit.Hello{Error: err.Error()})
+		return nil, nil, err
+	}
+	session.ConnectionID = conn.id
+	if err := conn.sendHello(session.Reply()); err != nil {
+		return nil, nil, err
+	}
+
+	codec, _ := conduit.LookupCodec(session.Codec)
+	conn.encoder = codec.NewEncoder(conn.conn)
+	conn.session = session
+	atomic.StoreInt32(&conn.ready, 1)
+	s.connected(conn)
+
+	s.config.Logger.Debugf("Negotiated protocol v%d with %s using %s codec and compression '%s'",
+		session.Version, conn.id, session.Codec, session.Compression)
+	return nil, codec.NewDecoder(rest), nil
+}
+
+// sendHello writes a hello to the client. It must only be called before the
+// connection is ready, while the JSON encoder is still in place.
+func (c *Connection) sendHello(hello *conduit.Hello) error {
+	msg, err := conduit.NewMessage(conduit.TypeHello, hello, conduit.WithPriority(conduit.PriorityUrgent))
+	if err != nil {
+		return err
+	}
+	return c.outbox.Send(msg)
+}
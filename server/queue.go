@@ -0,0 +1,456 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// DeliveryStrategy selects which subscribed worker receives the next job.
+type DeliveryStrategy int
+
+const (
+	// RoundRobin hands jobs to workers in turn, skipping workers that have no
+	// spare prefetch capacity.
+	RoundRobin DeliveryStrategy = iota
+	// LeastLoaded hands each job to the worker with the fewest unacknowledged jobs.
+	LeastLoaded
+)
+
+// QueueConfig holds configuration options for a work queue.
+//
+// Fields:
+//   - Strategy: How jobs are spread across subscribed workers.
+//   - VisibilityTimeout: How long a worker may hold a job before it is redelivered. Zero disables the timeout.
+//   - MaxRetries: How many times a failed job is redelivered before it is moved to the dead-letter queue.
+//     A negative value retries forever.
+type QueueConfig struct {
+	Strategy          DeliveryStrategy
+	VisibilityTimeout time.Duration
+	MaxRetries        int
+}
+
+// DefaultQueueConfig returns a QueueConfig with standard default values.
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Strategy:          RoundRobin,
+		VisibilityTimeout: 30 * time.Second,
+		MaxRetries:        3,
+	}
+}
+
+// Queue is a server-side work queue. Producers enqueue jobs and the queue delivers
+// each job to exactly one subscribed worker connection.
+//
+// A job stays in flight until the worker acknowledges it. Jobs that are nacked,
+// exceed the visibility timeout or belong to a worker that disconnects are
+// redelivered until MaxRetries is exhausted, after which they are moved to the
+// queue's dead-letter queue.
+type Queue struct {
+	name   string
+	config QueueConfig
+	server *Server
+	dead   *Queue
+
+	mu       sync.Mutex
+	pending  []*conduit.Job
+	inflight map[string]*delivery
+	workers  []*worker
+	next     int
+	seq      uint64
+}
+
+type worker struct {
+	conn     *Connection
+	prefetch int
+	inflight int
+	outgoing []*delivery // assigned jobs waiting to be sent, in order
+	sending  bool        // a deliver goroutine is draining outgoing
+}
+
+type delivery struct {
+	job    *conduit.Job
+	worker *worker
+	timer  *time.Timer
+}
+
+// DeclareQueue creates a work queue with the given name and configuration, along
+// with its dead-letter queue named "<name>.dead". If a queue with the same name
+// already exists it is returned unchanged.
+func (s *Server) DeclareQueue(name string, config QueueConfig) *Queue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if q, exists := s.queues[name]; exists {
+		return q
+	}
+
+	dead := newQueue(s, name+".dead", QueueConfig{
+		Strategy:          config.Strategy,
+		VisibilityTimeout: config.VisibilityTimeout,
+		MaxRetries:        -1,
+	})
+	q := newQueue(s, name, config)
+	q.dead = dead
+
+	s.queues[name] = q
+	s.queues[dead.name] = dead
+	return q
+}
+
+// GetQueue returns the queue declared with the given name, if any.
+func (s *Server) GetQueue(name string) (*Queue, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q, ok := s.queues[name]
+	return q, ok
+}
+
+func newQueue(s *Server, name string, config QueueConfig) *Queue {
+	return &Queue{
+		name:     name,
+		config:   config,
+		server:   s,
+		inflight: make(map[string]*delivery),
+	}
+}
+
+// Name returns the name of the queue.
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// DeadLetter returns the queue that receives jobs which exhausted their retries.
+// It returns nil for a dead-letter queue itself.
+func (q *Queue) DeadLetter() *Queue {
+	return q.dead
+}
+
+// Len returns the number of jobs waiting to be delivered.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// InFlight returns the number of jobs delivered to workers but not yet acknowledged.
+func (q *Queue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inflight)
+}
+
+// Enqueue adds a job with the given payload to the queue and returns its ID.
+// Options such as conduit.WithTTL and conduit.WithHeader apply to the job; a job
+// that expires before a worker takes it is dropped. Returns an error if the
+// payload cannot be marshaled.
+func (q *Queue) Enqueue(payload interface{}, opts ...conduit.SendOption) (string, error) {
+	msg, err := conduit.NewMessage(conduit.TypeQueueJob, payload, opts...)
+	if err != nil {
+		return "", err
+	}
+	return q.enqueue(msg.Payload, msg), nil
+}
+
+// enqueue adds a job, taking its headers and deadline from the envelope message.
+func (q *Queue) enqueue(payload json.RawMessage, envelope *conduit.Message) string {
+	q.mu.Lock()
+	q.seq++
+	job := &conduit.Job{
+		ID:        fmt.Sprintf("%s_%d", q.name, q.seq),
+		Queue:     q.name,
+		Payload:   payload,
+		Headers:   envelope.Headers,
+		ExpiresAt: envelope.ExpiresAt,
+	}
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+
+	q.dispatch()
+	return job.ID
+}
+
+func (q *Queue) push(job *conduit.Job) {
+	q.mu.Lock()
+	job.Queue = q.name
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+
+	q.dispatch()
+}
+
+// dispatch assigns pending jobs to workers until either runs out. It does not
+// wait for the jobs to be sent: each worker's jobs are sent in order by its own
+// deliver goroutine, so a slow worker holds back neither the caller, often a
+// producer's read loop, nor the other workers.
+func (q *Queue) dispatch() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		w := q.pickWorker()
+		if w == nil {
+			q.mu.Unlock()
+			return
+		}
+
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		if job.Expired() {
+			q.mu.Unlock()
+			q.server.config.Logger.Warnf("Dropping expired job %s from queue '%s'", job.ID, q.name)
+			continue
+		}
+		job.Attempt++
+
+		d := &delivery{job: job, worker: w}
+		w.inflight++
+		q.inflight[job.ID] = d
+		if q.config.VisibilityTimeout > 0 {
+			d.timer = time.AfterFunc(q.config.VisibilityTimeout, func() {
+				q.server.config.Logger.Warnf("Job %s exceeded visibility timeout on %s", job.ID, w.conn.id)
+				q.release(job.ID, d, true, true)
+				q.dispatch()
+			})
+		}
+		w.outgoing = append(w.outgoing, d)
+		if !w.sending {
+			w.sending = true
+			go q.deliver(w)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// deliver sends the jobs assigned to w until none are left. If a send fails the
+// worker is unsubscribed, which hands its jobs to the other workers.
+func (q *Queue) deliver(w *worker) {
+	for {
+		q.mu.Lock()
+		if len(w.outgoing) == 0 {
+			w.sending = false
+			q.mu.Unlock()
+			return
+		}
+		d := w.outgoing[0]
+		w.outgoing = w.outgoing[1:]
+		if q.inflight[d.job.ID] != d {
+			// Released before it was sent, e.g. because the worker left.
+			q.mu.Unlock()
+			continue
+		}
+		job := *d.job
+		q.mu.Unlock()
+
+		var opts []conduit.SendOption
+		if job.ExpiresAt != 0 {
+			opts = append(opts, conduit.WithDeadline(time.Unix(0, job.ExpiresAt)))
+		}
+		if err := w.conn.Send(conduit.TypeQueueJob, &job, opts...); err != nil {
+			q.server.config.Logger.Errorf("Failed to deliver job %s to %s: %v", job.ID, w.conn.id, err)
+			q.release(job.ID, d, true, false)
+			q.unsubscribe(w.conn)
+		}
+	}
+}
+
+// pickWorker returns the next worker with spare capacity according to the queue
+// strategy, or nil if every worker is busy. Must be called with q.mu held.
+func (q *Queue) pickWorker() *worker {
+	n := len(q.workers)
+	var picked *worker
+	pickedAt := 0
+	for i := 0; i < n; i++ {
+		idx := (q.next + i) % n
+		w := q.workers[idx]
+		if w.inflight >= w.prefetch {
+			continue
+		}
+		if picked == nil || (q.config.Strategy == LeastLoaded && w.inflight < picked.inflight) {
+			picked, pickedAt = w, idx
+		}
+		if q.config.Strategy == RoundRobin {
+			break
+		}
+	}
+	if picked != nil {
+		q.next = (pickedAt + 1) % n
+	}
+	return picked
+}
+
+// release removes an in-flight delivery. If failed is true the job is redelivered,
+// or moved to the dead-letter queue once it has exhausted its retries. Attempts
+// that never reached the worker (countAttempt false) do not count as retries.
+// Callers are responsible for dispatching afterwards.
+func (q *Queue) release(jobID string, d *delivery, failed, countAttempt bool) {
+	q.mu.Lock()
+	if current, ok := q.inflight[jobID]; !ok || current != d {
+		// Stale ack or timeout for a job that has already been redelivered.
+		q.mu.Unlock()
+		return
+	}
+	delete(q.inflight, jobID)
+	d.worker.inflight--
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	var deadLetter *conduit.Job
+	if failed {
+		job := d.job
+		if !countAttempt {
+			job.Attempt--
+		}
+		if q.dead != nil && q.config.MaxRetries >= 0 && job.Attempt > q.config.MaxRetries {
+			deadLetter = job
+		} else {
+			q.pending = append(q.pending, job)
+		}
+	}
+	q.mu.Unlock()
+
+	if deadLetter != nil {
+		q.server.config.Logger.Warnf("Job %s exhausted %d retries, moving to %s", deadLetter.ID, q.config.MaxRetries, q.dead.name)
+		q.dead.push(deadLetter)
+	}
+}
+
+func (q *Queue) subscribe(conn *Connection, prefetch int) {
+	if prefetch < 1 {
+		prefetch = 1
+	}
+
+	q.mu.Lock()
+	found := false
+	for _, w := range q.workers {
+		if w.conn == conn {
+			w.prefetch = prefetch
+			found = true
+			break
+		}
+	}
+	if !found {
+		q.workers = append(q.workers, &worker{conn: conn, prefetch: prefetch})
+	}
+	q.mu.Unlock()
+
+	q.dispatch()
+}
+
+// unsubscribe removes the worker for conn and redelivers any jobs it still held.
+func (q *Queue) unsubscribe(conn *Connection) {
+	q.mu.Lock()
+	var held []*delivery
+	for i, w := range q.workers {
+		if w.conn != conn {
+			continue
+		}
+		q.workers = append(q.workers[:i], q.workers[i+1:]...)
+		if q.next > i {
+			q.next--
+		}
+		for _, d := range q.inflight {
+			if d.worker == w {
+				held = append(held, d)
+			}
+		}
+		break
+	}
+	q.mu.Unlock()
+
+	for _, d := range held {
+		q.release(d.job.ID, d, true, true)
+	}
+	q.dispatch()
+}
+
+func (q *Queue) acknowledge(conn *Connection, jobID string, failed bool) {
+	q.mu.Lock()
+	d, ok := q.inflight[jobID]
+	q.mu.Unlock()
+
+	if !ok || d.worker.conn != conn {
+		q.server.config.Logger.Debugf("Ignoring acknowledgement for unknown job %s from %s", jobID, conn.id)
+		return
+	}
+	q.release(jobID, d, failed, true)
+	q.dispatch()
+}
+
+// handleQueueMessage processes the reserved work-queue message types. Enqueue
+// requests that expect a reply are answered with the job ID, or with a
+// RemoteError if the queue does not exist.
+func (s *Server) handleQueueMessage(conn *Connection, msg *conduit.Message) error {
+	switch msg.Type {
+	case conduit.TypeQueueEnqueue:
+		var req conduit.QueueEnqueue
+		if err := msg.UnmarshalPayload(&req); err != nil {
+			return err
+		}
+		q, ok := s.GetQueue(req.Queue)
+		if !ok {
+			err := fmt.Errorf("unknown queue '%s'", req.Queue)
+			if msg.ExpectReply {
+				return conn.Send(conduit.TypeRPCError, &conduit.RemoteError{
+					Code:    conduit.CodeInvalidArgument,
+					Message: err.Error(),
+				}, conduit.WithReplyTo(msg.ID))
+			}
+			return err
+		}
+		id := q.enqueue(req.Payload, msg)
+		if msg.ExpectReply {
+			return conn.Reply(msg, id)
+		}
+
+	case conduit.TypeQueueSubscribe, conduit.TypeQueueUnsubscribe:
+		var req conduit.QueueSubscribe
+		if err := msg.UnmarshalPayload(&req); err != nil {
+			return err
+		}
+		q, ok := s.GetQueue(req.Queue)
+		if !ok {
+			return fmt.Errorf("unknown queue '%s'", req.Queue)
+		}
+		if msg.Type == conduit.TypeQueueSubscribe {
+			q.subscribe(conn, req.Prefetch)
+		} else {
+			q.unsubscribe(conn)
+		}
+
+	case conduit.TypeQueueAck, conduit.TypeQueueNack:
+		var req conduit.QueueAck
+		if err := msg.UnmarshalPayload(&req); err != nil {
+			return err
+		}
+		q, ok := s.GetQueue(req.Queue)
+		if !ok {
+			return fmt.Errorf("unknown queue '%s'", req.Queue)
+		}
+		if msg.Type == conduit.TypeQueueNack {
+			s.config.Logger.Warnf("Job %s failed on %s: %s", req.JobID, conn.id, req.Error)
+		}
+		q.acknowledge(conn, req.JobID, msg.Type == conduit.TypeQueueNack)
+	}
+	return nil
+}
+
+// releaseWorker unsubscribes a closing connection from every queue.
+func (s *Server) releaseWorker(conn *Connection) {
+	s.mu.RLock()
+	queues := make([]*Queue, 0, len(s.queues))
+	for _, q := range s.queues {
+		queues = append(queues, q)
+	}
+	s.mu.RUnlock()
+
+	for _, q := range queues {
+		q.unsubscribe(conn)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// RequestHandler answers a request. The returned value is sent back as the reply;
+// a returned error is sent as a conduit.RemoteError instead. The request's
+// Context is canceled if the caller gives up.
+type RequestHandler func(*Connection, *conduit.Message) (interface{}, error)
+
+// ServerStreamHandler answers a request with a sequence of responses sent through
+// stream. Returning nil ends the stream; returning an error fails it.
+type ServerStreamHandler func(*Connection, *conduit.Message, *ServerStream) error
+
+// BidiStreamHandler serves a bidirectional streaming call, receiving the caller's
+// messages with stream.Recv and answering with stream.Send.
+type BidiStreamHandler func(*Connection, *ServerStream) error
+
+// rpcHandler is the common form every call handler is registered in.
+type rpcHandler struct {
+	serve func(*Connection, *conduit.Message, *ServerStream) error
+	bidi  bool
+	kind  string
+}
+
+// ServerStream is the handler's side of a streaming call.
+type ServerStream struct {
+	conn *Connection
+	id   string
+	ctx  context.Context
+	call *conduit.Call
+}
+
+// Context returns the call's context, which is canceled when the caller cancels
+// the call, its deadline passes or the connection closes.
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends one response to the caller.
+func (s *ServerStream) Send(payload interface{}, opts ...conduit.SendOption) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.conn.Send(conduit.TypeRPCItem, payload, append(opts, conduit.WithReplyTo(s.id))...)
+}
+
+// Recv decodes the caller's next message into v. It returns io.EOF once the
+// caller has closed its side of the stream. Server-streaming calls have no
+// inbound messages, so Recv returns io.EOF immediately.
+func (s *ServerStream) Recv(v interface{}) error {
+	for s.call != nil {
+		msg, err := s.call.Next(s.ctx)
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case conduit.TypeRPCItem:
+			if v == nil {
+				return nil
+			}
+			return msg.UnmarshalPayload(v)
+		case conduit.TypeRPCEnd:
+			s.call = nil
+		}
+	}
+	return io.EOF
+}
+
+// HandleRequest registers a handler that answers requests of the given type.
+// Each request is handled in its own goroutine.
+func (s *Server) HandleRequest(msgType string, handler RequestHandler) {
+	s.handleCall(msgType, rpcHandler{kind: conduit.KindRequest, serve: func(conn *Connection, msg *conduit.Message, _ *ServerStream) error {
+		resp, err := handler(conn, msg)
+		if err != nil {
+			return err
+		}
+		return conn.Reply(msg, resp)
+	}})
+}
+
+// HandleServerStream registers a handler that answers requests of the given type
+// with a stream of responses.
+func (s *Server) HandleServerStream(msgType string, handler ServerStreamHandler) {
+	s.handleCall(msgType, rpcHandler{kind: conduit.KindServerStream, serve: func(conn *Connection, msg *conduit.Message, stream *ServerStream) error {
+		if err := handler(conn, msg, stream); err != nil {
+			return err
+		}
+		return conn.Send(conduit.TypeRPCEnd, nil, conduit.WithReplyTo(msg.ID))
+	}})
+}
+
+// HandleBidiStream registers a handler for bidirectional streaming calls of the
+// given type.
+func (s *Server) HandleBidiStream(msgType string, handler BidiStreamHandler) {
+	s.handleCall(msgType, rpcHandler{bidi: true, kind: conduit.KindBidiStream, serve: func(conn *Connection, msg *conduit.Message, stream *ServerStream) error {
+		if err := handler(conn, stream); err != nil {
+			return err
+		}
+		return conn.Send(conduit.TypeRPCEnd, nil, conduit.WithReplyTo(msg.ID))
+	}})
+}
+
+func (s *Server) handleCall(msgType string, handler rpcHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rpc[msgType] = handler
+}
+
+// Reply sends payload as the reply to req. Plain message handlers can use it to
+// answer requests sent with client.Request.
+func (c *Connection) Reply(req *conduit.Message, payload interface{}, opts ...conduit.SendOption) error {
+	return c.Send(conduit.TypeRPCReply, payload, append(opts, conduit.WithReplyTo(req.ID))...)
+}
+
+// Request sends a request of the given type to the client and waits for the
+// reply from the client's request handler, decoding it into resp unless resp is
+// nil. A handler error is returned as a *conduit.RemoteError. If ctx is done
+// first, the client is told to cancel the call and a *conduit.CanceledError is
+// returned.
+//
+// Returns conduit.ErrFeatureUnsupported if the client did not negotiate RPC.
+func (c *Connection) Request(ctx context.Context, msgType string, payload interface{}, resp interface{}, opts ...conduit.SendOption) error {
+	if session := c.Session(); session == nil || !session.HasFeature(conduit.FeatureRPC) {
+		return conduit.ErrFeatureUnsupported
+	}
+
+	id := conduit.NewID()
+	call := c.calls.Register(id)
+	defer c.calls.Remove(id)
+
+	opts = append(opts, conduit.WithID(id), expectReply)
+	if err := c.Send(msgType, payload, opts...); err != nil {
+		return err
+	}
+	return conduit.AwaitReply(ctx, call, resp, c.Send, c.server.config.CancelTimeout)
+}
+
+func expectReply(m *conduit.Message) {
+	m.ExpectReply = true
+}
+
+// dispatchCall starts the call handler registered for msg's type, if any. The
+// handler runs in its own goroutine with a context that the caller can cancel.
+func (s *Server) dispatchCall(conn *Connection, msg *conduit.Message) bool {
+	s.mu.RLock()
+	handler, exists := s.rpc[msg.Type]
+	s.mu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	ctx, cancel := conduit.MessageContext(conn.ctx, msg)
+	if err := conn.active.Start(msg.ID, cancel); err != nil {
+		cancel()
+		s.config.Logger.Warnf("Rejecting call '%s' from %s: %v", msg.Type, conn.id, err)
+		conn.Send(conduit.TypeRPCError, &conduit.RemoteError{
+			Code:    conduit.CodeInvalidArgument,
+			Message: fmt.Sprintf("%v '%s'", err, msg.ID),
+		}, conduit.WithReplyTo(msg.ID))
+		return true
+	}
+	stream := &ServerStream{conn: conn, id: msg.ID, ctx: ctx}
+	if handler.bidi {
+		stream.call = conn.calls.Register(msg.ID)
+	}
+
+	go func() {
+		err := conduit.ServeCall(conn.active, conn.Send, msg, cancel, func() error {
+			return handler.serve(conn, msg.WithContext(ctx), stream)
+		})
+		conn.calls.Remove(msg.ID)
+		if err != nil {
+			s.config.Logger.Errorf("Handler error for call '%s' from %s: %v", msg.Type, conn.id, err)
+		}
+	}()
+	return true
+}
+
+// cancelCall cancels the call started by the request with the given ID. Running
+// calls report their outcome once the handler returns.
+func (c *Connection) cancelCall(id string) {
+	if outcome := c.active.Cancel(id); outcome != "" {
+		conduit.SendCancelResult(c.Send, id, outcome)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
+)
+
+// SetSchema registers the schema that payloads of msgType must satisfy. Inbound
+// messages are validated before any handler runs: an invalid request is answered
+// with a conduit.RemoteError of code conduit.CodeInvalidArgument listing the
+// failing paths (see schema.FieldErrors), and any other invalid message is
+// logged and dropped. A nil schema removes validation for msgType.
+//
+// The schema is compiled first; an invalid schema, such as one with a malformed
+// pattern, is returned as an error and not registered.
+func (s *Server) SetSchema(msgType string, sch *schema.Schema) error {
+	if sch != nil {
+		if err := sch.Compile(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sch == nil {
+		delete(s.schemas, msgType)
+		return nil
+	}
+	s.schemas[msgType] = sch
+	return nil
+}
+
+// Schema returns the schema registered for msgType, or nil if there is none.
+func (s *Server) Schema(msgType string) *schema.Schema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schemas[msgType]
+}
+
+// validate checks msg against the schema of its type. It returns false if the
+// message was rejected.
+func (s *Server) validate(conn *Connection, msg *conduit.Message) bool {
+	sch := s.Schema(msg.Type)
+	if sch == nil {
+		return true
+	}
+	err := sch.Validate(msg.Payload)
+	if err == nil {
+		return true
+	}
+
+	s.config.Logger.Warnf("Rejecting message of type '%s' from %s: %v", msg.Type, conn.id, err)
+	if msg.ExpectReply {
+		if verr, ok := err.(*schema.ValidationError); ok {
+			conn.Send(conduit.TypeRPCError, verr.RemoteError(), conduit.WithReplyTo(msg.ID))
+		}
+	}
+	return false
+}
@@ -1,15 +1,19 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
 )
 
 // Handler is a function type that processes incoming messages of a specific type.
@@ -27,11 +31,20 @@ type Handler func(*Connection, *conduit.Message) error
 type Server struct {
 	config    *conduit.ServerConfig
 	listener  net.Listener
+	address   conduit.Address
 	handlers  map[string]Handler
 	mu        sync.RWMutex
 	conns     map[*Connection]struct{}
+	queues    map[string]*Queue
+	streams   map[string]StreamHandler
+	rpc       map[string]rpcHandler
+	services  map[string]*service
+	schemas   map[string]*schema.Schema
+	taps      []TapFunc
+	onConnect []func(*Connection)
 	done      chan struct{}
 	closeOnce sync.Once
+	expired   uint64
 }
 
 // Connection represents a single client connection to the server.
@@ -43,6 +56,16 @@ type Server struct {
 type Connection struct {
 	conn    net.Conn
 	server  *Server
+	outbox  *conduit.Outbox
+	inbox   *conduit.Inbox
+	encoder conduit.Encoder
+	streams *conduit.StreamManager
+	calls   *conduit.PendingCalls
+	active  *conduit.ActiveCalls
+	session *conduit.Session
+	ready   int32
+	ctx     context.Context
+	cancel  context.CancelFunc
 	done    chan struct{}
 	id      string
 	context map[string]interface{}
@@ -65,46 +88,80 @@ func NewServer(config *conduit.ServerConfig) *Server {
 		config:   config,
 		handlers: make(map[string]Handler),
 		conns:    make(map[*Connection]struct{}),
+		queues:   make(map[string]*Queue),
+		streams:  make(map[string]StreamHandler),
+		rpc:      make(map[string]rpcHandler),
+		services: make(map[string]*service),
+		schemas:  make(map[string]*schema.Schema),
 		done:     make(chan struct{}),
 	}
 }
 
 // Handle registers a handler function for a given message type.
 // If a message with the specified type is received, the handler is invoked.
+// The handlers of a connection run one at a time in the order their messages
+// arrived, but not on the connection's read loop, so a handler may wait for the
+// reply to a Connection.Request.
 func (s *Server) Handle(msgType string, handler Handler) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.handlers[msgType] = handler
 }
 
-// Start begins listening on the configured Unix domain socket and accepts client connections.
+// Start begins listening on the configured address and accepts client connections.
+// SocketPath may be a plain Unix socket path or an address such as
+// "tcp://127.0.0.1:9000" (see conduit.ParseAddress).
 //
 // The server runs in the background, accepting connections and processing messages. To stop,
 // call Stop(). If Start fails (e.g., unable to listen on the socket), it returns an error.
 func (s *Server) Start() error {
-	// Remove existing socket file if present
-	if err := os.RemoveAll(s.config.SocketPath); err != nil {
-		return fmt.Errorf("failed to remove existing socket: %w", err)
+	addr, err := conduit.ParseAddress(s.config.SocketPath)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	s.address = addr
+
+	if addr.Scheme == "unix" {
+		// Remove existing socket file if present
+		if err := os.RemoveAll(addr.Addr); err != nil {
+			return fmt.Errorf("failed to remove existing socket: %w", err)
+		}
 	}
 
-	listener, err := net.Listen("unix", s.config.SocketPath)
+	transport, _ := conduit.LookupTransport(addr.Scheme)
+	listener, err := transport.Listen(addr.Addr, s.config.TLSConfig)
 	if err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
-	// Apply permissions to the socket file
-	if err := os.Chmod(s.config.SocketPath, os.FileMode(s.config.SocketPermissions)); err != nil {
-		listener.Close()
-		return fmt.Errorf("failed to set socket permissions: %w", err)
+	if addr.Scheme == "unix" {
+		// Apply permissions to the socket file
+		if err := os.Chmod(addr.Addr, os.FileMode(s.config.SocketPermissions)); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to set socket permissions: %w", err)
+		}
 	}
 
+	s.mu.Lock()
 	s.listener = listener
+	s.mu.Unlock()
 	s.config.Logger.Infof("Server started on %s", s.config.SocketPath)
 
 	go s.acceptConnections()
 	return nil
 }
 
+// Addr returns the address the server is listening on, or nil before Start.
+// It reports the actual port when listening on "tcp://host:0".
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
 // Stop stops the server, closes all active connections, and removes the socket file.
 // It is safe to call multiple times; subsequent calls will have no effect.
 func (s *Server) Stop() error {
@@ -122,8 +179,10 @@ func (s *Server) Stop() error {
 		}
 		s.mu.Unlock()
 
-		if err2 := os.RemoveAll(s.config.SocketPath); err2 != nil && err == nil {
-			err = err2
+		if s.address.Scheme == "unix" {
+			if err2 := os.RemoveAll(s.address.Addr); err2 != nil && err == nil {
+				err = err2
+			}
 		}
 	})
 	return err
@@ -142,33 +201,70 @@ func (s *Server) acceptConnections() {
 			}
 		}
 
-		clientConn := &Connection{
-			conn:    conn,
-			server:  s,
-			done:    make(chan struct{}),
-			id:      generateConnID(),
-			context: make(map[string]interface{}),
-		}
+		go s.ServeConn(conn)
+	}
+}
 
-		s.mu.Lock()
-		s.conns[clientConn] = struct{}{}
-		s.mu.Unlock()
+// ServeConn serves a single connection that was accepted or created outside the
+// server, such as one end of a net.Pipe or a connection bridged from another
+// protocol. It blocks until the connection is closed and takes ownership of
+// conn. Connections passed after Stop are closed immediately.
+func (s *Server) ServeConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clientConn := &Connection{
+		conn:    conn,
+		server:  s,
+		encoder: jsonCodec.NewEncoder(conn),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		id:      generateConnID(),
+		context: make(map[string]interface{}),
+		calls:   conduit.NewPendingCalls(),
+		active:  conduit.NewActiveCalls(),
+	}
+	clientConn.outbox = conduit.NewOutbox(clientConn.writeMessage)
+	clientConn.inbox = conduit.NewInbox()
+	clientConn.streams = conduit.NewStreamManager(clientConn.Send, false, clientConn.acceptStream, s.config.Logger)
 
-		s.config.Logger.Infof("New connection established: %s", clientConn.id)
-		go s.handleConnection(clientConn)
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		clientConn.Close()
+		return
+	default:
 	}
+	s.conns[clientConn] = struct{}{}
+	s.mu.Unlock()
+
+	s.config.Logger.Infof("New connection established: %s", clientConn.id)
+	s.handleConnection(clientConn)
 }
 
 func (s *Server) handleConnection(conn *Connection) {
 	defer func() {
 		conn.Close()
+		conn.inbox.Close()
 		s.mu.Lock()
 		delete(s.conns, conn)
 		s.mu.Unlock()
+		s.releaseWorker(conn)
 		s.config.Logger.Infof("Connection closed: %s", conn.id)
 	}()
 
-	decoder := json.NewDecoder(conduit.NewLimitedReader(conn.conn, s.config.MaxMessageSize))
+	limited := conduit.NewLimitedReader(conn.conn, s.config.MaxMessageSize)
+	first, decoder, err := s.handshake(conn, limited)
+	if err != nil {
+		if err != io.EOF {
+			s.config.Logger.Errorf("Handshake with %s failed: %v", conn.id, err)
+		}
+		return
+	}
+	if first != nil {
+		s.observe(conn, first, true)
+		s.dispatch(conn, first)
+	}
 
 	for {
 		select {
@@ -182,42 +278,121 @@ func (s *Server) handleConnection(conn *Connection) {
 			}
 
 			var msg conduit.Message
+			limited.Reset()
 			if err := decoder.Decode(&msg); err != nil {
 				if err != io.EOF {
 					s.config.Logger.Errorf("Failed to decode message from %s: %v", conn.id, err)
 				}
 				return
 			}
+			if err := msg.Decompress(s.config.MaxMessageSize); err != nil {
+				s.config.Logger.Errorf("Failed to decompress message from %s: %v", conn.id, err)
+				return
+			}
 
-			s.mu.RLock()
-			handler, exists := s.handlers[msg.Type]
-			s.mu.RUnlock()
+			s.observe(conn, &msg, true)
+			s.dispatch(conn, &msg)
+		}
+	}
+}
 
-			if !exists {
-				s.config.Logger.Warnf("No handler for message type '%s' from %s", msg.Type, conn.id)
-				continue
-			}
+// dispatch routes a decoded message to the library's control handlers or to the
+// user handler registered for its type.
+func (s *Server) dispatch(conn *Connection, msg *conduit.Message) {
+	if msg.Expired() {
+		atomic.AddUint64(&s.expired, 1)
+		s.config.Logger.Debugf("Dropping expired message of type '%s' from %s", msg.Type, conn.id)
+		return
+	}
 
-			if err := handler(conn, &msg); err != nil {
-				s.config.Logger.Errorf("Handler error for message type '%s' from %s: %v", msg.Type, conn.id, err)
-			}
+	if s.handleControl(conn, msg) || !s.validate(conn, msg) || s.dispatchCall(conn, msg) {
+		return
+	}
+
+	s.mu.RLock()
+	handler, exists := s.handlers[msg.Type]
+	s.mu.RUnlock()
+
+	if !exists {
+		s.config.Logger.Warnf("No handler for message type '%s' from %s", msg.Type, conn.id)
+		if msg.ExpectReply {
+			conn.Send(conduit.TypeRPCError, &conduit.RemoteError{
+				Code:    conduit.CodeUnknownType,
+				Message: fmt.Sprintf("no handler for message type '%s'", msg.Type),
+			}, conduit.WithReplyTo(msg.ID))
+		}
+		return
+	}
+
+	// Handlers run off the read loop so replies to requests they send are still
+	// read while they wait.
+	conn.inbox.Push(func() {
+		ctx, cancel := conduit.MessageContext(conn.ctx, msg)
+		defer cancel()
+		if err := handler(conn, msg.WithContext(ctx)); err != nil {
+			s.config.Logger.Errorf("Handler error for message type '%s' from %s: %v", msg.Type, conn.id, err)
+		}
+	})
+}
+
+// handleControl processes reserved "conduit." message types used by the library's
+// own protocols. It returns true if the message was consumed.
+func (s *Server) handleControl(conn *Connection, msg *conduit.Message) bool {
+	var err error
+	switch msg.Type {
+	case conduit.TypeQueueEnqueue, conduit.TypeQueueSubscribe, conduit.TypeQueueUnsubscribe,
+		conduit.TypeQueueAck, conduit.TypeQueueNack:
+		err = s.handleQueueMessage(conn, msg)
+	case conduit.TypeRPCCancel:
+		conn.cancelCall(msg.ReplyTo)
+	case conduit.TypeReflect:
+		if s.config.DisableReflection {
+			return false
+		}
+		err = conn.Reply(msg, s.describe(conn))
+	default:
+		if conn.calls.Deliver(msg) {
+			return true
+		}
+		var handled bool
+		if handled, err = conn.streams.HandleFrame(msg); !handled {
+			return false
 		}
 	}
+
+	if err != nil {
+		s.config.Logger.Errorf("Failed to process '%s' from %s: %v", msg.Type, conn.id, err)
+	}
+	return true
 }
 
 // Send sends a message of the given type and payload back to the client of this connection.
-// Returns an error if the message could not be encoded or sent.
-func (c *Connection) Send(msgType string, payload interface{}) error {
-	msg, err := conduit.NewMessage(msgType, payload)
+// Messages are written in priority order (see conduit.WithPriority); Send blocks until
+// the message has been written. Returns an error if the message could not be encoded or sent.
+func (c *Connection) Send(msgType string, payload interface{}, opts ...conduit.SendOption) error {
+	msg, err := conduit.NewMessage(msgType, payload, opts...)
 	if err != nil {
 		return err
 	}
+	return c.outbox.Send(msg)
+}
 
+// writeMessage writes a single message to the underlying connection. It is only
+// called from the connection's outbox goroutine.
+func (c *Connection) writeMessage(msg *conduit.Message) error {
+	wire, err := c.session.Compress(msg, c.server.config.CompressionThreshold)
+	if err != nil {
+		return err
+	}
 	if c.server.config.WriteTimeout > 0 {
 		c.conn.SetWriteDeadline(time.Now().Add(c.server.config.WriteTimeout))
 	}
-
-	return json.NewEncoder(c.conn).Encode(msg)
+	if err := c.encoder.Encode(wire); err != nil {
+		return err
+	}
+	// Only messages that were actually written are reported as sent.
+	c.server.observe(c, msg, false)
+	return nil
 }
 
 // Close terminates the client connection. Safe to call multiple times.
@@ -229,6 +404,10 @@ func (c *Connection) Close() error {
 		// already closed
 	default:
 		close(c.done)
+		c.cancel()
+		c.outbox.Close()
+		c.streams.Close(conduit.ErrConnectionClosed)
+		c.calls.FailAll(conduit.ErrConnectionClosed)
 		err = c.conn.Close()
 	}
 	c.mu.Unlock()
@@ -237,8 +416,8 @@ func (c *Connection) Close() error {
 
 // Broadcast sends a message of the given type and payload to all connected clients.
 // Returns an error if the message payload cannot be marshaled.
-func (s *Server) Broadcast(msgType string, payload interface{}) error {
-	msg, err := conduit.NewMessage(msgType, payload)
+func (s *Server) Broadcast(msgType string, payload interface{}, opts ...conduit.SendOption) error {
+	msg, err := conduit.NewMessage(msgType, payload, opts...)
 	if err != nil {
 		return err
 	}
@@ -247,7 +426,10 @@ func (s *Server) Broadcast(msgType string, payload interface{}) error {
 	defer s.mu.RUnlock()
 
 	for conn := range s.conns {
-		if err := conn.Send(msg.Type, msg.Payload); err != nil {
+		if !conn.isReady() {
+			continue
+		}
+		if err := conn.Send(msg.Type, msg.Payload, opts...); err != nil {
 			s.config.Logger.Errorf("Failed to broadcast to %s: %v", conn.id, err)
 		}
 	}
@@ -270,11 +452,51 @@ func (c *Connection) SetContext(key string, value interface{}) {
 	c.context[key] = value
 }
 
+// Context returns a context that is canceled when the connection closes.
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// ExpiredMessages returns how many received messages were dropped because they
+// had passed their deadline before they could be dispatched.
+func (s *Server) ExpiredMessages() uint64 {
+	return atomic.LoadUint64(&s.expired)
+}
+
+// Session returns the settings negotiated with the client during the handshake.
+// Legacy clients that skipped the handshake get conduit.LegacySession. Returns
+// nil while the handshake is still in progress.
+func (c *Connection) Session() *conduit.Session {
+	if !c.isReady() {
+		return nil
+	}
+	return c.session
+}
+
+func (c *Connection) isReady() bool {
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
 // ID returns the unique identifier of this connection.
 func (c *Connection) ID() string {
 	return c.id
 }
 
+// RemoteAddr returns the address of the client.
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// PeerCertificates returns the certificate chain the client presented over the
+// tls transport, leaf first. It returns nil for other transports or clients
+// without a certificate.
+func (c *Connection) PeerCertificates() []*x509.Certificate {
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState().PeerCertificates
+	}
+	return nil
+}
+
 func generateConnID() string {
 	return fmt.Sprintf("conn_%d", time.Now().UnixNano())
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/crazywolf132/conduit"
+)
+
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// service describes a receiver registered with RegisterService.
+type service struct {
+	name     string
+	receiver reflect.Value
+	methods  map[string]*serviceMethod
+}
+
+// serviceMethod describes one exported method of a registered service.
+type serviceMethod struct {
+	method   reflect.Method
+	reqType  reflect.Type
+	respType reflect.Type
+}
+
+// RegisterService exposes the exported methods of receiver as request handlers
+// named "<name>.<Method>". If name is empty, the receiver's type name is used.
+//
+// Every exported method must have the form
+//
+//	func (t *T) Method(ctx context.Context, req *Req) (*Resp, error)
+//
+// where the request payload is decoded into a new Req and the returned Resp is
+// sent as the reply. ctx is canceled if the caller cancels the call. Methods
+// with any other signature make registration fail, so mistakes surface at
+// startup rather than on the first call.
+func (s *Server) RegisterService(name string, receiver interface{}) error {
+	svc := &service{
+		receiver: reflect.ValueOf(receiver),
+		methods:  make(map[string]*serviceMethod),
+	}
+	recvType := reflect.TypeOf(receiver)
+	if recvType == nil {
+		return errors.New("service receiver cannot be nil")
+	}
+	if name == "" {
+		name = reflect.Indirect(svc.receiver).Type().Name()
+		if name == "" {
+			return fmt.Errorf("no service name given for unnamed type %s", recvType)
+		}
+	}
+	svc.name = name
+
+	for i := 0; i < recvType.NumMethod(); i++ {
+		method := recvType.Method(i)
+		m, err := newServiceMethod(method)
+		if err != nil {
+			return fmt.Errorf("service %s: method %s: %w", name, method.Name, err)
+		}
+		svc.methods[method.Name] = m
+	}
+	if len(svc.methods) == 0 {
+		return fmt.Errorf("service %s has no exported methods", name)
+	}
+
+	s.mu.Lock()
+	if _, exists := s.services[name]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("service %s is already registered", name)
+	}
+	s.services[name] = svc
+	s.mu.Unlock()
+
+	for methodName, m := range svc.methods {
+		s.HandleRequest(name+"."+methodName, svc.handler(m))
+	}
+	return nil
+}
+
+// ServiceMethods returns the message types of all registered service methods, sorted.
+func (s *Server) ServiceMethods() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for _, svc := range s.services {
+		for methodName := range svc.methods {
+			names = append(names, svc.name+"."+methodName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newServiceMethod(method reflect.Method) (*serviceMethod, error) {
+	mtype := method.Type
+	// The receiver is the first input.
+	if mtype.NumIn() != 3 || mtype.NumOut() != 2 {
+		return nil, errors.New("must have the signature func(context.Context, *Req) (*Resp, error)")
+	}
+	if mtype.In(1) != typeOfContext {
+		return nil, fmt.Errorf("first argument must be context.Context, not %s", mtype.In(1))
+	}
+	if mtype.In(2).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("request type %s must be a pointer", mtype.In(2))
+	}
+	if mtype.Out(0).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("response type %s must be a pointer", mtype.Out(0))
+	}
+	if mtype.Out(1) != typeOfError {
+		return nil, fmt.Errorf("second result must be error, not %s", mtype.Out(1))
+	}
+	return &serviceMethod{
+		method:   method,
+		reqType:  mtype.In(2).Elem(),
+		respType: mtype.Out(0).Elem(),
+	}, nil
+}
+
+// handler adapts a service method to a RequestHandler.
+func (svc *service) handler(m *serviceMethod) RequestHandler {
+	return func(_ *Connection, msg *conduit.Message) (interface{}, error) {
+		req := reflect.New(m.reqType)
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			if err := msg.UnmarshalPayload(req.Interface()); err != nil {
+				return nil, &conduit.RemoteError{Code: conduit.CodeInvalidArgument, Message: err.Error()}
+			}
+		}
+
+		out := m.method.Func.Call([]reflect.Value{svc.receiver, reflect.ValueOf(msg.Context()), req})
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
+		}
+		return out[0].Interface(), nil
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// StreamHandler processes a byte stream opened by a client. The handler reads the
+// stream until io.EOF; returning nil completes the stream, while returning an
+// error resets it and reports the error to the client.
+//
+// Each stream runs in its own goroutine, so handlers may block on the stream
+// without holding up other messages on the connection.
+type StreamHandler func(*Connection, *conduit.Stream) error
+
+// HandleStream registers a handler for streams opened with the given type.
+func (s *Server) HandleStream(msgType string, handler StreamHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[msgType] = handler
+}
+
+// OpenStream opens a byte stream to the client, handled on the client side by the
+// stream handler registered for msgType. Data written to the stream is sent in
+// chunks that interleave with other messages on the connection. Close the stream
+// to wait for the client's handler to finish.
+//
+// Returns conduit.ErrFeatureUnsupported if the client did not negotiate streams.
+func (c *Connection) OpenStream(msgType string) (*conduit.Stream, error) {
+	if session := c.Session(); session == nil || !session.HasFeature(conduit.FeatureStreams) {
+		return nil, conduit.ErrFeatureUnsupported
+	}
+	return c.streams.Open(msgType)
+}
+
+// acceptStream runs the handler for a stream opened by the client. It is called
+// in its own goroutine by the connection's stream manager.
+func (c *Connection) acceptStream(stream *conduit.Stream) {
+	s := c.server
+	s.mu.RLock()
+	handler, exists := s.streams[stream.Type()]
+	s.mu.RUnlock()
+
+	if !exists {
+		s.config.Logger.Warnf("No stream handler for type '%s' from %s", stream.Type(), c.id)
+		stream.CloseWithError(fmt.Errorf("no handler for stream type '%s'", stream.Type()))
+		return
+	}
+
+	err := handler(c, stream)
+	if err != nil {
+		s.config.Logger.Errorf("Stream handler error for type '%s' from %s: %v", stream.Type(), c.id, err)
+	}
+	stream.Finish(err)
+}
+
+// ChannelHandler serves a logical channel opened by a client. The channel is
+// closed when the handler returns; returning an error resets it instead.
+type ChannelHandler func(*Connection, *conduit.Channel) error
+
+// HandleChannel registers a handler for channels opened with the given name.
+// Each channel is served in its own goroutine.
+func (s *Server) HandleChannel(name string, handler ChannelHandler) {
+	s.HandleStream(conduit.ChannelStreamType(name), func(conn *Connection, stream *conduit.Stream) error {
+		ch := conduit.NewChannel(stream, name)
+		err := handler(conn, ch)
+		if err == nil {
+			ch.CloseRead()
+		}
+		return err
+	})
+}
+
+// OpenChannel opens a logical channel to the client, served by the client's
+// handler for name. Channels share the connection but have their own flow
+// control, so a slow channel does not hold up the others.
+func (c *Connection) OpenChannel(name string) (*conduit.Channel, error) {
+	stream, err := c.OpenStream(conduit.ChannelStreamType(name))
+	if err != nil {
+		return nil, err
+	}
+	return conduit.NewChannel(stream, name), nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import "github.com/crazywolf132/conduit"
+
+// TapFunc observes a message passing through a connection. inbound is true for
+// messages received from the client and false for messages written to it.
+// Taps run on the connection's read or write path, so they must be fast and
+// must not modify msg.
+type TapFunc func(conn *Connection, msg *conduit.Message, inbound bool)
+
+// Tap registers a function that sees every message the server receives, before
+// dispatch, and every message it writes, in the order they are written, once the
+// write has succeeded. Messages that fail to be written are not seen. This
+// includes the library's own "conduit." control messages. Taps should be
+// registered before Start.
+func (s *Server) Tap(tap TapFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taps = append(s.taps, tap)
+}
+
+// OnConnect registers a function that is called with every connection once its
+// handshake has completed and it is ready for messages, including broadcasts.
+// It runs on the connection's read loop before any message is dispatched, so it
+// must be fast. OnConnect should be called before Start.
+func (s *Server) OnConnect(fn func(*Connection)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onConnect = append(s.onConnect, fn)
+}
+
+func (s *Server) connected(conn *Connection) {
+	s.mu.RLock()
+	hooks := s.onConnect
+	s.mu.RUnlock()
+	for _, fn := range hooks {
+		fn(conn)
+	}
+}
+
+func (s *Server) observe(conn *Connection, msg *conduit.Message, inbound bool) {
+	s.mu.RLock()
+	taps := s.taps
+	s.mu.RUnlock()
+	for _, tap := range taps {
+		tap(conn, msg, inbound)
+	}
+}
@@ -0,0 +1,597 @@
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reserved message types used by the streaming protocol.
+const (
+	TypeStreamOpen   = "conduit.stream.open"
+	TypeStreamData   = "conduit.stream.data"
+	TypeStreamWindow = "conduit.stream.window"
+	TypeStreamClose  = "conduit.stream.close"
+	TypeStreamReset  = "conduit.stream.reset"
+)
+
+const (
+	// StreamChunkSize is the largest amount of data carried by a single data frame.
+	StreamChunkSize = 32 * 1024
+	// StreamWindowSize is how many bytes a writer may send before the reader grants more credit.
+	StreamWindowSize = 256 * 1024
+)
+
+var (
+	// ErrStreamClosed is returned when writing to a stream whose write side has been closed.
+	ErrStreamClosed = errors.New("stream closed")
+	// ErrFeatureUnsupported is returned when the peer did not negotiate a feature the call needs.
+	ErrFeatureUnsupported = errors.New("feature not supported by peer")
+)
+
+// StreamError is returned by stream operations after the peer reset the stream,
+// for example because its handler failed.
+type StreamError struct {
+	Reason string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("stream reset by peer: %s", e.Reason)
+}
+
+// StreamFrame is the payload of every stream control and data frame. Type is
+// only set on open frames, Data only on data frames, Increment only on window
+// updates and Error only on close and reset frames.
+type StreamFrame struct {
+	ID        uint64 `json:"id"`
+	Type      string `json:"type,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	Increment int64  `json:"increment,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SendFunc sends a message on a connection. Connection.Send and Client.Send both
+// satisfy it.
+type SendFunc func(msgType string, payload interface{}, opts ...SendOption) error
+
+// StreamManager multiplexes byte streams over a single connection. Data is cut
+// into frames that interleave with normal messages, and a credit window per
+// stream keeps a fast writer from overrunning a slow reader.
+//
+// Each connection owns one manager. The side that dialed the connection uses odd
+// stream IDs and the accepting side even ones, so both can open streams freely.
+type StreamManager struct {
+	send   SendFunc
+	accept func(*Stream)
+	logger Logger
+
+	mu       sync.Mutex
+	streams  map[uint64]*Stream
+	nextID   uint64
+	closed   error
+	control  []controlFrame
+	draining bool
+}
+
+// controlFrame is a frame waiting in the manager's control queue.
+type controlFrame struct {
+	msgType string
+	frame   StreamFrame
+}
+
+// NewStreamManager creates a manager that writes frames with send. Streams opened
+// by the peer are passed to accept, each in its own goroutine. Control frames
+// that fail to be sent are reported to logger.
+func NewStreamManager(send SendFunc, dialer bool, accept func(*Stream), logger Logger) *StreamManager {
+	m := &StreamManager{
+		send:    send,
+		accept:  accept,
+		logger:  logger,
+		streams: make(map[uint64]*Stream),
+		nextID:  2,
+	}
+	if dialer {
+		m.nextID = 1
+	}
+	return m
+}
+
+// Open starts a new stream tagged with msgType, which selects the handler on the
+// receiving side.
+func (m *StreamManager) Open(msgType string) (*Stream, error) {
+	m.mu.Lock()
+	if m.closed != nil {
+		err := m.closed
+		m.mu.Unlock()
+		return nil, err
+	}
+	s := newStream(m, m.nextID, msgType)
+	m.nextID += 2
+	m.streams[s.id] = s
+	m.mu.Unlock()
+
+	if err := m.send(TypeStreamOpen, StreamFrame{ID: s.id, Type: msgType}); err != nil {
+		m.remove(s.id)
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	return s, nil
+}
+
+// HandleFrame processes a stream frame received from the peer. It returns false
+// if msg is not a stream frame.
+func (m *StreamManager) HandleFrame(msg *Message) (bool, error) {
+	switch msg.Type {
+	case TypeStreamOpen, TypeStreamData, TypeStreamWindow, TypeStreamClose, TypeStreamReset:
+	default:
+		return false, nil
+	}
+
+	var frame StreamFrame
+	if err := msg.UnmarshalPayload(&frame); err != nil {
+		return true, err
+	}
+
+	if msg.Type == TypeStreamOpen {
+		m.mu.Lock()
+		if m.closed != nil {
+			m.mu.Unlock()
+			return true, nil
+		}
+		if _, exists := m.streams[frame.ID]; exists {
+			m.mu.Unlock()
+			return true, fmt.Errorf("stream %d already open", frame.ID)
+		}
+		s := newStream(m, frame.ID, frame.Type)
+		m.streams[s.id] = s
+		m.mu.Unlock()
+
+		go m.accept(s)
+		return true, nil
+	}
+
+	m.mu.Lock()
+	s, exists := m.streams[frame.ID]
+	m.mu.Unlock()
+	if !exists {
+		// Frames for streams that were already released are dropped.
+		return true, nil
+	}
+
+	switch msg.Type {
+	case TypeStreamData:
+		s.receiveData(frame.Data)
+	case TypeStreamWindow:
+		s.receiveWindow(frame.Increment)
+	case TypeStreamClose:
+		s.receiveClose()
+	case TypeStreamReset:
+		s.fail(&StreamError{Reason: frame.Error})
+		m.remove(s.id)
+	}
+	return true, nil
+}
+
+// Close fails every open stream with err and rejects new ones. It is called when
+// the underlying connection goes away.
+func (m *StreamManager) Close(err error) {
+	m.mu.Lock()
+	if m.closed != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = err
+	streams := m.streams
+	m.streams = make(map[uint64]*Stream)
+	m.mu.Unlock()
+
+	for _, s := range streams {
+		s.fail(err)
+	}
+}
+
+// sendControl queues a control frame without waiting for it to be written. Frames
+// sent while handling a received frame must not block, or two peers whose
+// writers are both backed up would stop reading from each other. Queued frames
+// are sent in order by a single goroutine that runs while the queue is not empty.
+func (m *StreamManager) sendControl(msgType string, frame StreamFrame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed != nil {
+		return
+	}
+	m.control = append(m.control, controlFrame{msgType: msgType, frame: frame})
+	if !m.draining {
+		m.draining = true
+		go m.drainControl()
+	}
+}
+
+func (m *StreamManager) drainControl() {
+	for {
+		m.mu.Lock()
+		if len(m.control) == 0 || m.closed != nil {
+			// Frames left after Close have no connection to go to.
+			m.control = nil
+			m.draining = false
+			m.mu.Unlock()
+			return
+		}
+		c := m.control[0]
+		m.control = m.control[1:]
+		m.mu.Unlock()
+
+		if err := m.send(c.msgType, c.frame, WithPriority(PriorityHigh)); err != nil {
+			m.logger.Warnf("Failed to send '%s' for stream %d: %v", c.msgType, c.frame.ID, err)
+		}
+	}
+}
+
+func (m *StreamManager) remove(id uint64) {
+	m.mu.Lock()
+	delete(m.streams, id)
+	m.mu.Unlock()
+}
+
+// Stream is one side of a byte stream carried over a conduit connection.
+//
+// The opening side typically writes and then calls Close, which waits until the
+// receiving handler has finished and reports its error, if any. The receiving
+// side reads until io.EOF. Either side may abort the stream with CloseWithError,
+// which unblocks the peer with a *StreamError.
+type Stream struct {
+	id      uint64
+	msgType string
+	manager *StreamManager
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu            sync.Mutex
+	changed       chan struct{}
+	buf           bytes.Buffer
+	unacked       int64
+	window        int64
+	readErr       error
+	writeErr      error
+	readClosed    bool
+	localClosed   bool
+	remoteClosed  bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(m *StreamManager, id uint64, msgType string) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Stream{
+		id:      id,
+		msgType: msgType,
+		manager: m,
+		ctx:     ctx,
+		cancel:  cancel,
+		changed: make(chan struct{}),
+		window:  StreamWindowSize,
+	}
+}
+
+// ID returns the identifier of the stream on its connection.
+func (s *Stream) ID() uint64 {
+	return s.id
+}
+
+// Type returns the message type the stream was opened with.
+func (s *Stream) Type() string {
+	return s.msgType
+}
+
+// Context returns a context that is canceled once the stream is finished, reset
+// by either side or its connection is lost.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Read reads data sent by the peer. It returns io.EOF once the peer has closed
+// its write side and all data has been consumed.
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.buf.Len() > 0 {
+			n, _ := s.buf.Read(p)
+			s.unacked += int64(n)
+			var increment int64
+			if s.unacked >= StreamWindowSize/2 && !s.remoteClosed {
+				increment, s.unacked = s.unacked, 0
+			}
+			s.mu.Unlock()
+
+			if increment > 0 {
+				s.manager.send(TypeStreamWindow, StreamFrame{ID: s.id, Increment: increment},
+					WithPriority(PriorityHigh))
+			}
+			return n, nil
+		}
+		if s.readErr != nil {
+			err := s.readErr
+			s.mu.Unlock()
+			return 0, err
+		}
+		changed, deadline := s.changed, s.readDeadline
+		s.mu.Unlock()
+		if err := wait(changed, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends p to the peer, blocking while the peer's receive window is full.
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		s.mu.Lock()
+		for s.writeErr == nil && s.window == 0 {
+			changed, deadline := s.changed, s.writeDeadline
+			s.mu.Unlock()
+			if err := wait(changed, deadline); err != nil {
+				return written, err
+			}
+			s.mu.Lock()
+		}
+		if s.writeErr != nil {
+			err := s.writeErr
+			s.mu.Unlock()
+			return written, err
+		}
+		n := len(p)
+		if int64(n) > s.window {
+			n = int(s.window)
+		}
+		if n > StreamChunkSize {
+			n = StreamChunkSize
+		}
+		s.window -= int64(n)
+		s.mu.Unlock()
+
+		if err := s.manager.send(TypeStreamData, StreamFrame{ID: s.id, Data: p[:n]},
+			WithPriority(PriorityLow)); err != nil {
+			s.fail(err)
+			s.manager.remove(s.id)
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite closes the write side of the stream. The peer reads io.EOF once it
+// has consumed the remaining data.
+func (s *Stream) CloseWrite() error {
+	s.mu.Lock()
+	if s.localClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	if s.writeErr == nil {
+		s.writeErr = ErrStreamClosed
+	}
+	finished := s.remoteClosed
+	s.signal()
+	s.mu.Unlock()
+
+	err := s.manager.send(TypeStreamClose, StreamFrame{ID: s.id})
+	if finished {
+		s.release()
+	}
+	return err
+}
+
+// CloseRead stops reading from the stream. Buffered and future data from the peer
+// is discarded, and its window credit returned, so the peer's writes keep
+// succeeding until it closes its side. Reads fail with ErrStreamClosed.
+func (s *Stream) CloseRead() error {
+	s.mu.Lock()
+	if s.readClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.readClosed = true
+	discarded := int64(s.buf.Len()) + s.unacked
+	s.buf.Reset()
+	s.unacked = 0
+	if s.readErr == nil || s.readErr == io.EOF {
+		s.readErr = ErrStreamClosed
+	}
+	remoteOpen := !s.remoteClosed
+	s.signal()
+	s.mu.Unlock()
+
+	if remoteOpen && discarded > 0 {
+		return s.manager.send(TypeStreamWindow, StreamFrame{ID: s.id, Increment: discarded},
+			WithPriority(PriorityHigh))
+	}
+	return nil
+}
+
+// SetReadDeadline sets the deadline for pending and future Read calls. Reads
+// past the deadline fail with os.ErrDeadlineExceeded. A zero value disables it.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.signal()
+	s.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for pending and future Write calls that are
+// waiting for window credit. A zero value disables it.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.writeDeadline = t
+	s.signal()
+	s.mu.Unlock()
+	return nil
+}
+
+// SetDeadline sets both the read and write deadlines.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+// Close closes the write side of the stream and waits until the peer has
+// finished with it. It returns the error the peer reset the stream with, if any.
+func (s *Stream) Close() error {
+	if err := s.CloseWrite(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for !s.remoteClosed {
+		changed := s.changed
+		s.mu.Unlock()
+		<-changed
+		s.mu.Lock()
+	}
+	err := s.readErr
+	s.mu.Unlock()
+
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// CloseWithError aborts the stream in both directions. The peer's pending and
+// future reads and writes fail with a *StreamError carrying err's message.
+func (s *Stream) CloseWithError(err error) error {
+	if err == nil {
+		err = ErrStreamClosed
+	}
+	s.mu.Lock()
+	if s.localClosed && s.remoteClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	s.fail(err)
+	s.manager.remove(s.id)
+	return s.manager.send(TypeStreamReset, StreamFrame{ID: s.id, Error: err.Error()},
+		WithPriority(PriorityHigh))
+}
+
+// Finish completes a stream handed to a stream handler and reports handlerErr to
+// the peer. A clean return closes the stream, unless the handler stopped reading
+// early, in which case the writer is told so instead of waiting for window credit.
+func (s *Stream) Finish(handlerErr error) {
+	s.mu.Lock()
+	drained := s.readClosed || s.remoteClosed && s.buf.Len() == 0
+	s.mu.Unlock()
+
+	switch {
+	case handlerErr != nil:
+		s.CloseWithError(handlerErr)
+	case !drained:
+		s.CloseWithError(errors.New("handler returned before reading the whole stream"))
+	default:
+		s.CloseWrite()
+	}
+}
+
+func (s *Stream) receiveData(data []byte) {
+	s.mu.Lock()
+	if s.remoteClosed {
+		s.mu.Unlock()
+		return
+	}
+	if s.readClosed {
+		s.mu.Unlock()
+		s.manager.sendControl(TypeStreamWindow, StreamFrame{ID: s.id, Increment: int64(len(data))})
+		return
+	}
+	s.buf.Write(data)
+	overflow := int64(s.buf.Len()) > StreamWindowSize
+	s.signal()
+	s.mu.Unlock()
+
+	if overflow {
+		err := errors.New("peer exceeded the stream window")
+		s.fail(err)
+		s.manager.remove(s.id)
+		s.manager.sendControl(TypeStreamReset, StreamFrame{ID: s.id, Error: err.Error()})
+	}
+}
+
+func (s *Stream) receiveWindow(increment int64) {
+	s.mu.Lock()
+	s.window += increment
+	s.signal()
+	s.mu.Unlock()
+}
+
+func (s *Stream) receiveClose() {
+	s.mu.Lock()
+	s.remoteClosed = true
+	if s.readErr == nil {
+		s.readErr = io.EOF
+	}
+	finished := s.localClosed
+	s.signal()
+	s.mu.Unlock()
+
+	if finished {
+		s.release()
+	}
+}
+
+// fail terminates both directions of the stream with err.
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	if s.readErr == nil {
+		s.readErr = err
+	}
+	if s.writeErr == nil {
+		s.writeErr = err
+	}
+	s.localClosed = true
+	s.remoteClosed = true
+	s.signal()
+	s.mu.Unlock()
+	s.cancel()
+}
+
+func (s *Stream) release() {
+	s.manager.remove(s.id)
+	s.cancel()
+}
+
+// wait blocks until changed is closed or the deadline passes. A zero deadline
+// waits indefinitely.
+func wait(changed <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-changed
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-changed:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// signal wakes every goroutine waiting for a state change. Must be called with s.mu held.
+func (s *Stream) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,172 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/conduittest"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// benchTransports are the harnesses benchmarks compare, so the numbers for Unix
+// sockets can be read against TCP loopback and in-process connections.
+var benchTransports = []struct {
+	name       string
+	newHarness func(testing.TB, func(*conduit.ServerConfig)) *conduittest.Harness
+}{
+	{"unix", conduittest.NewUnixHarness},
+	{"tcp", func(t testing.TB, configure func(*conduit.ServerConfig)) *conduittest.Harness {
+		return conduittest.NewHarnessAt(t, "tcp://127.0.0.1:0", configure)
+	}},
+	{"memory", conduittest.NewHarness},
+}
+
+var benchSizes = []int{64, 4 * 1024, 64 * 1024}
+
+// quietServer discards the errors logged as benchmark servers stop under their
+// connections.
+func quietServer(cfg *conduit.ServerConfig) {
+	cfg.Logger = conduit.NewLogger(conduit.LogError, io.Discard)
+}
+
+func benchEcho(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+	return msg.Payload, nil
+}
+
+// BenchmarkSendReceive measures one-way throughput: the client sends b.N
+// messages and the benchmark ends when the server has received all of them.
+func BenchmarkSendReceive(b *testing.B) {
+	for _, tr := range benchTransports {
+		for _, size := range benchSizes {
+			b.Run(fmt.Sprintf("%s/%dB", tr.name, size), func(b *testing.B) {
+				var received int64
+				done := make(chan struct{})
+				h := tr.newHarness(b, quietServer)
+				h.Server.Handle("bench", func(*server.Connection, *conduit.Message) error {
+					if atomic.AddInt64(&received, 1) == int64(b.N) {
+						close(done)
+					}
+					return nil
+				})
+				c := h.Client(nil)
+
+				payload := strings.Repeat("x", size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := c.Send("bench", payload); err != nil {
+						b.Fatalf("Failed to send: %v", err)
+					}
+				}
+				select {
+				case <-done:
+				case <-time.After(time.Minute):
+					b.Fatalf("Server received %d of %d messages", atomic.LoadInt64(&received), b.N)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRequestLatency measures sequential request/reply round trips and
+// reports the 50th, 99th and 99.9th latency percentiles.
+func BenchmarkRequestLatency(b *testing.B) {
+	for _, tr := range benchTransports {
+		b.Run(tr.name, func(b *testing.B) {
+			h := tr.newHarness(b, quietServer)
+			h.Server.HandleRequest("echo", benchEcho)
+			c := h.Client(nil)
+
+			ctx := context.Background()
+			latencies := make([]time.Duration, b.N)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				if err := c.Request(ctx, "echo", "ping", nil); err != nil {
+					b.Fatalf("Request failed: %v", err)
+				}
+				latencies[i] = time.Since(start)
+			}
+			b.StopTimer()
+			reportPercentiles(b, latencies)
+		})
+	}
+}
+
+// BenchmarkBroadcast measures how long a broadcast takes to reach every one of
+// N connected clients.
+func BenchmarkBroadcast(b *testing.B) {
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			h := conduittest.NewHarness(b, quietServer)
+
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				c := h.Client(nil)
+				c.Handle("bench", func(*client.Client, *conduit.Message) error {
+					wg.Done()
+					return nil
+				})
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wg.Add(n)
+				if err := h.Server.Broadcast("bench", "hello"); err != nil {
+					b.Fatalf("Failed to broadcast: %v", err)
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
+
+// BenchmarkCodec measures encoding and decoding a message, mostly to track
+// allocations per message.
+func BenchmarkCodec(b *testing.B) {
+	for _, name := range []string{"json", "gob"} {
+		b.Run(name, func(b *testing.B) {
+			codec, _ := conduit.LookupCodec(name)
+			msg, _ := conduit.NewMessage("bench", map[string]interface{}{"id": 42, "name": "conduit"},
+				conduit.WithHeader(conduit.HeaderTraceID, "abc"))
+			var buf bytes.Buffer
+			enc, dec := codec.NewEncoder(&buf), codec.NewDecoder(&buf)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(msg); err != nil {
+					b.Fatal(err)
+				}
+				var out conduit.Message
+				if err := dec.Decode(&out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func reportPercentiles(b *testing.B, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for _, p := range []struct {
+		unit string
+		q    float64
+	}{{"p50-ns", 0.50}, {"p99-ns", 0.99}, {"p99.9-ns", 0.999}} {
+		i := int(p.q * float64(len(latencies)-1))
+		b.ReportMetric(float64(latencies[i]), p.unit)
+	}
+}
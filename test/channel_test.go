@@ -0,0 +1,127 @@
+package test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestChannelsAreIndependent tests that a stalled channel does not block other channels
+// on the same connection and that channels work as net.Conn.
+func TestChannelsAreIndependent(t *testing.T) {
+	socketPath := "/tmp/conduit_channel_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startStreamServer(t, socketPath)
+	release := make(chan struct{})
+	srv.HandleChannel("stalled", func(_ *server.Connection, ch *conduit.Channel) error {
+		<-release
+		return nil
+	})
+	srv.HandleChannel("echo", func(_ *server.Connection, ch *conduit.Channel) error {
+		_, err := io.Copy(ch, ch)
+		return err
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+	defer close(release)
+
+	c := connectStreamClient(t, socketPath, nil)
+	defer c.Close()
+
+	stalled, err := c.OpenChannel("stalled")
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	defer stalled.Close()
+	stalled.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = stalled.Write(make([]byte, 2*conduit.StreamWindowSize))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected write beyond the window to time out, got %v", err)
+	}
+
+	echo, err := c.OpenChannel("echo")
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	defer echo.Close()
+	if echo.LocalAddr().Network() != "conduit" {
+		t.Errorf("Expected 'conduit' network, got '%s'", echo.LocalAddr().Network())
+	}
+
+	echo.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := echo.Write([]byte("hello channel\n")); err != nil {
+		t.Fatalf("Failed to write to channel: %v", err)
+	}
+	line, err := bufio.NewReader(echo).ReadString('\n')
+	if err != nil || line != "hello channel\n" {
+		t.Errorf("Expected echoed line, got %q (%v)", line, err)
+	}
+}
+
+// TestChannelMessages tests exchanging messages over a channel.
+func TestChannelMessages(t *testing.T) {
+	socketPath := "/tmp/conduit_channel_messages_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startStreamServer(t, socketPath)
+	srv.HandleChannel("chat", func(_ *server.Connection, ch *conduit.Channel) error {
+		for {
+			msg, err := ch.Receive()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			var text string
+			msg.UnmarshalPayload(&text)
+			if err := ch.Send("reply", "re: "+text); err != nil {
+				return err
+			}
+		}
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	c := connectStreamClient(t, socketPath, nil)
+	defer c.Close()
+
+	ch, err := c.OpenChannel("chat")
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	ch.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	for _, text := range []string{"one", "two"} {
+		if err := ch.Send("say", text); err != nil {
+			t.Fatalf("Failed to send on channel: %v", err)
+		}
+		msg, err := ch.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive on channel: %v", err)
+		}
+		var reply string
+		msg.UnmarshalPayload(&reply)
+		if msg.Type != "reply" || reply != "re: "+text {
+			t.Errorf("Unexpected reply %s %q", msg.Type, reply)
+		}
+	}
+
+	if err := ch.CloseWrite(); err != nil {
+		t.Fatalf("Failed to close channel: %v", err)
+	}
+	if _, err := ch.Receive(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the server finished, got %v", err)
+	}
+	ch.Close()
+}
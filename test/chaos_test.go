@@ -0,0 +1,257 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/chaos"
+	"github.com/crazywolf132/conduit/conduittest"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestChaosReconnect tests that clients recover from connections reset under them.
+func TestChaosReconnect(t *testing.T) {
+	transport, err := chaos.Register("chaos-reconnect", "memory", &chaos.Config{})
+	if err != nil {
+		t.Fatalf("Failed to register transport: %v", err)
+	}
+	h := conduittest.NewHarnessAt(t, "chaos-reconnect://reconnect", nil)
+	h.Server.HandleRequest("echo", echoHandler)
+	c := h.Client(reconnecting)
+
+	var reply string
+	if err := c.Call("echo", "before", &reply); err != nil || reply != "before" {
+		t.Fatalf("Expected 'before', got %q (%v)", reply, err)
+	}
+
+	transport.ResetAll()
+	if n := transport.Injected(chaos.Reset); n != 2 {
+		t.Errorf("Expected both ends to be reset, got %d", n)
+	}
+
+	awaitEcho(t, c, "after")
+}
+
+// TestChaosLatency tests that delayed writes slow calls down without failing them.
+func TestChaosLatency(t *testing.T) {
+	transport, err := chaos.Register("chaos-latency", "memory", &chaos.Config{
+		Faults: []chaos.Fault{
+			// The server's second write is its reply to the first request.
+			{Kind: chaos.Latency, Side: chaos.Server, Op: chaos.Write, After: 2, Delay: 50 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register transport: %v", err)
+	}
+	h := conduittest.NewHarnessAt(t, "chaos-latency://latency", nil)
+	h.Server.HandleRequest("echo", echoHandler)
+	c := h.Client(nil)
+
+	start := time.Now()
+	var reply string
+	if err := c.Call("echo", "slow", &reply); err != nil || reply != "slow" {
+		t.Fatalf("Expected 'slow', got %q (%v)", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the reply to be delayed by 50ms, took %v", elapsed)
+	}
+	if n := transport.Injected(chaos.Latency); n != 1 {
+		t.Errorf("Expected 1 delayed write, got %d", n)
+	}
+}
+
+// TestChaosCorrupt tests that a corrupted message fails to decode, the server
+// drops the connection and the client reconnects.
+func TestChaosCorrupt(t *testing.T) {
+	transport, err := chaos.Register("chaos-corrupt", "memory", &chaos.Config{
+		Faults: []chaos.Fault{
+			// The client's third write is its second message after the hello.
+			{Kind: chaos.Corrupt, Side: chaos.Client, Op: chaos.Write, After: 3},
+		},
+		// The seed fixes the byte flipped, so the corruption breaks the JSON
+		// rather than a string inside it.
+		Seed: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to register transport: %v", err)
+	}
+	logs := make(logLines, 16)
+	h := conduittest.NewHarnessAt(t, "chaos-corrupt://corrupt", func(cfg *conduit.ServerConfig) {
+		cfg.Logger = conduit.NewLogger(conduit.LogError, logs)
+	})
+	h.Server.HandleRequest("echo", echoHandler)
+	c := h.Client(reconnecting)
+	first := c.Session().ConnectionID
+
+	var reply string
+	if err := c.Call("echo", "before", &reply); err != nil || reply != "before" {
+		t.Fatalf("Expected 'before', got %q (%v)", reply, err)
+	}
+	if err := c.Send("bulk", make([]int, 1000)); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	logs.expect(t, "Failed to decode message from "+first)
+
+	awaitEcho(t, c, "after")
+	if id := c.Session().ConnectionID; id == first {
+		t.Errorf("Expected a new connection, got %s again", id)
+	}
+	if n := transport.Injected(chaos.Corrupt); n != 1 {
+		t.Errorf("Expected 1 corrupted write, got %d", n)
+	}
+}
+
+// TestChaosFaults tests scheduled partial writes and stalls against client and server.
+func TestChaosFaults(t *testing.T) {
+	transport, err := chaos.Register("chaos-faults", "memory", &chaos.Config{
+		Faults: []chaos.Fault{
+			// The client's second write is its first message after the hello.
+			{Kind: chaos.PartialWrite, Side: chaos.Client, After: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register transport: %v", err)
+	}
+	srv := startEchoServer(t, "chaos-faults://partial", nil)
+	defer srv.Stop()
+
+	reply, err := echoOver(t, "chaos-faults://partial", nil)
+	if err == nil {
+		t.Errorf("Expected the call to fail after a partial write, got %q", reply)
+	}
+	if n := transport.Injected(chaos.PartialWrite); n != 1 {
+		t.Errorf("Expected 1 partial write, got %d", n)
+	}
+
+	stalled, err := chaos.Register("chaos-stall", "memory", &chaos.Config{
+		Faults: []chaos.Fault{{Kind: chaos.Stall, Side: chaos.Server, Op: chaos.Read, Probability: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register transport: %v", err)
+	}
+	stallSrv := startEchoServer(t, "chaos-stall://stall", func(cfg *conduit.ServerConfig) {
+		cfg.ReadTimeout = 50 * time.Millisecond
+	})
+	defer stallSrv.Stop()
+
+	start := time.Now()
+	if _, err := echoOver(t, "chaos-stall://stall", nil); err == nil {
+		t.Error("Expected the server's read timeout to drop the stalled connection")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the read timeout to end the stall, took %v", elapsed)
+	}
+	if stalled.Injected(chaos.Stall) == 0 {
+		t.Error("Expected a stall to be injected")
+	}
+}
+
+// TestChaosStallDeadline tests that a deadline set while an operation is stalled
+// ends the stall.
+func TestChaosStallDeadline(t *testing.T) {
+	transport, err := chaos.Register("chaos-stall-deadline", "memory", &chaos.Config{
+		Faults: []chaos.Fault{{Kind: chaos.Stall, Side: chaos.Client, Op: chaos.Read, Probability: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register transport: %v", err)
+	}
+	ln, err := conduit.Listen("chaos-stall-deadline://stall", nil)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			defer conn.Close()
+		}
+	}()
+	conn, err := conduit.Dial(context.Background(), "chaos-stall-deadline://stall", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Hour))
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		result <- err
+	}()
+	for transport.Injected(chaos.Stall) == 0 {
+		runtime.Gosched()
+	}
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	select {
+	case err := <-result:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Expected the read to time out, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Deadline set during the stall did not end it")
+	}
+}
+
+// awaitEcho calls "echo" until the client has reconnected and the call
+// succeeds, failing the test after two seconds.
+func awaitEcho(t *testing.T, c *conduittest.Client, text string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		var reply string
+		err := c.CallContext(ctx, "echo", text, &reply)
+		cancel()
+		if err == nil && reply == text {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Client did not recover: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func echoHandler(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+	var text string
+	err := msg.UnmarshalPayload(&text)
+	return text, err
+}
+
+// reconnecting configures a harness client to reconnect quickly.
+func reconnecting(cfg *conduit.ClientConfig) {
+	cfg.Reconnect = true
+	cfg.ReconnectDelay = 10 * time.Millisecond
+}
+
+// logLines is a log output that passes on each line written.
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	select {
+	case l <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+// expect waits for a line containing text, failing the test after a second.
+func (l logLines) expect(t *testing.T, text string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case line := <-l:
+			if strings.Contains(line, text) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected %q to be logged", text)
+		}
+	}
+}
@@ -166,3 +166,100 @@ func TestClientContext(t *testing.T) {
 		t.Errorf("Expected context value 42, got %v", val)
 	}
 }
+
+// TestClientSendHeaders tests that headers set on Send reach the server handler.
+func TestClientSendHeaders(t *testing.T) {
+	socketPath := "/tmp/conduit_headers_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+
+	received := make(chan *conduit.Message, 1)
+	srv.Handle("traced", func(conn *server.Connection, msg *conduit.Message) error {
+		received <- msg
+		return nil
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	c := client.NewClient(clientCfg)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("traced", "payload", conduit.WithID("msg-1"), conduit.WithHeader(conduit.HeaderTraceID, "abc")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.ID != "msg-1" {
+			t.Errorf("Expected ID 'msg-1', got '%s'", msg.ID)
+		}
+		if msg.Header(conduit.HeaderTraceID) != "abc" {
+			t.Errorf("Expected trace ID 'abc', got '%s'", msg.Header(conduit.HeaderTraceID))
+		}
+	case <-time.After(time.Second):
+		t.Error("Timeout waiting for message")
+	}
+}
+
+// TestClientHandleDefault tests that the default handler receives messages without a handler.
+func TestClientHandleDefault(t *testing.T) {
+	socketPath := "/tmp/conduit_default_handler_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+	srv.Handle("trigger", func(conn *server.Connection, msg *conduit.Message) error {
+		if err := conn.Send("handled", 1); err != nil {
+			return err
+		}
+		return conn.Send("unhandled", 2)
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	c := client.NewClient(clientCfg)
+
+	received := make(chan string, 2)
+	c.Handle("handled", func(_ *client.Client, msg *conduit.Message) error {
+		received <- "handler:" + msg.Type
+		return nil
+	})
+	c.HandleDefault(func(_ *client.Client, msg *conduit.Message) error {
+		received <- "default:" + msg.Type
+		return nil
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("trigger", nil); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	for _, want := range []string{"handler:handled", "default:unhandled"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("Expected '%s', got '%s'", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for message")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestMessageCompression tests that payloads round-trip through every built-in compressor.
+func TestMessageCompression(t *testing.T) {
+	payload := strings.Repeat("conduit ", 4096)
+
+	for _, name := range []string{"gzip", "flate"} {
+		c, ok := conduit.LookupCompressor(name)
+		if !ok {
+			t.Fatalf("Expected compressor '%s' to be registered", name)
+		}
+
+		msg, _ := conduit.NewMessage("bulk", payload)
+		original := append([]byte(nil), msg.Payload...)
+		if err := msg.Compress(c); err != nil {
+			t.Fatalf("Failed to compress with %s: %v", name, err)
+		}
+		if msg.Encoding != name || len(msg.Payload) >= len(original) {
+			t.Errorf("Expected smaller %s payload, got %d bytes (encoding '%s')", name, len(msg.Payload), msg.Encoding)
+		}
+
+		if err := msg.Decompress(int64(len(original))); err != nil {
+			t.Fatalf("Failed to decompress with %s: %v", name, err)
+		}
+		if msg.Encoding != "" || !bytes.Equal(msg.Payload, original) {
+			t.Errorf("Expected %s payload to round-trip", name)
+		}
+	}
+}
+
+// TestCompressionNotSmaller tests that payloads whose compressed form would be
+// larger once base64 encoded are sent uncompressed.
+func TestCompressionNotSmaller(t *testing.T) {
+	session := &conduit.Session{Compression: "gzip"}
+	data := make([]byte, 4096)
+	rand.Read(data)
+
+	// Random bytes do not compress, and encoding them as a base64 string already
+	// makes the payload a third larger than the raw bytes.
+	msg, _ := conduit.NewMessage("random", data)
+	wire, err := session.Compress(msg, 1024)
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if wire != msg {
+		t.Errorf("Expected an incompressible payload to be sent as is, got encoding '%s'", wire.Encoding)
+	}
+
+	msg, _ = conduit.NewMessage("text", strings.Repeat("conduit ", 4096))
+	if wire, _ := session.Compress(msg, 1024); wire.Encoding != "gzip" {
+		t.Errorf("Expected a compressible payload to be compressed, got encoding '%s'", wire.Encoding)
+	}
+}
+
+// TestDecompressionLimit tests that a compressed payload expanding beyond the limit is rejected.
+func TestDecompressionLimit(t *testing.T) {
+	c, _ := conduit.LookupCompressor("gzip")
+	msg, _ := conduit.NewMessage("bomb", strings.Repeat("0", 1024*1024))
+	if err := msg.Compress(c); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+
+	if err := msg.Decompress(1024); err == nil {
+		t.Error("Expected decompression beyond the limit to fail")
+	}
+}
+
+// TestNegotiatedCompression tests that the server decompresses inbound payloads and compresses
+// large outbound payloads with the negotiated algorithm.
+func TestNegotiatedCompression(t *testing.T) {
+	socketPath := "/tmp/conduit_compression_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.CompressionThreshold = 1024
+	s := server.NewServer(cfg)
+
+	s.Handle("echo", func(conn *server.Connection, msg *conduit.Message) error {
+		return conn.Send("echo_response", msg.Payload)
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	hello := conduit.NewHello([]string{"json"}, []string{"flate"})
+	helloMsg, _ := conduit.NewMessage(conduit.TypeHello, hello)
+	if err := encoder.Encode(helloMsg); err != nil {
+		t.Fatalf("Failed to send hello: %v", err)
+	}
+	var reply conduit.Message
+	if err := decoder.Decode(&reply); err != nil {
+		t.Fatalf("Failed to read hello reply: %v", err)
+	}
+	var replyHello conduit.Hello
+	reply.UnmarshalPayload(&replyHello)
+	if replyHello.Compression != "flate" {
+		t.Fatalf("Expected 'flate' compression, got '%s'", replyHello.Compression)
+	}
+
+	payload := strings.Repeat("abcdefgh", 16*1024)
+	msg, _ := conduit.NewMessage("echo", payload)
+	flate, _ := conduit.LookupCompressor("flate")
+	if err := msg.Compress(flate); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if err := encoder.Encode(msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	var echoed conduit.Message
+	if err := decoder.Decode(&echoed); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if echoed.Encoding != "flate" {
+		t.Errorf("Expected response to be compressed with flate, got '%s'", echoed.Encoding)
+	}
+	if err := echoed.Decompress(cfg.MaxMessageSize); err != nil {
+		t.Fatalf("Failed to decompress response: %v", err)
+	}
+	var got string
+	if err := echoed.UnmarshalPayload(&got); err != nil || got != payload {
+		t.Errorf("Expected echoed payload to match (%v)", err)
+	}
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/crazywolf132/conduit"
 )
@@ -71,3 +72,90 @@ func (m *mockReader) Read(p []byte) (int, error) {
 	}
 	return len(p), nil
 }
+
+// TestMessageDeadline tests that message deadlines are set by options and survive encoding.
+func TestMessageDeadline(t *testing.T) {
+	msg, err := conduit.NewMessage("test", "payload", conduit.WithTTL(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	deadline, ok := msg.Deadline()
+	if !ok {
+		t.Fatal("Expected message to have a deadline")
+	}
+	if msg.Expired() {
+		t.Error("Expected message not to be expired yet")
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	var decoded conduit.Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if got, _ := decoded.Deadline(); !got.Equal(deadline) {
+		t.Errorf("Expected deadline %v, got %v", deadline, got)
+	}
+
+	past, _ := conduit.NewMessage("test", "payload", conduit.WithDeadline(time.Now().Add(-time.Second)))
+	if !past.Expired() {
+		t.Error("Expected message with past deadline to be expired")
+	}
+
+	legacy := conduit.Message{Type: "test"}
+	if _, ok := legacy.Deadline(); ok || legacy.Expired() {
+		t.Error("Expected message without deadline never to expire")
+	}
+}
+
+// TestMessageHeaders tests the standard envelope fields and header options.
+func TestMessageHeaders(t *testing.T) {
+	msg, err := conduit.NewMessage("test", "payload",
+		conduit.WithHeader(conduit.HeaderTraceID, "trace-123"),
+		conduit.WithHeaders(map[string]string{conduit.HeaderTenantID: "acme"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	if msg.ID == "" {
+		t.Error("Expected message to have an ID")
+	}
+	if msg.Time().IsZero() {
+		t.Error("Expected message to have a timestamp")
+	}
+	if msg.ContentType != conduit.DefaultContentType {
+		t.Errorf("Expected content type %s, got %s", conduit.DefaultContentType, msg.ContentType)
+	}
+	if msg.Header(conduit.HeaderTraceID) != "trace-123" || msg.Header(conduit.HeaderTenantID) != "acme" {
+		t.Errorf("Unexpected headers: %v", msg.Headers)
+	}
+
+	other, _ := conduit.NewMessage("test", "payload", conduit.WithID("custom"))
+	if other.ID != "custom" {
+		t.Errorf("Expected ID 'custom', got '%s'", other.ID)
+	}
+}
+
+// TestLegacyMessageDecoding tests that messages with only type and payload still decode.
+func TestLegacyMessageDecoding(t *testing.T) {
+	var msg conduit.Message
+	if err := json.Unmarshal([]byte(`{"type":"greeting","payload":"hi"}`), &msg); err != nil {
+		t.Fatalf("Failed to decode legacy message: %v", err)
+	}
+
+	if msg.Type != "greeting" || msg.ID != "" || msg.Header(conduit.HeaderTraceID) != "" {
+		t.Errorf("Unexpected decoded message: %+v", msg)
+	}
+	if !msg.Time().IsZero() {
+		t.Error("Expected zero time for message without timestamp")
+	}
+
+	var payload string
+	if err := msg.UnmarshalPayload(&payload); err != nil || payload != "hi" {
+		t.Errorf("Expected payload 'hi', got '%s' (%v)", payload, err)
+	}
+}
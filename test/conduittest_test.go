@@ -0,0 +1,147 @@
+package test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/conduittest"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestConduittestConn tests calling a handler directly with a recording connection.
+func TestConduittestConn(t *testing.T) {
+	greet := func(conn *server.Connection, msg *conduit.Message) error {
+		var name string
+		if err := msg.UnmarshalPayload(&name); err != nil {
+			return err
+		}
+		conn.SetContext("greeted", name)
+		return conn.Send("greeting", "Hello, "+name)
+	}
+
+	conn := conduittest.NewConn(t)
+	if err := greet(conn.Connection, conduittest.NewMessage(t, "greet", "Ada")); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	var greeting string
+	conn.ExpectMessage(t, "greeting", time.Second).UnmarshalPayload(&greeting)
+	if greeting != "Hello, Ada" {
+		t.Errorf("Expected 'Hello, Ada', got %q", greeting)
+	}
+	if name, _ := conn.GetContext("greeted"); name != "Ada" {
+		t.Errorf("Expected the handler to store 'Ada', got %v", name)
+	}
+	conn.Sent().ExpectNoMessage(t, "greeting", 10*time.Millisecond)
+
+	if err := conn.Reply(conduittest.NewMessage(t, "req", nil, conduit.WithID("r1")), 42); err != nil {
+		t.Fatalf("Failed to reply: %v", err)
+	}
+	if reply := conn.ExpectMessage(t, conduit.TypeRPCReply, time.Second); reply.ReplyTo != "r1" || string(reply.Payload) != "42" {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+}
+
+// TestConduittestHarness tests exchanging messages with clients from the harness.
+func TestConduittestHarness(t *testing.T) {
+	h := conduittest.NewHarness(t, nil)
+	h.Server.Handle("ping", func(conn *server.Connection, msg *conduit.Message) error {
+		return conn.Send("pong", msg.Payload)
+	})
+
+	c := h.Client(nil)
+	if err := c.Send("ping", 1); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if pong := c.ExpectMessage(t, "pong", time.Second); string(pong.Payload) != "1" {
+		t.Errorf("Expected pong 1, got %s", pong.Payload)
+	}
+	if n := len(c.Received().Messages()); n != 1 {
+		t.Errorf("Expected 1 recorded message, got %d", n)
+	}
+}
+
+// TestConduittestHarnessTraffic tests that a harness over a Unix socket makes its
+// clients ready for broadcasts without sending anything besides the handshake.
+func TestConduittestHarnessTraffic(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	h := conduittest.NewUnixHarness(t, nil)
+	h.Server.Tap(func(_ *server.Connection, msg *conduit.Message, inbound bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if inbound {
+			seen = append(seen, "in "+msg.Type)
+		} else {
+			seen = append(seen, "out "+msg.Type)
+		}
+	})
+	if !strings.HasSuffix(h.Address, ".sock") {
+		t.Errorf("Expected a Unix socket address, got %q", h.Address)
+	}
+
+	h.Client(nil)
+	h.Client(nil)
+	if err := h.Server.Broadcast("news", 1); err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+	h.ExpectBroadcast(t, "news", time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"out " + conduit.TypeHello, "out " + conduit.TypeHello, "out news", "out news"}
+	if strings.Join(seen, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Expected the server to see %v, got %v", want, seen)
+	}
+}
+
+// TestConduittestHarnessOtherConnections tests that harness clients are matched
+// to their server connections by ID when other clients connect to the server too.
+func TestConduittestHarnessOtherConnections(t *testing.T) {
+	h := conduittest.NewHarness(t, nil)
+	var mu sync.Mutex
+	var ids []string
+	h.Server.OnConnect(func(conn *server.Connection) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, conn.ID())
+	})
+
+	cfg := conduit.DefaultClientConfig(h.Address)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	other := client.NewClient(cfg)
+	if err := other.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer other.Close()
+
+	c := h.Client(nil)
+	if err := h.Server.Broadcast("news", 1); err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+	h.ExpectBroadcast(t, "news", time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{other.Session().ConnectionID, c.Session().ConnectionID}
+	if strings.Join(ids, ", ") != strings.Join(want, ", ") || want[0] == want[1] {
+		t.Errorf("Expected connections %v to be ready, got %v", want, ids)
+	}
+}
+
+// TestConduittestHarnessAt tests a harness on a TCP port chosen by the system.
+func TestConduittestHarnessAt(t *testing.T) {
+	h := conduittest.NewHarnessAt(t, "tcp://127.0.0.1:0", nil)
+	if strings.HasSuffix(h.Address, ":0") {
+		t.Errorf("Expected the address of the assigned port, got %q", h.Address)
+	}
+	h.Client(nil)
+	if err := h.Server.Broadcast("news", 1); err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+	h.ExpectBroadcast(t, "news", time.Second)
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestDescribe tests that clients can list the message types a server handles.
+func TestDescribe(t *testing.T) {
+	socketPath := "/tmp/conduit_describe_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.Handle("ping", func(*server.Connection, *conduit.Message) error { return nil })
+		srv.HandleServerStream("count", func(*server.Connection, *conduit.Message, *server.ServerStream) error { return nil })
+		srv.HandleChannel("chat", func(*server.Connection, *conduit.Channel) error { return nil })
+		srv.SetSchema("ping", schema.MustParse(`{"type":"string"}`))
+		if err := srv.RegisterService("", Arith{}); err != nil {
+			t.Fatalf("Failed to register service: %v", err)
+		}
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	desc, err := c.Describe(context.Background())
+	if err != nil {
+		t.Fatalf("Describe failed: %v", err)
+	}
+	if desc.Library != conduit.Version || desc.Protocol != conduit.ProtocolVersion {
+		t.Errorf("Unexpected version info: %+v", desc)
+	}
+
+	want := map[string]string{
+		"Arith.Add":                       conduit.KindRequest,
+		"Arith.Divide":                    conduit.KindRequest,
+		conduit.ChannelStreamType("chat"): conduit.KindChannel,
+		"count":                           conduit.KindServerStream,
+		"ping":                            conduit.KindMessage,
+	}
+	if len(desc.Types) != len(want) {
+		t.Fatalf("Expected %d types, got %+v", len(want), desc.Types)
+	}
+	for msgType, kind := range want {
+		td := desc.Lookup(msgType)
+		if td == nil || td.Kind != kind {
+			t.Errorf("Expected %s to be a %s, got %+v", msgType, kind, td)
+		}
+	}
+
+	if string(desc.Lookup("ping").Request) != `{"type":"string"}` {
+		t.Errorf("Unexpected ping schema: %s", desc.Lookup("ping").Request)
+	}
+	add := desc.Lookup("Arith.Add")
+	req, err := schema.Parse(add.Request)
+	if err != nil || add.Response == nil {
+		t.Fatalf("Expected request and response schemas, got %+v (%v)", add, err)
+	}
+	if err := req.Validate([]byte(`{"A":"one"}`)); err == nil {
+		t.Error("Expected the derived request schema to reject a string operand")
+	}
+}
+
+// TestDescribeDisabled tests that reflection can be turned off.
+func TestDescribeDisabled(t *testing.T) {
+	socketPath := "/tmp/conduit_describe_disabled_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.DisableReflection = true
+	srv := server.NewServer(cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	var remote *conduit.RemoteError
+	if _, err := c.Describe(context.Background()); !errors.As(err, &remote) || remote.Code != conduit.CodeUnknownType {
+		t.Errorf("Expected RemoteError '%s', got %v", conduit.CodeUnknownType, err)
+	}
+}
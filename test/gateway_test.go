@@ -0,0 +1,276 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/gateway"
+	"github.com/crazywolf132/conduit/server"
+	"github.com/crazywolf132/conduit/websocket"
+)
+
+// TestGateway tests bridging WebSocket clients to a server, in process and
+// through an upstream socket.
+func TestGateway(t *testing.T) {
+	socketPath := "/tmp/conduit_gateway_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startEchoServer(t, socketPath, nil)
+	defer srv.Stop()
+	srv.HandleRequest("admin.shutdown", func(*server.Connection, *conduit.Message) (interface{}, error) {
+		return "shutting down", nil
+	})
+
+	gateways := map[string]func(*gateway.Config) *gateway.Gateway{
+		"server": func(cfg *gateway.Config) *gateway.Gateway { return gateway.ForServer(srv, cfg) },
+		"upstream": func(cfg *gateway.Config) *gateway.Gateway {
+			cfg.Upstream = socketPath
+			return gateway.New(cfg)
+		},
+	}
+	for name, newGateway := range gateways {
+		t.Run(name, func(t *testing.T) {
+			cfg := gateway.DefaultConfig("")
+			cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+			cfg.AllowSend = []string{"echo"}
+			gw := newGateway(cfg)
+			defer gw.Close()
+			httpSrv := httptest.NewServer(gw)
+			defer httpSrv.Close()
+
+			ws := dialGateway(t, httpSrv, httpSrv.URL)
+			defer ws.Close()
+
+			reply := roundTrip(t, ws, `{"id":"1","type":"echo","payload":"hello","expect_reply":true}`)
+			if reply.Type != conduit.TypeRPCReply || reply.ReplyTo != "1" || string(reply.Payload) != `"hello"` {
+				t.Errorf("Unexpected reply to echo: %+v", reply)
+			}
+
+			reply = roundTrip(t, ws, `{"id":"2","type":"admin.shutdown","payload":null,"expect_reply":true}`)
+			var remoteErr conduit.RemoteError
+			reply.UnmarshalPayload(&remoteErr)
+			if reply.Type != conduit.TypeRPCError || reply.ReplyTo != "2" || remoteErr.Code != conduit.CodePermissionDenied {
+				t.Errorf("Expected admin.shutdown to be denied, got %+v", reply)
+			}
+		})
+	}
+}
+
+// TestGatewayHandshake tests that browsers negotiating a session are held to JSON.
+func TestGatewayHandshake(t *testing.T) {
+	srv := server.NewServer(conduit.DefaultServerConfig(""))
+	defer srv.Stop()
+
+	cfg := gateway.DefaultConfig("")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	httpSrv := httptest.NewServer(gateway.ForServer(srv, cfg))
+	defer httpSrv.Close()
+
+	ws := dialGateway(t, httpSrv, httpSrv.URL)
+	defer ws.Close()
+
+	hello, _ := json.Marshal(conduit.NewHello([]string{"gob", "json"}, []string{"gzip"}))
+	reply := roundTrip(t, ws, `{"type":"conduit.hello","payload":`+string(hello)+`}`)
+	var accepted conduit.Hello
+	if err := reply.UnmarshalPayload(&accepted); err != nil || reply.Type != conduit.TypeHello {
+		t.Fatalf("Expected a hello reply, got %+v (%v)", reply, err)
+	}
+	if accepted.Codec != "json" || accepted.Compression != "" || accepted.Error != "" {
+		t.Errorf("Expected plain JSON to be negotiated, got %+v", accepted)
+	}
+}
+
+// TestGatewayOrigin tests that cross-origin connections need to be allowed.
+func TestGatewayOrigin(t *testing.T) {
+	srv := server.NewServer(conduit.DefaultServerConfig(""))
+	defer srv.Stop()
+
+	cfg := gateway.DefaultConfig("")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	httpSrv := httptest.NewServer(gateway.ForServer(srv, cfg))
+	defer httpSrv.Close()
+
+	ws := dialGateway(t, httpSrv, httpSrv.URL)
+	ws.Close()
+
+	_, err := websocket.Dial(context.Background(), wsURL(httpSrv), http.Header{"Origin": {"https://evil.example"}}, nil)
+	var handshakeErr *websocket.HandshakeError
+	if !errors.As(err, &handshakeErr) || handshakeErr.Status != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin connection to be forbidden, got %v", err)
+	}
+
+	cfg.AllowedOrigins = []string{"https://*.example"}
+	ws = dialGateway(t, httpSrv, "https://app.example")
+	ws.Close()
+}
+
+func wsURL(httpSrv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(httpSrv.URL, "http")
+}
+
+func dialGateway(t *testing.T, httpSrv *httptest.Server, origin string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ws, err := websocket.Dial(ctx, wsURL(httpSrv), http.Header{"Origin": {origin}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to gateway: %v", err)
+	}
+	return ws
+}
+
+// roundTrip sends a message envelope and returns the next message received.
+func roundTrip(t *testing.T, ws *websocket.Conn, envelope string) *conduit.Message {
+	t.Helper()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(envelope)); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	msgType, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to receive: %v", err)
+	}
+	if msgType != websocket.TextMessage {
+		t.Fatalf("Expected a text message, got %d", msgType)
+	}
+	var msg conduit.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Malformed message %s: %v", data, err)
+	}
+	return &msg
+}
+
+// TestHTTPGateway tests mapping HTTP requests and server-sent events onto a server.
+func TestHTTPGateway(t *testing.T) {
+	socketPath := "/tmp/conduit_http_gateway_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startEchoServer(t, socketPath, nil)
+	defer srv.Stop()
+	srv.HandleRequest("reject", func(*server.Connection, *conduit.Message) (interface{}, error) {
+		return nil, &conduit.RemoteError{Code: conduit.CodeInvalidArgument, Message: "bad input"}
+	})
+	srv.HandleRequest("trace", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		return msg.Headers[conduit.HeaderTraceID], nil
+	})
+
+	cfg := gateway.DefaultConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.AllowSend = []string{"echo", "reject", "trace", "missing"}
+	gw := gateway.NewHTTP(cfg)
+	defer gw.Close()
+	httpSrv := httptest.NewServer(gw)
+	defer httpSrv.Close()
+
+	post := func(msgType, body string, header http.Header) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, httpSrv.URL+"/rpc/"+msgType, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", msgType, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	tests := []struct {
+		msgType, body string
+		header        http.Header
+		status        int
+		response      string
+	}{
+		{"echo", `"hello"`, nil, http.StatusOK, `"hello"`},
+		{"trace", ``, http.Header{"Conduit-Trace-Id": {"abc"}}, http.StatusOK, `"abc"`},
+		{"reject", `{}`, nil, http.StatusBadRequest, `{"code":"invalid_argument","message":"bad input"}`},
+		{"missing", `{}`, nil, http.StatusNotFound, `{"code":"unknown_type","message":"no handler for message type 'missing'"}`},
+		{"admin", `{}`, nil, http.StatusForbidden, `{"code":"permission_denied","message":"message type 'admin' is not allowed"}`},
+		{"echo", `{not json`, nil, http.StatusBadRequest, `{"code":"invalid_argument","message":"request body is not valid JSON"}`},
+	}
+	for _, tt := range tests {
+		status, response := post(tt.msgType, tt.body, tt.header)
+		if status != tt.status || response != tt.response {
+			t.Errorf("POST /rpc/%s: expected %d %s, got %d %s", tt.msgType, tt.status, tt.response, status, response)
+		}
+	}
+
+	resp, err := http.Get(httpSrv.URL + "/events?type=news.*")
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	srv.Broadcast("weather", "sunny")
+	// A type with a line break would inject fields into the stream and is dropped.
+	srv.Broadcast("news.x\ndata: injected", "spoofed")
+	srv.Broadcast("news.local", map[string]string{"headline": "conduit ships"})
+
+	events := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		var event []string
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				events <- strings.Join(event, "\n")
+				return
+			}
+			if !strings.HasPrefix(line, "id: ") {
+				event = append(event, line)
+			}
+		}
+	}()
+	select {
+	case got := <-events:
+		want := "event: news.local\ndata: {\"headline\":\"conduit ships\"}"
+		if got != want {
+			t.Errorf("Expected event\n%s\ngot\n%s", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+}
+
+// TestGatewayLiteralConfig tests a gateway whose Config leaves the sizes and
+// timeouts zero, behind an HTTP server with short request timeouts that must
+// not apply to upgraded connections.
+func TestGatewayLiteralConfig(t *testing.T) {
+	socketPath := "/tmp/conduit_gateway_literal_test.sock"
+	defer os.RemoveAll(socketPath)
+	srv := startEchoServer(t, socketPath, nil)
+	defer srv.Stop()
+
+	gw := gateway.New(&gateway.Config{Upstream: socketPath, Logger: conduit.NewLogger(conduit.LogError, nil)})
+	defer gw.Close()
+	httpSrv := httptest.NewUnstartedServer(gw)
+	httpSrv.Config.ReadTimeout = 100 * time.Millisecond
+	httpSrv.Config.WriteTimeout = 100 * time.Millisecond
+	httpSrv.Start()
+	defer httpSrv.Close()
+
+	ws := dialGateway(t, httpSrv, httpSrv.URL)
+	defer ws.Close()
+
+	// Outlive the HTTP server's timeouts before using the connection.
+	time.Sleep(300 * time.Millisecond)
+	reply := roundTrip(t, ws, `{"id":"1","type":"echo","payload":"hello","expect_reply":true}`)
+	if reply.Type != conduit.TypeRPCReply || string(reply.Payload) != `"hello"` {
+		t.Errorf("Unexpected reply to echo: %+v", reply)
+	}
+}
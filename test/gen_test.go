@@ -0,0 +1,137 @@
+package test
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/crazywolf132/conduit/gen"
+)
+
+const serviceDefinition = `package inventory
+
+import (
+	"context"
+
+	"example.com/models"
+)
+
+//conduit:service
+type Inventory interface {
+	Lookup(ctx context.Context, req *models.SKU) (*Item, error)
+	Restocked(evt *Item)
+}
+
+type Item struct {
+	Count int
+}
+
+type Unrelated interface {
+	Ignored()
+}
+`
+
+// TestGenerateService tests that the generator emits typed glue for marked interfaces.
+func TestGenerateService(t *testing.T) {
+	file, err := gen.Parse("inventory.go", []byte(serviceDefinition), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse definition: %v", err)
+	}
+	if len(file.Services) != 1 {
+		t.Fatalf("Expected 1 service, got %d", len(file.Services))
+	}
+	svc := file.Services[0]
+	if len(svc.Methods) != 1 || svc.Methods[0].Request != "models.SKU" || svc.Methods[0].Response != "Item" {
+		t.Errorf("Unexpected methods: %+v", svc.Methods)
+	}
+	if len(svc.Events) != 1 || svc.Events[0].Payload != "Item" {
+		t.Errorf("Unexpected events: %+v", svc.Events)
+	}
+
+	code, err := gen.Generate(file, "inventory.go")
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "inventory_conduit.go", code, 0); err != nil {
+		t.Fatalf("Generated code does not parse: %v", err)
+	}
+	for _, want := range []string{
+		`InventoryLookup    = "Inventory.Lookup"`,
+		`"example.com/models"`,
+		"func RegisterInventoryServer(srv *server.Server, impl InventoryServer)",
+		"func (c *InventoryClient) Lookup(ctx context.Context, req *models.SKU, opts ...conduit.SendOption) (*Item, error)",
+		"func (c *InventoryClient) OnRestocked(handler func(*Item) error)",
+		"func BroadcastInventoryRestocked(srv *server.Server, evt *Item",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("Expected generated code to contain %q", want)
+		}
+	}
+}
+
+// TestGenerateRejectsInvalidMethods tests that unsupported method signatures are reported.
+func TestGenerateRejectsInvalidMethods(t *testing.T) {
+	src := `package bad
+
+import "context"
+
+type Bad interface {
+	Lookup(ctx context.Context, id string) (*Item, error)
+}
+`
+	_, err := gen.Parse("bad.go", []byte(src), []string{"Bad"})
+	if err == nil || !strings.Contains(err.Error(), "must be a pointer") {
+		t.Errorf("Expected pointer error, got %v", err)
+	}
+}
+
+// TestGenerateVersionedImports tests that imports whose package name differs from
+// the last path element are kept.
+func TestGenerateVersionedImports(t *testing.T) {
+	src := `package config
+
+import (
+	"context"
+
+	"example.com/api/v2"
+	"gopkg.in/yaml.v3"
+	store "example.com/go-store"
+)
+
+//conduit:service
+type Config interface {
+	Load(ctx context.Context, req *api.Request) (*yaml.Node, error)
+	Saved(evt *store.Entry)
+}
+`
+	file, err := gen.Parse("config.go", []byte(src), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse definition: %v", err)
+	}
+	want := []string{`"example.com/api/v2"`, `"gopkg.in/yaml.v3"`, `store "example.com/go-store"`}
+	if strings.Join(file.Imports, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected imports %v, got %v", want, file.Imports)
+	}
+
+	src = strings.Replace(src, `store "example.com/go-store"`, `"example.com/storage"`, 1)
+	if _, err := gen.Parse("config.go", []byte(src), nil); err == nil || !strings.Contains(err.Error(), "package store") {
+		t.Errorf("Expected an error about the unresolved store package, got %v", err)
+	}
+}
+
+// TestGenerateRejectsShadowingMethods tests that service methods cannot hide the
+// methods of the embedded client.
+func TestGenerateRejectsShadowingMethods(t *testing.T) {
+	for _, method := range []string{
+		"Close(ctx context.Context, req *Item) (*Item, error)",
+		"Request(ctx context.Context, req *Item) (*Item, error)",
+		"Send(ctx context.Context, req *Item) (*Item, error)",
+	} {
+		src := "package bad\n\nimport \"context\"\n\ntype Bad interface {\n\t" + method + "\n}\n"
+		_, err := gen.Parse("bad.go", []byte(src), []string{"Bad"})
+		if err == nil || !strings.Contains(err.Error(), "shadow") {
+			t.Errorf("Expected %q to be rejected, got %v", method, err)
+		}
+	}
+}
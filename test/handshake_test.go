@@ -0,0 +1,275 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestNegotiate tests that negotiation picks the best common settings.
+func TestNegotiate(t *testing.T) {
+	local := &conduit.Hello{Version: 3, MinVersion: 1, Codecs: []string{"gob", "json"}, Features: []string{"a", "b"}}
+	remote := &conduit.Hello{Version: 2, MinVersion: 2, Codecs: []string{"json", "gob"}, Features: []string{"b", "c"}}
+
+	session, err := conduit.Negotiate(local, remote)
+	if err != nil {
+		t.Fatalf("Expected negotiation to succeed, got %v", err)
+	}
+	if session.Version != 2 {
+		t.Errorf("Expected version 2, got %d", session.Version)
+	}
+	if session.Codec != "gob" {
+		t.Errorf("Expected codec 'gob', got '%s'", session.Codec)
+	}
+	if !session.HasFeature("b") || session.HasFeature("a") || session.HasFeature("c") {
+		t.Errorf("Expected only feature 'b', got %v", session.Features)
+	}
+
+	_, err = conduit.Negotiate(local, &conduit.Hello{Version: 5, MinVersion: 4, Codecs: []string{"json"}})
+	if !errors.Is(err, conduit.ErrIncompatiblePeer) {
+		t.Errorf("Expected ErrIncompatiblePeer for disjoint versions, got %v", err)
+	}
+
+	_, err = conduit.Negotiate(local, &conduit.Hello{Version: 1, MinVersion: 1, Codecs: []string{"msgpack"}})
+	if !errors.Is(err, conduit.ErrIncompatiblePeer) {
+		t.Errorf("Expected ErrIncompatiblePeer for disjoint codecs, got %v", err)
+	}
+}
+
+// TestHandshakeNegotiatesCodec tests that client and server agree on the server's preferred codec and use it.
+func TestHandshakeNegotiatesCodec(t *testing.T) {
+	socketPath := "/tmp/conduit_handshake_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	serverCfg.Codecs = []string{"gob", "json"}
+	srv := server.NewServer(serverCfg)
+
+	codecs := make(chan string, 1)
+	srv.Handle("echo", func(conn *server.Connection, msg *conduit.Message) error {
+		codecs <- conn.Session().Codec
+		return conn.Send("echo_response", msg.Payload)
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	c := client.NewClient(clientCfg)
+
+	received := make(chan string, 1)
+	c.Handle("echo_response", func(_ *client.Client, msg *conduit.Message) error {
+		var resp string
+		if err := msg.UnmarshalPayload(&resp); err != nil {
+			return err
+		}
+		received <- resp
+		return nil
+	})
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	session := c.Session()
+	if session == nil || session.Codec != "gob" || session.Version != conduit.ProtocolVersion {
+		t.Fatalf("Unexpected client session: %+v", session)
+	}
+	if !session.HasFeature(conduit.FeatureHeaders) {
+		t.Errorf("Expected feature '%s' to be negotiated", conduit.FeatureHeaders)
+	}
+
+	if err := c.Send("echo", "over gob"); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	select {
+	case codec := <-codecs:
+		if codec != "gob" {
+			t.Errorf("Expected server session codec 'gob', got '%s'", codec)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for server handler")
+	}
+
+	select {
+	case resp := <-received:
+		if resp != "over gob" {
+			t.Errorf("Expected 'over gob', got '%s'", resp)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timeout waiting for response")
+	}
+}
+
+// TestHandshakeIncompatible tests that a client without a common codec gets a clear error.
+func TestHandshakeIncompatible(t *testing.T) {
+	socketPath := "/tmp/conduit_handshake_incompatible_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	serverCfg.Codecs = []string{"gob"}
+	srv := server.NewServer(serverCfg)
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	clientCfg.Codecs = []string{"json"}
+	c := client.NewClient(clientCfg)
+	defer c.Close()
+
+	err := c.ConnectWithRetry()
+	if !errors.Is(err, conduit.ErrIncompatiblePeer) {
+		t.Fatalf("Expected ErrIncompatiblePeer, got %v", err)
+	}
+	var handshakeErr *conduit.HandshakeError
+	if !errors.As(err, &handshakeErr) || handshakeErr.Reason == "" {
+		t.Errorf("Expected a HandshakeError with a reason, got %v", err)
+	}
+	if c.IsConnected() {
+		t.Error("Expected client not to be connected")
+	}
+}
+
+// TestHandshakeLegacyServer tests that a server which never answers the hello is
+// reported as incompatible instead of being retried forever.
+func TestHandshakeLegacyServer(t *testing.T) {
+	socketPath := "/tmp/conduit_handshake_legacy_server_test.sock"
+	os.RemoveAll(socketPath)
+	defer os.RemoveAll(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Like a server without the handshake: read and never answer.
+			go func() {
+				defer conn.Close()
+				var msg conduit.Message
+				for json.NewDecoder(conn).Decode(&msg) == nil {
+				}
+			}()
+		}
+	}()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	clientCfg.HandshakeTimeout = 100 * time.Millisecond
+	clientCfg.ReconnectDelay = 10 * time.Millisecond
+	c := client.NewClient(clientCfg)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.ConnectWithRetry() }()
+	select {
+	case err := <-done:
+		var handshakeErr *conduit.HandshakeError
+		if !errors.As(err, &handshakeErr) || !errors.Is(err, conduit.ErrIncompatiblePeer) {
+			t.Fatalf("Expected a HandshakeError, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ConnectWithRetry kept retrying a server without the handshake")
+	}
+}
+
+// TestHandshakeRequired tests that legacy clients are rejected when the server requires a handshake.
+func TestHandshakeRequired(t *testing.T) {
+	socketPath := "/tmp/conduit_handshake_required_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	serverCfg.RequireHandshake = true
+	srv := server.NewServer(serverCfg)
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	msg, _ := conduit.NewMessage("legacy", "hello")
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var reply conduit.Message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	var hello conduit.Hello
+	if err := reply.UnmarshalPayload(&hello); err != nil {
+		t.Fatalf("Failed to decode hello: %v", err)
+	}
+	if reply.Type != conduit.TypeHello || hello.Error == "" {
+		t.Errorf("Expected hello with an error, got %+v", reply)
+	}
+}
+
+// TestHandshakeLiteralConfig tests that configs built without the Default
+// constructors, and so without codecs, still agree on JSON.
+func TestHandshakeLiteralConfig(t *testing.T) {
+	socketPath := "/tmp/conduit_handshake_literal_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := server.NewServer(&conduit.ServerConfig{
+		SocketPath:     socketPath,
+		Logger:         conduit.NewLogger(conduit.LogError, nil),
+		MaxMessageSize: 1 << 20,
+	})
+	srv.HandleRequest("echo", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+		return msg.Payload, nil
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	c := client.NewClient(&conduit.ClientConfig{
+		SocketPath:     socketPath,
+		Logger:         conduit.NewLogger(conduit.LogError, nil),
+		MaxMessageSize: 1 << 20,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if codec := c.Session().Codec; codec != "json" {
+		t.Errorf("Expected the json codec, got %q", codec)
+	}
+	var reply string
+	if err := c.Call("echo", "hi", &reply); err != nil || reply != "hi" {
+		t.Errorf("Expected 'hi', got %q (%v)", reply, err)
+	}
+}
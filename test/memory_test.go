@@ -0,0 +1,146 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestMemoryTransport tests wiring a server and client together in process.
+func TestMemoryTransport(t *testing.T) {
+	srv := startEchoServer(t, "memory://echo", nil)
+
+	// The server is reachable as soon as Start returns.
+	if reply, err := echoOver(t, "memory://echo", nil); err != nil || reply != "hello" {
+		t.Errorf("Expected 'hello' over memory transport, got %q (%v)", reply, err)
+	}
+
+	cfg := conduit.DefaultServerConfig("memory://echo")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	if err := server.NewServer(cfg).Start(); err == nil {
+		t.Error("Expected a second server on the same memory address to fail")
+	}
+
+	srv.Stop()
+	if _, err := conduit.Dial(context.Background(), "memory://echo", nil); err == nil {
+		t.Error("Expected dialing a stopped server to fail")
+	}
+
+	// The name is free again once the server has stopped.
+	srv = startEchoServer(t, "memory://echo", nil)
+	defer srv.Stop()
+	if addr := srv.Addr(); addr.Network() != "memory" || addr.String() != "echo" {
+		t.Errorf("Unexpected address %s:%s", addr.Network(), addr)
+	}
+}
+
+// TestMemoryTransportBothSides tests that both ends can send at once. Handlers
+// send from the read loop, so the server answering a ping while the client
+// answers a pong only completes if neither write waits for the other side to
+// read.
+func TestMemoryTransportBothSides(t *testing.T) {
+	const pings = 50
+	cfg := conduit.DefaultServerConfig("memory://both-sides")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(cfg)
+	var acks int32
+	done := make(chan struct{})
+	srv.Handle("ping", func(conn *server.Connection, msg *conduit.Message) error {
+		return conn.Send("pong", msg.Payload)
+	})
+	srv.Handle("ack", func(*server.Connection, *conduit.Message) error {
+		if atomic.AddInt32(&acks, 1) == pings {
+			close(done)
+		}
+		return nil
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig("memory://both-sides")
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	clientCfg.Reconnect = false
+	c := client.NewClient(clientCfg)
+	c.Handle("pong", func(c *client.Client, msg *conduit.Message) error {
+		return c.Send("ack", msg.Payload)
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	sendErr := make(chan error, 1)
+	go func() {
+		for i := 0; i < pings; i++ {
+			if err := c.Send("ping", i); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case err := <-sendErr:
+		t.Fatalf("Failed to send ping: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Exchange stalled after %d of %d acks", atomic.LoadInt32(&acks), pings)
+	}
+}
+
+// TestMemoryTransportDeadlines tests that each end's read and write deadlines
+// apply only to its own reads and writes.
+func TestMemoryTransportDeadlines(t *testing.T) {
+	transport, _ := conduit.LookupTransport("memory")
+	l, err := transport.Listen("deadlines", nil)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	clientConn, err := transport.Dial(context.Background(), "deadlines", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+	serverConn := <-accepted
+	defer serverConn.Close()
+
+	// The server's write deadline does not limit the client's read.
+	serverConn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		serverConn.SetWriteDeadline(time.Time{})
+		serverConn.Write([]byte("x"))
+	}()
+	buf := make([]byte, 1)
+	if _, err := clientConn.Read(buf); err != nil {
+		t.Fatalf("Expected the client's read to wait for data, got %v", err)
+	}
+
+	// The client's own read deadline does.
+	clientConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := clientConn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected the read deadline to expire, got %v", err)
+	}
+
+	// A write that does not fit the peer's buffer stops at its write deadline.
+	serverConn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := serverConn.Write(make([]byte, 4<<20)); !errors.Is(err, os.ErrDeadlineExceeded) || n == 0 {
+		t.Errorf("Expected a partial write ending at the deadline, wrote %d bytes (%v)", n, err)
+	}
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+)
+
+// blockingWriter records written message types and holds the first write until released.
+type blockingWriter struct {
+	mu      sync.Mutex
+	written []string
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (w *blockingWriter) write(msg *conduit.Message) error {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.release
+	})
+	w.mu.Lock()
+	w.written = append(w.written, msg.Type)
+	w.mu.Unlock()
+	return nil
+}
+
+// queueBehindGate sends msgs asynchronously once the writer is blocked on a gate
+// message and waits until all of them are queued.
+func queueBehindGate(t *testing.T, o *conduit.Outbox, w *blockingWriter, msgs []*conduit.Message) *sync.WaitGroup {
+	t.Helper()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		o.Send(&conduit.Message{Type: "gate"})
+	}()
+	<-w.started
+
+	for _, msg := range msgs {
+		wg.Add(1)
+		go func(m *conduit.Message) {
+			defer wg.Done()
+			if err := o.Send(m); err != nil {
+				t.Errorf("Failed to send %s: %v", m.Type, err)
+			}
+		}(msg)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for o.Len() != len(msgs) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if o.Len() != len(msgs) {
+		t.Fatalf("Expected %d queued messages, got %d", len(msgs), o.Len())
+	}
+	return &wg
+}
+
+// TestOutboxPriorityOrder tests that higher priority messages are written first.
+func TestOutboxPriorityOrder(t *testing.T) {
+	w := newBlockingWriter()
+	o := conduit.NewOutbox(w.write)
+	defer o.Close()
+
+	wg := queueBehindGate(t, o, w, []*conduit.Message{
+		{Type: "bulk", Priority: conduit.PriorityLow},
+		{Type: "normal", Priority: conduit.PriorityNormal},
+		{Type: "cancel", Priority: conduit.PriorityUrgent},
+		{Type: "status", Priority: conduit.PriorityHigh},
+	})
+	close(w.release)
+	wg.Wait()
+
+	expected := []string{"gate", "cancel", "status", "normal", "bulk"}
+	for i, typ := range expected {
+		if w.written[i] != typ {
+			t.Fatalf("Expected write order %v, got %v", expected, w.written)
+		}
+	}
+}
+
+// TestOutboxStarvation tests that low priority messages are eventually written under constant high priority load.
+func TestOutboxStarvation(t *testing.T) {
+	w := newBlockingWriter()
+	o := conduit.NewOutbox(w.write)
+	defer o.Close()
+
+	msgs := []*conduit.Message{{Type: "bulk", Priority: conduit.PriorityLow}}
+	for i := 0; i < 50; i++ {
+		msgs = append(msgs, &conduit.Message{Type: "urgent", Priority: conduit.PriorityUrgent})
+	}
+	wg := queueBehindGate(t, o, w, msgs)
+	close(w.release)
+	wg.Wait()
+
+	for i, typ := range w.written {
+		if typ == "bulk" {
+			if i == len(w.written)-1 {
+				t.Errorf("Expected low priority message to overtake the urgent backlog")
+			}
+			return
+		}
+	}
+	t.Error("Low priority message was never written")
+}
+
+// TestOutboxClose tests that sending on a closed outbox fails.
+func TestOutboxClose(t *testing.T) {
+	o := conduit.NewOutbox(func(*conduit.Message) error { return nil })
+	o.Close()
+
+	if err := o.Send(&conduit.Message{Type: "late"}); !errors.Is(err, conduit.ErrConnectionClosed) {
+		t.Errorf("Expected ErrConnectionClosed, got %v", err)
+	}
+}
+
+// TestOutboxDropsExpired tests that expired messages are not written.
+func TestOutboxDropsExpired(t *testing.T) {
+	written := make(chan string, 1)
+	o := conduit.NewOutbox(func(msg *conduit.Message) error {
+		written <- msg.Type
+		return nil
+	})
+	defer o.Close()
+
+	stale, _ := conduit.NewMessage("stale", nil, conduit.WithDeadline(time.Now().Add(-time.Second)))
+	if err := o.Send(stale); !errors.Is(err, conduit.ErrMessageExpired) {
+		t.Errorf("Expected ErrMessageExpired, got %v", err)
+	}
+
+	select {
+	case typ := <-written:
+		t.Errorf("Expected expired message to be dropped, but %s was written", typ)
+	default:
+	}
+}
@@ -0,0 +1,236 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/proxy"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestProxy tests forwarding, rewriting and dropping messages through the proxy.
+func TestProxy(t *testing.T) {
+	for _, codec := range []string{"json", "gob"} {
+		t.Run(codec, func(t *testing.T) {
+			serverPath := "/tmp/conduit_proxy_server_" + codec + ".sock"
+			proxyPath := "/tmp/conduit_proxy_" + codec + ".sock"
+			defer os.RemoveAll(serverPath)
+			defer os.RemoveAll(proxyPath)
+
+			serverCfg := conduit.DefaultServerConfig(serverPath)
+			serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+			serverCfg.Codecs = []string{codec}
+			serverCfg.CompressionThreshold = 16
+			srv := server.NewServer(serverCfg)
+			srv.Handle("echo", func(conn *server.Connection, msg *conduit.Message) error {
+				var text string
+				if err := msg.UnmarshalPayload(&text); err != nil {
+					return err
+				}
+				return conn.Send("echo", strings.Repeat(text, 8))
+			})
+			if err := srv.Start(); err != nil {
+				t.Fatalf("Failed to start server: %v", err)
+			}
+			defer srv.Stop()
+
+			var mu sync.Mutex
+			var seen []string
+			proxyCfg := proxy.DefaultConfig(proxyPath, serverPath)
+			proxyCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+			p := proxy.New(proxyCfg)
+			p.Use(
+				func(conn *proxy.Conn, dir proxy.Direction, msg *conduit.Message) *conduit.Message {
+					mu.Lock()
+					seen = append(seen, dir.String()+" "+msg.Type+" "+string(msg.Payload))
+					mu.Unlock()
+					return msg
+				},
+				proxy.Match(proxy.ToServer, []string{"secret.*"}, proxy.Drop()),
+				proxy.Match(proxy.ToServer, []string{"echo"}, proxy.Rewrite(func(msg *conduit.Message) *conduit.Message {
+					msg.Payload = json.RawMessage(`"rewritten"`)
+					return msg
+				})),
+			)
+			if err := p.Start(); err != nil {
+				t.Fatalf("Failed to start proxy: %v", err)
+			}
+			defer p.Stop()
+
+			clientCfg := conduit.DefaultClientConfig(proxyPath)
+			clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+			clientCfg.Reconnect = false
+			c := client.NewClient(clientCfg)
+			received := make(chan string, 2)
+			c.HandleDefault(func(_ *client.Client, msg *conduit.Message) error {
+				var text string
+				msg.UnmarshalPayload(&text)
+				received <- msg.Type + ":" + text
+				return nil
+			})
+			if err := c.Connect(); err != nil {
+				t.Fatalf("Client failed to connect: %v", err)
+			}
+			defer c.Close()
+
+			if got := c.Session().Codec; got != codec {
+				t.Errorf("Expected the peers to negotiate %s, got %s", codec, got)
+			}
+			if err := c.Send("secret.token", "hunter2"); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			if err := c.Send("echo", "original"); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+
+			select {
+			case got := <-received:
+				if want := "echo:" + strings.Repeat("rewritten", 8); got != want {
+					t.Errorf("Expected %q, got %q", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Timeout waiting for echo")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			want := []string{
+				`client->server secret.token "hunter2"`,
+				`client->server echo "original"`,
+				`server->client echo "` + strings.Repeat("rewritten", 8) + `"`,
+			}
+			if strings.Join(seen, "\n") != strings.Join(want, "\n") {
+				t.Errorf("Expected the proxy to see\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(seen, "\n"))
+			}
+		})
+	}
+}
+
+// stallTransport dials connections only once release is closed. Every dial is
+// announced on started, and the far end of each connection it returns is sent
+// on peers.
+type stallTransport struct {
+	started chan struct{}
+	release chan struct{}
+	peers   chan net.Conn
+}
+
+func newStallTransport() *stallTransport {
+	return &stallTransport{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+		peers:   make(chan net.Conn, 10),
+	}
+}
+
+func (s *stallTransport) Listen(string, *tls.Config) (net.Listener, error) {
+	return nil, errors.New("stall transport cannot listen")
+}
+
+func (s *stallTransport) Dial(ctx context.Context, _ string, _ *tls.Config) (net.Conn, error) {
+	s.started <- struct{}{}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.release:
+	}
+	local, remote := net.Pipe()
+	s.peers <- remote
+	return local, nil
+}
+
+// startStallProxy starts a proxy on proxyPath whose target is dialed through
+// transport, registered under scheme.
+func startStallProxy(t *testing.T, scheme, proxyPath string, transport *stallTransport, dialTimeout time.Duration) *proxy.Proxy {
+	t.Helper()
+	conduit.RegisterTransport(scheme, transport)
+	cfg := proxy.DefaultConfig(proxyPath, scheme+"://target")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.DialTimeout = dialTimeout
+	p := proxy.New(cfg)
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	return p
+}
+
+// expectClosed fails the test unless the peer closes conn within timeout.
+func expectClosed(t *testing.T, conn net.Conn, timeout time.Duration) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+// TestProxyDialTimeout tests that targets are dialed concurrently and that a
+// dial that does not finish in time closes the client connection.
+func TestProxyDialTimeout(t *testing.T) {
+	proxyPath := "/tmp/conduit_proxy_dial.sock"
+	defer os.RemoveAll(proxyPath)
+
+	transport := newStallTransport()
+	p := startStallProxy(t, "stall-timeout", proxyPath, transport, 500*time.Millisecond)
+	defer p.Stop()
+
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", proxyPath)
+		if err != nil {
+			t.Fatalf("Failed to connect to proxy: %v", err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+
+	// Both dials are in progress at once: the first does not hold back the second.
+	for i := range clients {
+		select {
+		case <-transport.started:
+		case <-time.After(time.Second):
+			t.Fatalf("Dial %d did not start while dial 1 was pending", i+1)
+		}
+	}
+	for _, conn := range clients {
+		expectClosed(t, conn, 2*time.Second)
+	}
+}
+
+// TestProxyStopDuringDial tests that a connection whose dial finishes after
+// Stop is closed instead of being proxied.
+func TestProxyStopDuringDial(t *testing.T) {
+	proxyPath := "/tmp/conduit_proxy_stop_dial.sock"
+	defer os.RemoveAll(proxyPath)
+
+	transport := newStallTransport()
+	p := startStallProxy(t, "stall-stop", proxyPath, transport, 5*time.Second)
+
+	conn, err := net.Dial("unix", proxyPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case <-transport.started:
+	case <-time.After(time.Second):
+		t.Fatal("Proxy did not dial the target")
+	}
+
+	p.Stop()
+	close(transport.release)
+	server := <-transport.peers
+	defer server.Close()
+
+	expectClosed(t, server, time.Second)
+	expectClosed(t, conn, time.Second)
+}
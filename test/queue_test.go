@@ -0,0 +1,361 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/conduittest"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// TestQueueDeliversEachJobOnce tests that each enqueued job is processed by exactly one worker.
+func TestQueueDeliversEachJobOnce(t *testing.T) {
+	socketPath := "/tmp/conduit_queue_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+	srv.DeclareQueue("jobs", server.DefaultQueueConfig())
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	const total = 20
+	var mu sync.Mutex
+	seen := make(map[int]int)
+	done := make(chan struct{}, total)
+
+	for _, name := range []string{"w1", "w2"} {
+		name := name
+		clientCfg := conduit.DefaultClientConfig(socketPath)
+		clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+		w := client.NewClient(clientCfg)
+		w.Subscribe("jobs", 2, func(_ *client.Client, job *conduit.Job) error {
+			var n int
+			if err := job.UnmarshalPayload(&n); err != nil {
+				return err
+			}
+			mu.Lock()
+			seen[n]++
+			mu.Unlock()
+			done <- struct{}{}
+			return nil
+		})
+		if err := w.Connect(); err != nil {
+			t.Fatalf("Worker %s failed to connect: %v", name, err)
+		}
+		defer w.Close()
+	}
+
+	producerCfg := conduit.DefaultClientConfig(socketPath)
+	producerCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	producer := client.NewClient(producerCfg)
+	if err := producer.Connect(); err != nil {
+		t.Fatalf("Producer failed to connect: %v", err)
+	}
+	defer producer.Close()
+
+	for i := 0; i < total; i++ {
+		id, err := producer.Enqueue("jobs", i)
+		if err != nil {
+			t.Fatalf("Failed to enqueue job: %v", err)
+		}
+		if id == "" {
+			t.Fatalf("Expected a job ID for job %d", i)
+		}
+	}
+
+	for i := 0; i < total; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for jobs, processed %d of %d", i, total)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < total; i++ {
+		if seen[i] != 1 {
+			t.Errorf("Expected job %d to be processed once, got %d", i, seen[i])
+		}
+	}
+}
+
+// TestQueueEnqueueUnknown tests that enqueueing to an undeclared queue fails for
+// the producer instead of being dropped on the server.
+func TestQueueEnqueueUnknown(t *testing.T) {
+	socketPath := "/tmp/conduit_queue_unknown_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	producer := client.NewClient(clientCfg)
+	if err := producer.Connect(); err != nil {
+		t.Fatalf("Producer failed to connect: %v", err)
+	}
+	defer producer.Close()
+
+	_, err := producer.Enqueue("missing", "job")
+	var remote *conduit.RemoteError
+	if !errors.As(err, &remote) || remote.Code != conduit.CodeInvalidArgument {
+		t.Fatalf("Expected an invalid_argument RemoteError, got %v", err)
+	}
+}
+
+// TestQueueDeadLetter tests that a job which keeps failing is moved to the dead-letter queue.
+func TestQueueDeadLetter(t *testing.T) {
+	socketPath := "/tmp/conduit_queue_dlq_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+
+	queueCfg := server.DefaultQueueConfig()
+	queueCfg.MaxRetries = 2
+	q := srv.DeclareQueue("poison", queueCfg)
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	w := client.NewClient(clientCfg)
+
+	attempts := make(chan int, 10)
+	w.Subscribe("poison", 1, func(_ *client.Client, job *conduit.Job) error {
+		attempts <- job.Attempt
+		return errors.New("cannot process")
+	})
+	if err := w.Connect(); err != nil {
+		t.Fatalf("Worker failed to connect: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := q.Enqueue("bad"); err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Errorf("Expected attempt %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for attempt %d", want)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for q.DeadLetter().Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := q.DeadLetter().Len(); n != 1 {
+		t.Errorf("Expected 1 job in dead-letter queue, got %d", n)
+	}
+	if n := q.Len() + q.InFlight(); n != 0 {
+		t.Errorf("Expected queue to be empty, got %d jobs", n)
+	}
+}
+
+// TestQueueRedeliverOnDisconnect tests that jobs held by a disconnected worker go to another worker.
+func TestQueueRedeliverOnDisconnect(t *testing.T) {
+	socketPath := "/tmp/conduit_queue_redeliver_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+	q := srv.DeclareQueue("jobs", server.DefaultQueueConfig())
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	clientCfg.Reconnect = false
+
+	stuck := client.NewClient(clientCfg)
+	taken := make(chan struct{}, 1)
+	stuck.Subscribe("jobs", 1, func(_ *client.Client, job *conduit.Job) error {
+		taken <- struct{}{}
+		select {} // never acknowledges
+	})
+	if err := stuck.Connect(); err != nil {
+		t.Fatalf("Worker failed to connect: %v", err)
+	}
+
+	if _, err := q.Enqueue("work"); err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	select {
+	case <-taken:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for first worker to take the job")
+	}
+
+	healthy := client.NewClient(clientCfg)
+	redelivered := make(chan int, 1)
+	healthy.Subscribe("jobs", 1, func(_ *client.Client, job *conduit.Job) error {
+		redelivered <- job.Attempt
+		return nil
+	})
+	if err := healthy.Connect(); err != nil {
+		t.Fatalf("Worker failed to connect: %v", err)
+	}
+	defer healthy.Close()
+
+	stuck.Close()
+
+	select {
+	case attempt := <-redelivered:
+		if attempt != 2 {
+			t.Errorf("Expected redelivery attempt 2, got %d", attempt)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timeout waiting for job to be redelivered")
+	}
+}
+
+// TestQueueVisibilityTimeout tests that a job which is not acknowledged in time is redelivered.
+func TestQueueVisibilityTimeout(t *testing.T) {
+	socketPath := "/tmp/conduit_queue_visibility_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	serverCfg := conduit.DefaultServerConfig(socketPath)
+	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(serverCfg)
+
+	queueCfg := server.DefaultQueueConfig()
+	queueCfg.VisibilityTimeout = 100 * time.Millisecond
+	q := srv.DeclareQueue("slow", queueCfg)
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	clientCfg := conduit.DefaultClientConfig(socketPath)
+	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	w := client.NewClient(clientCfg)
+
+	attempts := make(chan int, 2)
+	w.Subscribe("slow", 2, func(_ *client.Client, job *conduit.Job) error {
+		attempts <- job.Attempt
+		if job.Attempt == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return nil
+	})
+	if err := w.Connect(); err != nil {
+		t.Fatalf("Worker failed to connect: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := q.Enqueue("work"); err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	for want := 1; want <= 2; want++ {
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Errorf("Expected attempt %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for attempt %d", want)
+		}
+	}
+}
+
+// TestQueueStalledWorker tests that a worker that stops reading holds back
+// neither producers nor the other workers.
+func TestQueueStalledWorker(t *testing.T) {
+	h := conduittest.NewHarness(t, nil)
+	h.Server.DeclareQueue("jobs", server.DefaultQueueConfig())
+
+	// The stalled worker is one end of a net.Pipe, which has no buffer: once it
+	// stops reading, every job sent to it blocks.
+	local, remote := net.Pipe()
+	defer local.Close()
+	go h.Server.ServeConn(remote)
+	encoder := json.NewEncoder(local)
+	decoder := json.NewDecoder(local)
+	subscribe := conduittest.NewMessage(t, conduit.TypeQueueSubscribe, conduit.QueueSubscribe{Queue: "jobs", Prefetch: 10})
+	done := conduittest.NewMessage(t, "subscribed", nil)
+	done.ExpectReply = true
+	for _, msg := range []*conduit.Message{
+		conduittest.NewMessage(t, conduit.TypeHello, conduit.NewHello([]string{"json"}, nil)), subscribe, done,
+	} {
+		if err := encoder.Encode(msg); err != nil {
+			t.Fatalf("Failed to send '%s': %v", msg.Type, err)
+		}
+		// The hello and the unhandled request are answered; the subscription is
+		// handled before the request.
+		if msg != subscribe {
+			var reply conduit.Message
+			if err := decoder.Decode(&reply); err != nil {
+				t.Fatalf("Failed to read the reply to '%s': %v", msg.Type, err)
+			}
+		}
+	}
+
+	processed := make(chan int, 10)
+	w := h.Client(nil)
+	w.Subscribe("jobs", 10, func(_ *client.Client, job *conduit.Job) error {
+		var n int
+		job.UnmarshalPayload(&n)
+		processed <- n
+		return nil
+	})
+	// A round trip makes sure the server has handled the subscription.
+	var remoteErr *conduit.RemoteError
+	if err := w.Request(context.Background(), "subscribed", nil, nil); !errors.As(err, &remoteErr) {
+		t.Fatalf("Expected an unknown_type error, got %v", err)
+	}
+
+	producer := h.Client(nil)
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := producer.EnqueueContext(ctx, "jobs", i)
+		cancel()
+		if err != nil {
+			t.Fatalf("Enqueue %d was held back: %v", i, err)
+		}
+	}
+
+	// Round robin gives every other job to the stalled worker.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-processed:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Healthy worker processed %d of 2 jobs", i)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/record"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// setupRecordedServer registers the handlers of the server under test. greeting
+// changes the reply to "greet" so a replay can detect the difference.
+func setupRecordedServer(greeting string) func(*server.Server) {
+	return func(srv *server.Server) {
+		srv.HandleRequest("greet", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+			var name string
+			if err := msg.UnmarshalPayload(&name); err != nil {
+				return nil, err
+			}
+			return greeting + ", " + name, nil
+		})
+		srv.HandleRequest("fail", func(*server.Connection, *conduit.Message) (interface{}, error) {
+			return nil, errors.New("always fails")
+		})
+		srv.Handle("ping", func(conn *server.Connection, msg *conduit.Message) error {
+			return conn.Send("pong", map[string]int{"seq": 1})
+		})
+	}
+}
+
+// TestRecordAndReplay tests recording a server's traffic and replaying it against another server.
+func TestRecordAndReplay(t *testing.T) {
+	socketPath := "/tmp/conduit_record_test.sock"
+	defer os.RemoveAll(socketPath)
+	recording := filepath.Join(t.TempDir(), "traffic.jsonl")
+
+	rec, err := record.Create(recording)
+	if err != nil {
+		t.Fatalf("Failed to create recording: %v", err)
+	}
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		setupRecordedServer("Hello")(srv)
+		srv.Tap(rec.Tap)
+	})
+
+	c := connectRPCClient(t, socketPath)
+	var reply string
+	if err := c.Request(context.Background(), "greet", "alice", &reply); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	c.Request(context.Background(), "fail", nil, nil)
+	pongs := make(chan struct{}, 1)
+	c.Handle("pong", func(*client.Client, *conduit.Message) error {
+		pongs <- struct{}{}
+		return nil
+	})
+	if err := c.Send("ping", nil); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	<-pongs
+	c.Close()
+	srv.Stop()
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Failed to close recording: %v", err)
+	}
+
+	entries, err := record.Load(recording)
+	if err != nil {
+		t.Fatalf("Failed to load recording: %v", err)
+	}
+	inbound := 0
+	for _, e := range entries {
+		if e.Direction == record.Inbound {
+			inbound++
+		}
+	}
+	if inbound != 3 {
+		t.Fatalf("Expected 3 inbound entries, got %d", inbound)
+	}
+
+	cfg := record.DefaultReplayConfig(socketPath)
+	cfg.Speed = 0
+	cfg.Settle = 100 * time.Millisecond
+
+	same := startRPCServer(t, socketPath, setupRecordedServer("Hello"))
+	report, err := record.Replay(context.Background(), entries, cfg)
+	same.Stop()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if !report.OK() || report.Sent != 3 || report.Compared != 3 {
+		t.Errorf("Expected a clean replay of 3 messages, got %+v", report)
+	}
+
+	changed := startRPCServer(t, socketPath, setupRecordedServer("Hi"))
+	report, err = record.Replay(context.Background(), entries, cfg)
+	changed.Stop()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(report.Diffs) != 1 {
+		t.Fatalf("Expected 1 diff, got %+v", report.Diffs)
+	}
+	diff := report.Diffs[0]
+	if diff.Kind != record.DiffMismatch || diff.Request != "greet" ||
+		string(diff.Expected) != `"Hello, alice"` || string(diff.Actual) != `"Hi, alice"` {
+		t.Errorf("Unexpected diff: %s", diff)
+	}
+}
+
+// TestRecorderFormat tests that recordings round-trip through the reader.
+func TestRecorderFormat(t *testing.T) {
+	var buf bytes.Buffer
+	rec := record.NewRecorder(&buf)
+	msg, _ := conduit.NewMessage("greet", "bob", conduit.WithID("m1"))
+	if err := rec.Record("conn-1", record.Inbound, msg); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	e, err := record.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatalf("Failed to read entry: %v", err)
+	}
+	if e.Conn != "conn-1" || e.Direction != record.Inbound || e.Message.ID != "m1" || string(e.Message.Payload) != `"bob"` {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+}
+
+// TestRecorderRedaction tests that header values holding credentials are kept
+// out of recordings unless redaction is turned off.
+func TestRecorderRedaction(t *testing.T) {
+	msg, _ := conduit.NewMessage("login", nil,
+		conduit.WithHeader(conduit.HeaderAuthorization, "Bearer abc"),
+		conduit.WithHeader("session_token", "xyz"),
+		conduit.WithHeader(conduit.HeaderTraceID, "t1"))
+
+	record1 := func(configure func(*record.Recorder)) map[string]string {
+		t.Helper()
+		var buf bytes.Buffer
+		rec := record.NewRecorder(&buf)
+		if configure != nil {
+			configure(rec)
+		}
+		rec.Record("conn-1", record.Inbound, msg)
+		rec.Close()
+		e, err := record.NewReader(&buf).Next()
+		if err != nil {
+			t.Fatalf("Failed to read entry: %v", err)
+		}
+		return e.Message.Headers
+	}
+
+	headers := record1(nil)
+	if headers[conduit.HeaderAuthorization] != record.Redacted || headers["session_token"] != record.Redacted || headers[conduit.HeaderTraceID] != "t1" {
+		t.Errorf("Expected credentials to be redacted by default, got %v", headers)
+	}
+	if msg.Headers[conduit.HeaderAuthorization] != "Bearer abc" {
+		t.Errorf("Expected the recorded message to be left untouched, got %v", msg.Headers)
+	}
+
+	headers = record1(func(rec *record.Recorder) { rec.RedactHeaders("TRACE") })
+	if headers[conduit.HeaderAuthorization] != "Bearer abc" || headers[conduit.HeaderTraceID] != record.Redacted {
+		t.Errorf("Expected only the trace header to be redacted, got %v", headers)
+	}
+
+	headers = record1(func(rec *record.Recorder) { rec.RedactHeaders() })
+	if headers[conduit.HeaderAuthorization] != "Bearer abc" || headers["session_token"] != "xyz" {
+		t.Errorf("Expected no redaction, got %v", headers)
+	}
+}
@@ -0,0 +1,622 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/conduittest"
+	"github.com/crazywolf132/conduit/server"
+)
+
+func startRPCServer(t *testing.T, socketPath string, setup func(*server.Server)) *server.Server {
+	t.Helper()
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	srv := server.NewServer(cfg)
+	setup(srv)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	return srv
+}
+
+func connectRPCClient(t *testing.T, socketPath string) *client.Client {
+	t.Helper()
+	cfg := conduit.DefaultClientConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	c := client.NewClient(cfg)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	return c
+}
+
+// TestRequestReply tests unary requests, handler errors and requests without a handler.
+func TestRequestReply(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleRequest("add", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+			var nums []int
+			if err := msg.UnmarshalPayload(&nums); err != nil {
+				return nil, err
+			}
+			sum := 0
+			for _, n := range nums {
+				sum += n
+			}
+			return sum, nil
+		})
+		srv.HandleRequest("fail", func(_ *server.Connection, _ *conduit.Message) (interface{}, error) {
+			return nil, &conduit.RemoteError{Code: "not_found", Message: "no such thing"}
+		})
+		srv.Handle("legacy", func(conn *server.Connection, msg *conduit.Message) error {
+			return conn.Reply(msg, "answered")
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var sum int
+	if err := c.Request(ctx, "add", []int{1, 2, 3}, &sum); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if sum != 6 {
+		t.Errorf("Expected 6, got %d", sum)
+	}
+
+	var answer string
+	if err := c.Request(ctx, "legacy", nil, &answer); err != nil || answer != "answered" {
+		t.Errorf("Expected reply from plain handler, got %q (%v)", answer, err)
+	}
+
+	var remote *conduit.RemoteError
+	err := c.Request(ctx, "fail", nil, nil)
+	if !errors.As(err, &remote) || remote.Code != "not_found" {
+		t.Errorf("Expected RemoteError 'not_found', got %v", err)
+	}
+
+	err = c.Request(ctx, "missing", nil, nil)
+	if !errors.As(err, &remote) || remote.Code != conduit.CodeUnknownType {
+		t.Errorf("Expected RemoteError '%s', got %v", conduit.CodeUnknownType, err)
+	}
+}
+
+// TestServerStreamingRPC tests consuming a stream of responses and a stream that fails midway.
+func TestServerStreamingRPC(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_stream_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleServerStream("count", func(_ *server.Connection, msg *conduit.Message, stream *server.ServerStream) error {
+			var n int
+			msg.UnmarshalPayload(&n)
+			for i := 1; i <= n; i++ {
+				if err := stream.Send(i); err != nil {
+					return err
+				}
+			}
+			if n > 3 {
+				return errors.New("too many")
+			}
+			return nil
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := c.RequestStream(ctx, "count", 3)
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	var got []int
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		got = append(got, n)
+	}
+	if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("Expected [1 2 3], got %v", got)
+	}
+
+	stream, err = c.RequestStream(ctx, "count", 5)
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	received := 0
+	for {
+		var n int
+		if err = stream.Recv(&n); err != nil {
+			break
+		}
+		received++
+	}
+	var remote *conduit.RemoteError
+	if received != 5 || !errors.As(err, &remote) || !strings.Contains(remote.Message, "too many") {
+		t.Errorf("Expected 5 responses then a RemoteError, got %d and %v", received, err)
+	}
+}
+
+// TestStreamingRPCCancel tests that canceling the caller's context stops the server handler.
+func TestStreamingRPCCancel(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_cancel_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	stopped := make(chan error, 1)
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleServerStream("tail", func(_ *server.Connection, _ *conduit.Message, stream *server.ServerStream) error {
+			for i := 0; ; i++ {
+				select {
+				case <-stream.Context().Done():
+					stopped <- stream.Context().Err()
+					return stream.Context().Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+				stream.Send(i)
+			}
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.RequestStream(ctx, "tail", nil)
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := stream.Recv(nil); err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+	}
+	cancel()
+
+	if err := stream.Recv(nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled after cancel, got %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected handler context to be canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for handler to stop")
+	}
+}
+
+// TestBidiStreamingRPC tests exchanging messages in both directions within one call.
+func TestBidiStreamingRPC(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_bidi_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleBidiStream("upper", func(_ *server.Connection, stream *server.ServerStream) error {
+			for {
+				var s string
+				if err := stream.Recv(&s); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.Send(strings.ToUpper(s)); err != nil {
+					return err
+				}
+			}
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := c.OpenBidiStream(ctx, "upper")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	for _, word := range []string{"alpha", "beta"} {
+		if err := stream.Send(word); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		var got string
+		if err := stream.Recv(&got); err != nil || got != strings.ToUpper(word) {
+			t.Errorf("Expected %q, got %q (%v)", strings.ToUpper(word), got, err)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend failed: %v", err)
+	}
+	if err := stream.Recv(nil); err != io.EOF {
+		t.Errorf("Expected io.EOF after CloseSend, got %v", err)
+	}
+}
+
+// TestRequestCancelOutcome tests that canceling a request cancels the handler's context and
+// reports whether the handler stopped or completed anyway.
+func TestRequestCancelOutcome(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_cancel_outcome_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	handlerErrs := make(chan error, 1)
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleRequest("slow", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+			<-msg.Context().Done()
+			handlerErrs <- msg.Context().Err()
+			return nil, msg.Context().Err()
+		})
+		srv.HandleRequest("stubborn", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+			<-msg.Context().Done()
+			return "done anyway", nil
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	for _, tc := range []struct {
+		msgType string
+		outcome string
+	}{
+		{"slow", conduit.CancelStopped},
+		{"stubborn", conduit.CancelCompleted},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := c.Request(ctx, tc.msgType, nil, nil)
+		cancel()
+
+		var canceled *conduit.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected CanceledError wrapping DeadlineExceeded for '%s', got %v", tc.msgType, err)
+		}
+		if canceled.Outcome != tc.outcome {
+			t.Errorf("Expected outcome '%s' for '%s', got '%s'", tc.outcome, tc.msgType, canceled.Outcome)
+		}
+	}
+
+	select {
+	case err := <-handlerErrs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected handler context to be canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timeout waiting for handler to observe cancellation")
+	}
+}
+
+// TestServerInitiatedRequest tests that the server can call request handlers registered on a client.
+func TestServerInitiatedRequest(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_server_request_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	conns := make(chan *server.Connection, 1)
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.Handle("register", func(conn *server.Connection, _ *conduit.Message) error {
+			conns <- conn
+			return nil
+		})
+	})
+	defer srv.Stop()
+
+	cfg := conduit.DefaultClientConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	c := client.NewClient(cfg)
+	c.HandleRequest("status", func(_ *client.Client, msg *conduit.Message) (interface{}, error) {
+		var verbose bool
+		msg.UnmarshalPayload(&verbose)
+		if verbose {
+			return nil, errors.New("verbose status unavailable")
+		}
+		return "healthy", nil
+	})
+	c.HandleRequest("hang", func(_ *client.Client, msg *conduit.Message) (interface{}, error) {
+		<-msg.Context().Done()
+		return nil, msg.Context().Err()
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("register", nil); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	var conn *server.Connection
+	select {
+	case conn = <-conns:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for registration")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var status string
+	if err := conn.Request(ctx, "status", false, &status); err != nil || status != "healthy" {
+		t.Errorf("Expected 'healthy', got %q (%v)", status, err)
+	}
+
+	var remote *conduit.RemoteError
+	if err := conn.Request(ctx, "status", true, &status); !errors.As(err, &remote) {
+		t.Errorf("Expected RemoteError from client handler, got %v", err)
+	}
+	if err := conn.Request(ctx, "unknown", nil, nil); !errors.As(err, &remote) || remote.Code != conduit.CodeUnknownType {
+		t.Errorf("Expected RemoteError '%s', got %v", conduit.CodeUnknownType, err)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	var canceled *conduit.CanceledError
+	if err := conn.Request(short, "hang", nil, nil); !errors.As(err, &canceled) || canceled.Outcome != conduit.CancelStopped {
+		t.Errorf("Expected CanceledError with outcome '%s', got %v", conduit.CancelStopped, err)
+	}
+}
+
+// TestStreamingRPCOverflow tests that a call whose consumer falls too far behind
+// fails instead of buffering every response.
+func TestStreamingRPCOverflow(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_overflow_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	sent := make(chan struct{})
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleServerStream("flood", func(_ *server.Connection, _ *conduit.Message, stream *server.ServerStream) error {
+			defer close(sent)
+			for i := 0; i < 2*conduit.CallBufferSize; i++ {
+				if err := stream.Send(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		srv.HandleRequest("ping", func(*server.Connection, *conduit.Message) (interface{}, error) {
+			return "pong", nil
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := c.RequestStream(ctx, "flood", nil)
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	defer stream.Close()
+
+	<-sent
+	// Replies arrive in order, so once the ping is answered every item has been
+	// received without being consumed.
+	if err := c.Request(ctx, "ping", nil, nil); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	received := 0
+	for {
+		if err = stream.Recv(nil); err != nil {
+			break
+		}
+		received++
+	}
+	if !errors.Is(err, conduit.ErrCallOverflow) || received != conduit.CallBufferSize {
+		t.Errorf("Expected %d responses then ErrCallOverflow, got %d and %v", conduit.CallBufferSize, received, err)
+	}
+}
+
+// TestDuplicateRequestID tests that a request reusing the ID of a running call is
+// rejected instead of replacing it.
+func TestDuplicateRequestID(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_duplicate_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	release := make(chan struct{})
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.HandleRequest("hold", func(*server.Connection, *conduit.Message) (interface{}, error) {
+			<-release
+			return "done", nil
+		})
+	})
+	defer srv.Stop()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	expectReply := func(m *conduit.Message) { m.ExpectReply = true }
+	enc := json.NewEncoder(conn)
+	for i := 0; i < 2; i++ {
+		msg, _ := conduit.NewMessage("hold", nil, conduit.WithID("same"), expectReply)
+		if err := enc.Encode(msg); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	dec := json.NewDecoder(conn)
+	var reply conduit.Message
+	if err := dec.Decode(&reply); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if reply.Type != conduit.TypeRPCError || conduit.DecodeRemoteError(&reply).Code != conduit.CodeInvalidArgument {
+		t.Fatalf("Expected the duplicate to be rejected, got %s %s", reply.Type, reply.Payload)
+	}
+
+	close(release)
+	if err := dec.Decode(&reply); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if reply.Type != conduit.TypeRPCReply || reply.ReplyTo != "same" {
+		t.Errorf("Expected the first call to complete, got %s", reply.Type)
+	}
+}
+
+// TestServerRequestConnectionLost tests that client handlers for server requests
+// are canceled when the connection drops, and that their replies do not reach
+// the connection that replaces it.
+func TestServerRequestConnectionLost(t *testing.T) {
+	socketPath := "/tmp/conduit_rpc_connection_lost_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	conns := make(chan *server.Connection, 2)
+	replies := make(chan *server.Connection, 8)
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.Handle("register", func(conn *server.Connection, _ *conduit.Message) error {
+			conns <- conn
+			return nil
+		})
+		srv.Tap(func(conn *server.Connection, msg *conduit.Message, inbound bool) {
+			if inbound && msg.Type == conduit.TypeRPCReply {
+				replies <- conn
+			}
+		})
+	})
+	defer srv.Stop()
+
+	cfg := conduit.DefaultClientConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.ReconnectDelay = 10 * time.Millisecond
+	c := client.NewClient(cfg)
+	started := make(chan struct{}, 1)
+	stopped := make(chan error, 1)
+	c.HandleRequest("hang", func(_ *client.Client, msg *conduit.Message) (interface{}, error) {
+		started <- struct{}{}
+		<-msg.Context().Done()
+		stopped <- msg.Context().Err()
+		return "late", nil
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	register := func() *server.Connection {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for c.Send("register", nil) != nil {
+			if time.Now().After(deadline) {
+				t.Fatal("Client did not reconnect")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case conn := <-conns:
+			return conn
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for registration")
+			return nil
+		}
+	}
+
+	first := register()
+	go first.Request(context.Background(), "hang", nil, nil)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the client handler to start")
+	}
+
+	first.Close()
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the handler context to be canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Client handler was not canceled when the connection dropped")
+	}
+
+	second := register()
+	select {
+	case conn := <-replies:
+		if conn == second {
+			t.Error("Reply to a request from the lost connection reached the new one")
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestRequestFromHandler tests sending requests from plain message handlers,
+// which must not wait on the read loop that delivers the reply.
+func TestRequestFromHandler(t *testing.T) {
+	h := conduittest.NewHarness(t, nil)
+	h.Server.HandleRequest("ack", func(*server.Connection, *conduit.Message) (interface{}, error) {
+		return "ok", nil
+	})
+	h.Server.Handle("hello", func(conn *server.Connection, msg *conduit.Message) error {
+		ctx, cancel := context.WithTimeout(msg.Context(), 2*time.Second)
+		defer cancel()
+		var name string
+		if err := conn.Request(ctx, "whoami", nil, &name); err != nil {
+			return err
+		}
+		return conn.Send("greeting", "Hello, "+name)
+	})
+
+	c := h.Client(nil)
+	c.HandleRequest("whoami", func(*client.Client, *conduit.Message) (interface{}, error) {
+		return "Ada", nil
+	})
+	results := make(chan string, 1)
+	c.Handle("greeting", func(c *client.Client, msg *conduit.Message) error {
+		var greeting, ack string
+		if err := msg.UnmarshalPayload(&greeting); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(msg.Context(), 2*time.Second)
+		defer cancel()
+		if err := c.Request(ctx, "ack", nil, &ack); err != nil {
+			results <- err.Error()
+			return err
+		}
+		results <- greeting + " " + ack
+		return nil
+	})
+
+	if err := c.Send("hello", nil); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	select {
+	case got := <-results:
+		if got != "Hello, Ada ok" {
+			t.Errorf("Expected 'Hello, Ada ok', got %q", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Handler requests did not complete")
+	}
+}
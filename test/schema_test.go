@@ -0,0 +1,136 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/schema"
+	"github.com/crazywolf132/conduit/server"
+)
+
+type CreateUser struct {
+	Name string   `json:"name" schema:"required,minLength=1,maxLength=16"`
+	Age  int      `json:"age" schema:"min=0,max=150"`
+	Role string   `json:"role" schema:"enum=admin|member"`
+	Tags []string `json:"tags,omitempty" schema:"maxItems=2"`
+}
+
+// TestSchemaValidate tests validation of payloads against parsed and derived schemas.
+func TestSchemaValidate(t *testing.T) {
+	parsed := schema.MustParse(`{
+		"type": "object",
+		"required": ["name"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"items": {"type": "array", "items": {"type": "integer", "minimum": 1}}
+		}
+	}`)
+
+	if err := parsed.Validate([]byte(`{"name":"bob","items":[1,2]}`)); err != nil {
+		t.Errorf("Expected valid payload, got %v", err)
+	}
+
+	err := parsed.Validate([]byte(`{"name":"Bob","items":[1,0,"x"],"extra":true}`))
+	var verr *schema.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	want := []string{"/extra", "/items/1", "/items/2", "/name"}
+	if len(verr.Errors) != len(want) {
+		t.Fatalf("Expected errors at %v, got %+v", want, verr.Errors)
+	}
+	for i, path := range want {
+		if verr.Errors[i].Path != path {
+			t.Errorf("Expected error %d at %s, got %s", i, path, verr.Errors[i].Path)
+		}
+	}
+
+	derived := schema.MustFromType(CreateUser{})
+	if err := derived.Validate([]byte(`{"name":"alice","age":30,"role":"admin"}`)); err != nil {
+		t.Errorf("Expected valid payload, got %v", err)
+	}
+	err = derived.Validate([]byte(`{"age":200,"role":"owner","tags":["a","b","c"]}`))
+	if !errors.As(err, &verr) || len(verr.Errors) != 4 {
+		t.Errorf("Expected 4 errors, got %v", err)
+	}
+
+	if _, err := schema.Parse([]byte(`{"type":"text"}`)); err == nil {
+		t.Error("Expected an unknown type to be rejected")
+	}
+}
+
+// TestSchemaEnforcement tests that invalid payloads are rejected before reaching handlers.
+func TestSchemaEnforcement(t *testing.T) {
+	socketPath := "/tmp/conduit_schema_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	handled := make(chan string, 2)
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		srv.SetSchema("user.create", schema.MustFromType(CreateUser{}))
+		srv.HandleRequest("user.create", func(_ *server.Connection, msg *conduit.Message) (interface{}, error) {
+			var req CreateUser
+			if err := msg.UnmarshalPayload(&req); err != nil {
+				return nil, err
+			}
+			handled <- req.Name
+			return "created", nil
+		})
+	})
+	defer srv.Stop()
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	var reply string
+	if err := c.Call("user.create", &CreateUser{Name: "alice", Role: "member"}, &reply); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if reply != "created" {
+		t.Errorf("Expected 'created', got %q", reply)
+	}
+
+	err := c.Call("user.create", map[string]interface{}{"age": -1, "role": "member"}, &reply)
+	var remote *conduit.RemoteError
+	if !errors.As(err, &remote) || remote.Code != conduit.CodeInvalidArgument {
+		t.Fatalf("Expected RemoteError '%s', got %v", conduit.CodeInvalidArgument, err)
+	}
+	fields := schema.FieldErrors(remote)
+	if len(fields) != 2 || fields[0].Path != "/name" || fields[1].Path != "/age" {
+		t.Errorf("Expected errors at /name and /age, got %+v", fields)
+	}
+
+	if len(handled) != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", len(handled))
+	}
+}
+
+// TestSchemaInvalidPattern tests that a hand-built schema with a malformed
+// pattern is rejected when registered and never panics during validation.
+func TestSchemaInvalidPattern(t *testing.T) {
+	bad := &schema.Schema{Type: schema.TypeString, Pattern: "(unclosed"}
+
+	srv := server.NewServer(conduit.DefaultServerConfig("/tmp/conduit_schema_pattern_test.sock"))
+	if err := srv.SetSchema("name", bad); err == nil || !strings.Contains(err.Error(), "invalid pattern") {
+		t.Errorf("Expected an invalid pattern error, got %v", err)
+	}
+	if srv.Schema("name") != nil {
+		t.Error("Expected the invalid schema not to be registered")
+	}
+
+	err := bad.Validate([]byte(`"value"`))
+	if _, ok := err.(*schema.ValidationError); !ok {
+		t.Errorf("Expected a ValidationError for the uncompiled schema, got %v", err)
+	}
+
+	good := &schema.Schema{Type: schema.TypeString, Pattern: "^[a-z]+$"}
+	if err := srv.SetSchema("name", good); err != nil {
+		t.Fatalf("Failed to register schema: %v", err)
+	}
+	if err := good.Validate([]byte(`"ABC"`)); err == nil {
+		t.Error("Expected the pattern to be enforced")
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/crazywolf132/conduit"
 	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/conduittest"
 	"github.com/crazywolf132/conduit/server"
 )
 
@@ -43,78 +44,19 @@ func TestServerStartStop(t *testing.T) {
 
 // TestServerBroadcast tests that the server can broadcast messages to all clients.
 func TestServerBroadcast(t *testing.T) {
-	socketPath := "/tmp/conduit_broadcast_test.sock"
-	defer os.RemoveAll(socketPath)
-
-	serverCfg := conduit.DefaultServerConfig(socketPath)
-	serverCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
-	srv := server.NewServer(serverCfg)
-
-	// No special handler needed, we will broadcast manually.
-	if err := srv.Start(); err != nil {
-		t.Fatalf("Failed to start server: %v", err)
-	}
-	defer srv.Stop()
+	h := conduittest.NewHarness(t, nil)
+	h.Client(nil)
+	h.Client(nil)
 
-	clientCfg := conduit.DefaultClientConfig(socketPath)
-	clientCfg.Logger = conduit.NewLogger(conduit.LogError, nil)
-
-	c1 := client.NewClient(clientCfg)
-	if err := c1.Connect(); err != nil {
-		t.Fatalf("Client1 failed to connect: %v", err)
-	}
-	defer c1.Close()
-
-	c2 := client.NewClient(clientCfg)
-	if err := c2.Connect(); err != nil {
-		t.Fatalf("Client2 failed to connect: %v", err)
-	}
-	defer c2.Close()
-
-	received1 := make(chan string, 1)
-	c1.Handle("announcement", func(_ *client.Client, msg *conduit.Message) error {
-		var m string
-		if err := msg.UnmarshalPayload(&m); err != nil {
-			return err
-		}
-		received1 <- m
-		return nil
-	})
-
-	received2 := make(chan string, 1)
-	c2.Handle("announcement", func(_ *client.Client, msg *conduit.Message) error {
-		var m string
-		if err := msg.UnmarshalPayload(&m); err != nil {
-			return err
-		}
-		received2 <- m
-		return nil
-	})
-
-	// Give clients some time to set handlers
-	time.Sleep(100 * time.Millisecond)
-
-	// Broadcast message
-	if err := srv.Broadcast("announcement", "Hello, everyone!"); err != nil {
+	if err := h.Server.Broadcast("announcement", "Hello, everyone!"); err != nil {
 		t.Fatalf("Failed to broadcast: %v", err)
 	}
 
-	select {
-	case msg := <-received1:
-		if msg != "Hello, everyone!" {
-			t.Errorf("Client1 expected 'Hello, everyone!', got '%s'", msg)
-		}
-	case <-time.After(time.Second):
-		t.Error("Timeout waiting for client1 to receive broadcast")
-	}
-
-	select {
-	case msg := <-received2:
-		if msg != "Hello, everyone!" {
-			t.Errorf("Client2 expected 'Hello, everyone!', got '%s'", msg)
+	for i, msg := range h.ExpectBroadcast(t, "announcement", time.Second) {
+		var m string
+		if err := msg.UnmarshalPayload(&m); err != nil || m != "Hello, everyone!" {
+			t.Errorf("Client%d expected 'Hello, everyone!', got '%s' (%v)", i+1, m, err)
 		}
-	case <-time.After(time.Second):
-		t.Error("Timeout waiting for client2 to receive broadcast")
 	}
 }
 
@@ -248,3 +190,59 @@ func TestServerContext(t *testing.T) {
 		t.Error("Timeout waiting for get_context response")
 	}
 }
+
+// TestServerDropsExpiredMessages tests that expired messages are dropped and counted, and live
+// messages surface their deadline through the handler context.
+func TestServerDropsExpiredMessages(t *testing.T) {
+	socketPath := "/tmp/conduit_expired_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	s := server.NewServer(cfg)
+
+	handled := make(chan time.Time, 2)
+	s.Handle("work", func(conn *server.Connection, msg *conduit.Message) error {
+		deadline, _ := msg.Context().Deadline()
+		handled <- deadline
+		return nil
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	stale, _ := conduit.NewMessage("work", "stale", conduit.WithDeadline(time.Now().Add(-time.Second)))
+	fresh, _ := conduit.NewMessage("work", "fresh", conduit.WithTTL(time.Minute))
+	for _, msg := range []*conduit.Message{stale, fresh} {
+		if err := json.NewEncoder(conn).Encode(msg); err != nil {
+			t.Fatalf("Failed to send message to server: %v", err)
+		}
+	}
+
+	select {
+	case deadline := <-handled:
+		want, _ := fresh.Deadline()
+		if !deadline.Equal(want) {
+			t.Errorf("Expected handler deadline %v, got %v", want, deadline)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for fresh message")
+	}
+
+	select {
+	case <-handled:
+		t.Error("Expected expired message not to reach the handler")
+	default:
+	}
+	if n := s.ExpiredMessages(); n != 1 {
+		t.Errorf("Expected 1 expired message, got %d", n)
+	}
+}
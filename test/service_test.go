@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/server"
+)
+
+type ArithArgs struct {
+	A, B int
+}
+
+type ArithReply struct {
+	Result int
+}
+
+type Arith struct{}
+
+func (Arith) Add(_ context.Context, args *ArithArgs) (*ArithReply, error) {
+	return &ArithReply{Result: args.A + args.B}, nil
+}
+
+func (Arith) Divide(_ context.Context, args *ArithArgs) (*ArithReply, error) {
+	if args.B == 0 {
+		return nil, errors.New("divide by zero")
+	}
+	return &ArithReply{Result: args.A / args.B}, nil
+}
+
+type badService struct{}
+
+func (badService) Add(a, b int) int {
+	return a + b
+}
+
+// TestRegisterService tests calling methods of a service registered by reflection.
+func TestRegisterService(t *testing.T) {
+	socketPath := "/tmp/conduit_service_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startRPCServer(t, socketPath, func(srv *server.Server) {
+		if err := srv.RegisterService("", Arith{}); err != nil {
+			t.Fatalf("Failed to register service: %v", err)
+		}
+	})
+	defer srv.Stop()
+
+	methods := srv.ServiceMethods()
+	if len(methods) != 2 || methods[0] != "Arith.Add" || methods[1] != "Arith.Divide" {
+		t.Errorf("Expected [Arith.Add Arith.Divide], got %v", methods)
+	}
+
+	c := connectRPCClient(t, socketPath)
+	defer c.Close()
+
+	var reply ArithReply
+	if err := c.Call("Arith.Add", &ArithArgs{A: 2, B: 3}, &reply); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if reply.Result != 5 {
+		t.Errorf("Expected 5, got %d", reply.Result)
+	}
+
+	var remote *conduit.RemoteError
+	if err := c.Call("Arith.Divide", &ArithArgs{A: 1}, &reply); !errors.As(err, &remote) || remote.Message != "divide by zero" {
+		t.Errorf("Expected RemoteError 'divide by zero', got %v", err)
+	}
+	if err := c.Call("Arith.Divide", "not an object", &reply); !errors.As(err, &remote) || remote.Code != conduit.CodeInvalidArgument {
+		t.Errorf("Expected RemoteError '%s', got %v", conduit.CodeInvalidArgument, err)
+	}
+}
+
+// TestRegisterServiceValidation tests that invalid services are rejected at registration.
+func TestRegisterServiceValidation(t *testing.T) {
+	srv := server.NewServer(conduit.DefaultServerConfig("/tmp/conduit_service_validation_test.sock"))
+
+	if err := srv.RegisterService("Bad", badService{}); err == nil {
+		t.Error("Expected registration of a method with the wrong signature to fail")
+	}
+	if err := srv.RegisterService("Arith", &Arith{}); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	if err := srv.RegisterService("Arith", Arith{}); err == nil {
+		t.Error("Expected duplicate registration to fail")
+	}
+}
@@ -0,0 +1,216 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+func startStreamServer(t *testing.T, socketPath string) *server.Server {
+	t.Helper()
+	cfg := conduit.DefaultServerConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.MaxMessageSize = 128 * 1024
+	return server.NewServer(cfg)
+}
+
+func connectStreamClient(t *testing.T, socketPath string, setup func(*client.Client)) *client.Client {
+	t.Helper()
+	cfg := conduit.DefaultClientConfig(socketPath)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.MaxMessageSize = 128 * 1024
+	c := client.NewClient(cfg)
+	if setup != nil {
+		setup(c)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Client failed to connect: %v", err)
+	}
+	return c
+}
+
+// TestStreamLargePayload tests that a stream far larger than MaxMessageSize arrives intact
+// while normal messages keep flowing on the same connection.
+func TestStreamLargePayload(t *testing.T) {
+	socketPath := "/tmp/conduit_stream_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 512*1024) // 8 MiB
+	want := sha256.Sum256(data)
+
+	srv := startStreamServer(t, socketPath)
+	sums := make(chan [32]byte, 1)
+	srv.HandleStream("upload", func(_ *server.Connection, stream *conduit.Stream) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, stream); err != nil {
+			return err
+		}
+		var sum [32]byte
+		copy(sum[:], h.Sum(nil))
+		sums <- sum
+		return nil
+	})
+	pings := make(chan struct{}, 1)
+	srv.Handle("ping", func(_ *server.Connection, _ *conduit.Message) error {
+		pings <- struct{}{}
+		return nil
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	c := connectStreamClient(t, socketPath, nil)
+	defer c.Close()
+
+	stream, err := c.OpenStream("upload")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+
+	half := len(data) / 2
+	if _, err := stream.Write(data[:half]); err != nil {
+		t.Fatalf("Failed to write stream: %v", err)
+	}
+	if err := c.Send("ping", nil); err != nil {
+		t.Fatalf("Failed to send message during stream: %v", err)
+	}
+	select {
+	case <-pings:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for message sent during stream")
+	}
+	if _, err := stream.Write(data[half:]); err != nil {
+		t.Fatalf("Failed to write stream: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+
+	select {
+	case sum := <-sums:
+		if sum != want {
+			t.Error("Expected streamed data to arrive intact")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for stream handler")
+	}
+}
+
+// TestStreamHandlerError tests that a failing stream handler is reported to the writer.
+func TestStreamHandlerError(t *testing.T) {
+	socketPath := "/tmp/conduit_stream_error_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startStreamServer(t, socketPath)
+	srv.HandleStream("upload", func(_ *server.Connection, stream *conduit.Stream) error {
+		io.Copy(io.Discard, stream)
+		return errors.New("disk full")
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	c := connectStreamClient(t, socketPath, nil)
+	defer c.Close()
+
+	stream, err := c.OpenStream("upload")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	stream.Write([]byte("some data"))
+
+	err = stream.Close()
+	var streamErr *conduit.StreamError
+	if !errors.As(err, &streamErr) || !strings.Contains(streamErr.Reason, "disk full") {
+		t.Errorf("Expected StreamError carrying the handler error, got %v", err)
+	}
+
+	unknown, err := c.OpenStream("unknown")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := unknown.Close(); !errors.As(err, &streamErr) {
+		t.Errorf("Expected StreamError for stream without handler, got %v", err)
+	}
+}
+
+// TestStreamCancel tests that a writer canceling a stream unblocks the handler and
+// that a server can stream to a client.
+func TestStreamCancel(t *testing.T) {
+	socketPath := "/tmp/conduit_stream_cancel_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	srv := startStreamServer(t, socketPath)
+	readErrs := make(chan error, 1)
+	srv.HandleStream("upload", func(_ *server.Connection, stream *conduit.Stream) error {
+		_, err := io.Copy(io.Discard, stream)
+		readErrs <- err
+		return err
+	})
+	srv.Handle("download", func(conn *server.Connection, _ *conduit.Message) error {
+		stream, err := conn.OpenStream("file")
+		if err != nil {
+			return err
+		}
+		stream.Write([]byte("from the server"))
+		return stream.Close()
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	received := make(chan string, 1)
+	c := connectStreamClient(t, socketPath, func(c *client.Client) {
+		c.HandleStream("file", func(_ *client.Client, stream *conduit.Stream) error {
+			data, err := io.ReadAll(stream)
+			received <- string(data)
+			return err
+		})
+	})
+	defer c.Close()
+
+	stream, err := c.OpenStream("upload")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	stream.Write([]byte("partial"))
+	stream.CloseWithError(errors.New("canceled by user"))
+
+	select {
+	case err := <-readErrs:
+		var streamErr *conduit.StreamError
+		if !errors.As(err, &streamErr) {
+			t.Errorf("Expected handler read to fail with StreamError, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for canceled stream")
+	}
+	if _, err := stream.Write([]byte("more")); err == nil {
+		t.Error("Expected write to canceled stream to fail")
+	}
+
+	if err := c.Send("download", nil); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	select {
+	case data := <-received:
+		if data != "from the server" {
+			t.Errorf("Expected 'from the server', got '%s'", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for server stream")
+	}
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crazywolf132/conduit"
+	"github.com/crazywolf132/conduit/client"
+	"github.com/crazywolf132/conduit/server"
+)
+
+// startEchoServer starts a server on address answering "echo" requests with
+// the client's certificate name, if any.
+func startEchoServer(t *testing.T, address string, configure func(*conduit.ServerConfig)) *server.Server {
+	t.Helper()
+	cfg := conduit.DefaultServerConfig(address)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	if configure != nil {
+		configure(cfg)
+	}
+	srv := server.NewServer(cfg)
+	srv.HandleRequest("echo", func(conn *server.Connection, msg *conduit.Message) (interface{}, error) {
+		var text string
+		if err := msg.UnmarshalPayload(&text); err != nil {
+			return nil, err
+		}
+		if certs := conn.PeerCertificates(); len(certs) > 0 {
+			text += " from " + certs[0].Subject.CommonName
+		}
+		return text, nil
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	return srv
+}
+
+func echoOver(t *testing.T, address string, configure func(*conduit.ClientConfig)) (string, error) {
+	t.Helper()
+	cfg := conduit.DefaultClientConfig(address)
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	cfg.Reconnect = false
+	if configure != nil {
+		configure(cfg)
+	}
+	c := client.NewClient(cfg)
+	if err := c.Connect(); err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	var reply string
+	err := c.Call("echo", "hello", &reply)
+	return reply, err
+}
+
+// TestTransportAddresses tests serving the protocol over unix:// and tcp:// addresses.
+func TestTransportAddresses(t *testing.T) {
+	socketPath := "/tmp/conduit_transport_test.sock"
+	defer os.RemoveAll(socketPath)
+
+	unixSrv := startEchoServer(t, "unix://"+socketPath, nil)
+	if reply, err := echoOver(t, socketPath, nil); err != nil || reply != "hello" {
+		t.Errorf("Expected 'hello' over a plain socket path, got %q (%v)", reply, err)
+	}
+	unixSrv.Stop()
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket file to be removed, got %v", err)
+	}
+
+	tcpSrv := startEchoServer(t, "tcp://127.0.0.1:0", nil)
+	defer tcpSrv.Stop()
+	if reply, err := echoOver(t, "tcp://"+tcpSrv.Addr().String(), nil); err != nil || reply != "hello" {
+		t.Errorf("Expected 'hello' over tcp, got %q (%v)", reply, err)
+	}
+
+	if _, err := conduit.ParseAddress("carrier-pigeon://coop"); err == nil {
+		t.Error("Expected an unknown scheme to be rejected")
+	}
+}
+
+// TestTLSTransport tests the tls transport with client certificate authentication.
+func TestTLSTransport(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, true)
+	writeCert(t, dir, "server", ca, caKey, false)
+	writeCert(t, dir, "client", ca, caKey, false)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	srv := startEchoServer(t, "tls://127.0.0.1:0", func(cfg *conduit.ServerConfig) {
+		tlsConfig, err := conduit.ServerTLSConfig(file("server.pem"), file("server.key"), file("ca.pem"))
+		if err != nil {
+			t.Fatalf("Failed to load server TLS config: %v", err)
+		}
+		cfg.TLSConfig = tlsConfig
+	})
+	defer srv.Stop()
+	address := "tls://" + srv.Addr().String()
+
+	reply, err := echoOver(t, address, func(cfg *conduit.ClientConfig) {
+		tlsConfig, err := conduit.ClientTLSConfig(file("ca.pem"), file("client.pem"), file("client.key"))
+		if err != nil {
+			t.Fatalf("Failed to load client TLS config: %v", err)
+		}
+		cfg.TLSConfig = tlsConfig
+	})
+	if err != nil || reply != "hello from client" {
+		t.Errorf("Expected 'hello from client', got %q (%v)", reply, err)
+	}
+
+	_, err = echoOver(t, address, func(cfg *conduit.ClientConfig) {
+		tlsConfig, err := conduit.ClientTLSConfig(file("ca.pem"), "", "")
+		if err != nil {
+			t.Fatalf("Failed to load client TLS config: %v", err)
+		}
+		cfg.TLSConfig = tlsConfig
+	})
+	if err == nil {
+		t.Error("Expected a client without a certificate to be rejected")
+	}
+
+	cfg := conduit.DefaultServerConfig("tls://127.0.0.1:0")
+	cfg.Logger = conduit.NewLogger(conduit.LogError, nil)
+	if err := server.NewServer(cfg).Start(); err == nil {
+		t.Error("Expected a tls server without a certificate to fail to start")
+	}
+}
+
+// writeCert writes name.pem and name.key to dir, signed by parent or
+// self-signed if parent is nil.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
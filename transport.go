@@ -0,0 +1,187 @@
+package conduit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Transport opens the connections conduit messages travel over. Transports are
+// selected by the scheme of an address, e.g. "tcp" in "tcp://127.0.0.1:9000".
+//
+// config is the TLS configuration from the server or client config. Transports
+// that do not use TLS ignore it.
+type Transport interface {
+	Listen(addr string, config *tls.Config) (net.Listener, error)
+	Dial(ctx context.Context, addr string, config *tls.Config) (net.Conn, error)
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]Transport{
+		"unix":   netTransport{network: "unix"},
+		"tcp":    netTransport{network: "tcp"},
+		"tls":    tlsTransport{},
+		"memory": &memoryTransport{listeners: make(map[string]*memoryListener)},
+	}
+)
+
+// RegisterTransport makes a transport available under scheme. Registering a
+// transport with an existing scheme replaces it.
+func RegisterTransport(scheme string, t Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[scheme] = t
+}
+
+// LookupTransport returns the transport registered under scheme, if any.
+func LookupTransport(scheme string) (Transport, bool) {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	t, ok := transports[scheme]
+	return t, ok
+}
+
+// Address is a parsed transport address.
+type Address struct {
+	Scheme string
+	Addr   string
+}
+
+// ParseAddress parses an address of the form scheme://addr, such as
+// "unix:///tmp/app.sock", "tcp://127.0.0.1:9000", "tls://host:9443" or
+// "memory://app" for connections within the process. A plain path without a
+// scheme is a Unix socket, so existing socket paths keep working.
+// Returns an error if no transport is registered for the scheme.
+func ParseAddress(address string) (Address, error) {
+	scheme, addr, ok := strings.Cut(address, "://")
+	if !ok {
+		scheme, addr = "unix", address
+	}
+	if addr == "" {
+		return Address{}, fmt.Errorf("invalid address %q: missing %s address", address, scheme)
+	}
+	if _, exists := LookupTransport(scheme); !exists {
+		return Address{}, fmt.Errorf("invalid address %q: unknown transport %q", address, scheme)
+	}
+	return Address{Scheme: scheme, Addr: addr}, nil
+}
+
+func (a Address) String() string {
+	return a.Scheme + "://" + a.Addr
+}
+
+// Listen listens on address using the transport selected by its scheme.
+func Listen(address string, config *tls.Config) (net.Listener, error) {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	t, _ := LookupTransport(addr.Scheme)
+	return t.Listen(addr.Addr, config)
+}
+
+// Dial connects to address using the transport selected by its scheme.
+func Dial(ctx context.Context, address string, config *tls.Config) (net.Conn, error) {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	t, _ := LookupTransport(addr.Scheme)
+	return t.Dial(ctx, addr.Addr, config)
+}
+
+// netTransport serves the plain stream networks of package net.
+type netTransport struct {
+	network string
+}
+
+func (t netTransport) Listen(addr string, _ *tls.Config) (net.Listener, error) {
+	return net.Listen(t.network, addr)
+}
+
+func (t netTransport) Dial(ctx context.Context, addr string, _ *tls.Config) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, t.network, addr)
+}
+
+// tlsTransport runs TLS over TCP. Servers need a certificate in their config;
+// setting ClientAuth and ClientCAs there requires clients to authenticate with
+// certificates of their own.
+type tlsTransport struct{}
+
+func (tlsTransport) Listen(addr string, config *tls.Config) (net.Listener, error) {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return nil, errors.New("tls transport requires a server certificate in TLSConfig")
+	}
+	return tls.Listen("tcp", addr, config)
+}
+
+func (tlsTransport) Dial(ctx context.Context, addr string, config *tls.Config) (net.Conn, error) {
+	d := tls.Dialer{Config: config}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// ServerTLSConfig loads a server certificate and key for the tls transport. If
+// clientCAFile is not empty, clients must present a certificate signed by one of
+// the CAs it contains.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig builds the client side of the tls transport. If caFile is not
+// empty, the server's certificate is verified against the CAs it contains
+// instead of the system roots. certFile and keyFile, if set, hold the client
+// certificate presented to servers that require one.
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
@@ -1,30 +1,208 @@
 package conduit
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 )
 
+// ErrMessageExpired is returned when a message passes its deadline before it could be sent.
+var ErrMessageExpired = errors.New("message expired")
+
+// Standard header keys. Headers are free-form, these are just the common ones.
+const (
+	HeaderTraceID       = "trace-id"
+	HeaderAuthorization = "authorization"
+	HeaderTenantID      = "tenant-id"
+)
+
+// DefaultContentType is the content type of payloads created by NewMessage.
+const DefaultContentType = "application/json"
+
 // Message represents a structured message that can be sent over the Unix socket
+//
+// Besides Type and Payload, the envelope carries metadata that keeps cross-cutting
+// concerns out of payload structs: a unique ID, the send Timestamp in Unix
+// nanoseconds, the payload ContentType and free-form Headers. All of these are
+// optional on the wire, so messages from peers that only send type and payload
+// still decode.
+//
+// ExpiresAt is an optional deadline in Unix nanoseconds. Expired messages are
+// dropped by the sender's queues and by the receiver before dispatch. Encoding
+// names the compression algorithm applied to Payload, if any.
+//
+// ReplyTo correlates a message with the request it answers, and ExpectReply
+// marks a request whose sender is waiting for a reply.
 type Message struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	ID          string            `json:"id,omitempty"`
+	Type        string            `json:"type"`
+	Payload     json.RawMessage   `json:"payload"`
+	Timestamp   int64             `json:"timestamp,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Priority    Priority          `json:"priority,omitempty"`
+	ExpiresAt   int64             `json:"expires_at,omitempty"`
+	Encoding    string            `json:"encoding,omitempty"`
+	ReplyTo     string            `json:"reply_to,omitempty"`
+	ExpectReply bool              `json:"expect_reply,omitempty"`
+
+	ctx context.Context
+}
+
+// SendOption configures an outgoing message before it is sent.
+type SendOption func(*Message)
+
+// WithPriority sets the priority class of an outgoing message.
+func WithPriority(p Priority) SendOption {
+	return func(m *Message) {
+		m.Priority = p
+	}
+}
+
+// WithID overrides the generated ID of an outgoing message.
+func WithID(id string) SendOption {
+	return func(m *Message) {
+		m.ID = id
+	}
+}
+
+// WithReplyTo marks an outgoing message as a reply to the request with the given ID.
+func WithReplyTo(id string) SendOption {
+	return func(m *Message) {
+		m.ReplyTo = id
+	}
+}
+
+// WithHeader sets a single header on an outgoing message.
+func WithHeader(key, value string) SendOption {
+	return func(m *Message) {
+		m.SetHeader(key, value)
+	}
+}
+
+// WithHeaders sets several headers on an outgoing message.
+func WithHeaders(headers map[string]string) SendOption {
+	return func(m *Message) {
+		for k, v := range headers {
+			m.SetHeader(k, v)
+		}
+	}
 }
 
-// NewMessage creates a new Message with the given type and payload
-func NewMessage(msgType string, payload interface{}) (*Message, error) {
+// WithContentType overrides the content type of an outgoing message.
+func WithContentType(contentType string) SendOption {
+	return func(m *Message) {
+		m.ContentType = contentType
+	}
+}
+
+// WithDeadline sets an absolute deadline after which the message is dropped.
+func WithDeadline(t time.Time) SendOption {
+	return func(m *Message) {
+		m.ExpiresAt = t.UnixNano()
+	}
+}
+
+// WithTTL sets a deadline of d from now after which the message is dropped.
+func WithTTL(d time.Duration) SendOption {
+	return WithDeadline(time.Now().Add(d))
+}
+
+// NewMessage creates a new Message with the given type and payload, applying any options
+func NewMessage(msgType string, payload interface{}, opts ...SendOption) (*Message, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	return &Message{
-		Type:    msgType,
-		Payload: payloadBytes,
-	}, nil
+	msg := &Message{
+		ID:          NewID(),
+		Type:        msgType,
+		Payload:     payloadBytes,
+		Timestamp:   time.Now().UnixNano(),
+		ContentType: DefaultContentType,
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return msg, nil
 }
 
 // UnmarshalPayload unmarshals the message payload into the provided interface
 func (m *Message) UnmarshalPayload(v interface{}) error {
 	return json.Unmarshal(m.Payload, v)
 }
+
+// Header returns the value of the given header, or "" if it is not set.
+func (m *Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// SetHeader sets a header on the message.
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// Time returns the time the message was created, or the zero time if the sender
+// did not include a timestamp.
+func (m *Message) Time() time.Time {
+	if m.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.Timestamp)
+}
+
+// Deadline returns the message deadline, and false if the message never expires.
+func (m *Message) Deadline() (time.Time, bool) {
+	if m.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, m.ExpiresAt), true
+}
+
+// Expired returns true if the message has a deadline that has passed.
+func (m *Message) Expired() bool {
+	return m.ExpiresAt != 0 && time.Now().UnixNano() > m.ExpiresAt
+}
+
+// Context returns the message's context. For received messages it carries the
+// message deadline and is canceled once the handler returns or the connection
+// closes. The returned context is never nil.
+func (m *Message) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the message with its context changed to ctx.
+func (m *Message) WithContext(ctx context.Context) *Message {
+	m2 := *m
+	m2.ctx = ctx
+	return &m2
+}
+
+// MessageContext derives the handler context for a received message from parent,
+// applying the message deadline if it has one.
+func MessageContext(parent context.Context, m *Message) (context.Context, context.CancelFunc) {
+	if deadline, ok := m.Deadline(); ok {
+		return context.WithDeadline(parent, deadline)
+	}
+	return context.WithCancel(parent)
+}
+
+// NewID returns a random identifier suitable for message IDs.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}